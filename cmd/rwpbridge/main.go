@@ -0,0 +1,105 @@
+/*
+   Copyright 2026 SKAARHOJ ApS
+
+   Released under MIT License
+*/
+
+// Command rwpbridge lets systems talk to a Raw Panel in either encoding.
+//
+//	rwpbridge [flags] <panel>
+//
+// It keeps one connection to the panel, in binary if the panel supports
+// it, and accepts systems in ASCII or binary on the listen address.
+// Everything is translated both ways, so legacy ASCII automation can drive
+// Blue Pill panels. Several systems can share the panel, feedback of systems
+// listed with -priority wins over the others.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+
+	helpers "github.com/SKAARHOJ/rawpanel-lib"
+	"github.com/SKAARHOJ/rawpanel-lib/proxy"
+	log "github.com/s00500/env_logger"
+)
+
+func main() {
+	listen := flag.String("listen", ":9923", "Address systems connect to")
+	mode := flag.String("mode", "negotiate", "How the panel encoding is chosen: auto, ASCII, binary or negotiate")
+	record := flag.String("record", "", "Record the panel traffic to this .rwplog file")
+	maxClients := flag.Int("max-clients", 0, "Max number of systems, 0 is unlimited")
+	allow := flag.String("allow", "", "Comma separated IP addresses of the systems allowed to connect, all if empty")
+	priority := flag.String("priority", "", "Comma separated IP addresses of systems whose feedback wins over the others")
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "Usage: rwpbridge [flags] <panel>")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	panel := flag.Arg(0)
+	if _, _, err := net.SplitHostPort(panel); err != nil {
+		panel = net.JoinHostPort(panel, "9923")
+	}
+	protocolMode, err := helpers.ParseProtocolMode(*mode)
+	if err != nil {
+		log.Fatal(err)
+	}
+	panelConfig := &helpers.ConnectToPanelConfig{ProtocolMode: protocolMode}
+	if *record != "" {
+		recorder, err := helpers.CreateRecording(*record, helpers.RecordBinary)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer recorder.Close()
+		panelConfig.Recorder = recorder
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	config := &proxy.Config{
+		PanelConfig: panelConfig,
+		MaxClients:  *maxClients,
+		AllowedIPs:  splitList(*allow),
+		Priority:    priorityOf(splitList(*priority)),
+	}
+	bridge := proxy.New(ctx, panel, config)
+	addr, err := bridge.Listen("tcp", *listen)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Infof("Bridging %s to systems on %s\n", panel, addr)
+
+	<-ctx.Done()
+	bridge.Close()
+}
+
+func splitList(list string) []string {
+	if list == "" {
+		return nil
+	}
+	return strings.Split(list, ",")
+}
+
+// Systems from the given IP addresses get priority 1, all others 0
+func priorityOf(ips []string) func(addr net.Addr) int {
+	return func(addr net.Addr) int {
+		host, _, _ := net.SplitHostPort(addr.String())
+		for _, ip := range ips {
+			if net.ParseIP(host).Equal(net.ParseIP(ip)) {
+				return 1
+			}
+		}
+		return 0
+	}
+}
@@ -0,0 +1,456 @@
+/*
+   Copyright 2026 SKAARHOJ ApS
+
+   Released under MIT License
+*/
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+
+	helpers "github.com/SKAARHOJ/rawpanel-lib"
+	rwp "github.com/SKAARHOJ/rawpanel-lib/ibeam_rawpanel"
+	topology "github.com/SKAARHOJ/rawpanel-lib/topology"
+)
+
+func runInfo(ctx context.Context, opts *options, args []string) error {
+	if len(args) > 0 {
+		return commandUsage(opts)
+	}
+	s, err := connect(ctx, opts)
+	if err != nil {
+		return err
+	}
+	defer s.close()
+
+	// ASCII panels reply with a line per field, so the replies are merged:
+	info := &rwp.PanelInfo{}
+	s.sendCommand(&rwp.Command{SendPanelInfo: true})
+	err = s.collect(ctx, func(msg *rwp.OutboundMessage) bool {
+		if msg.PanelInfo == nil {
+			return false
+		}
+		proto.Merge(info, msg.PanelInfo)
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	if opts.jsonOut {
+		return printJSON(opts, info)
+	}
+	out := opts.stdout
+	fmt.Fprintf(out, "Model:          %s\n", info.Model)
+	fmt.Fprintf(out, "Serial:         %s\n", info.Serial)
+	fmt.Fprintf(out, "Name:           %s\n", info.Name)
+	fmt.Fprintf(out, "Version:        %s\n", info.SoftwareVersion)
+	fmt.Fprintf(out, "Platform:       %s\n", info.Platform)
+	fmt.Fprintf(out, "Panel type:     %s\n", info.PanelType)
+	fmt.Fprintf(out, "Protocol:       %s\n", s.encoding())
+	if info.MaxClients > 0 {
+		fmt.Fprintf(out, "Max clients:    %d\n", info.MaxClients)
+	}
+	if len(info.LockedToIPs) > 0 {
+		fmt.Fprintf(out, "Locked to IPs:  %s\n", strings.Join(info.LockedToIPs, ", "))
+	}
+	fmt.Fprintf(out, "Supports:       %s\n", supportedFeatures(info.RawPanelSupport))
+	return nil
+}
+
+// Names of the features set in RawPanelSupport
+func supportedFeatures(support *rwp.RawPanelSupport) string {
+	if support == nil {
+		return "(not reported)"
+	}
+	features := []string{}
+	fields := support.ProtoReflect().Descriptor().Fields()
+	for i := 0; i < fields.Len(); i++ {
+		field := fields.Get(i)
+		if field.Kind() == protoreflect.BoolKind && support.ProtoReflect().Get(field).Bool() {
+			features = append(features, string(field.Name()))
+		}
+	}
+	if len(features) == 0 {
+		return "(none)"
+	}
+	return strings.Join(features, ", ")
+}
+
+func runTopology(ctx context.Context, opts *options, args []string) error {
+	fs := flag.NewFlagSet("topology", flag.ContinueOnError)
+	svgFile := fs.String("svg", "", "Write the composite SVG to this file (- for stdout) instead of printing the JSON")
+	grid := fs.Bool("grid", false, "Render the SVG as a grid instead of on the panel background")
+	if err := parseCommandFlags(fs, opts, args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return commandUsage(opts)
+	}
+
+	s, err := connect(ctx, opts)
+	if err != nil {
+		return err
+	}
+	defer s.close()
+
+	panelTopology := &rwp.PanelTopology{}
+	s.sendCommand(&rwp.Command{SendPanelTopology: true})
+	err = s.collect(ctx, func(msg *rwp.OutboundMessage) bool {
+		if msg.PanelTopology == nil {
+			return false
+		}
+		proto.Merge(panelTopology, msg.PanelTopology)
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	if panelTopology.Json == "" {
+		return fmt.Errorf("panel sent no topology")
+	}
+
+	if *svgFile == "" {
+		var indented bytes.Buffer
+		if err := json.Indent(&indented, []byte(panelTopology.Json), "", "  "); err != nil {
+			return fmt.Errorf("invalid topology JSON from panel: %w", err)
+		}
+		fmt.Fprintln(opts.stdout, indented.String())
+		return nil
+	}
+
+	var svg string
+	if *grid || panelTopology.Svgbase == "" {
+		svg = topology.GenerateCompositeGridSVG(panelTopology.Json)
+	} else {
+		svg = topology.GenerateCompositeSVG(panelTopology.Json, panelTopology.Svgbase, nil)
+	}
+	if svg == "" {
+		return fmt.Errorf("could not render the topology")
+	}
+	if *svgFile == "-" {
+		_, err := fmt.Fprintln(opts.stdout, svg)
+		return err
+	}
+	return os.WriteFile(*svgFile, []byte(svg), 0644)
+}
+
+func runWatch(ctx context.Context, opts *options, args []string) error {
+	fs := flag.NewFlagSet("watch", flag.ContinueOnError)
+	all := fs.Bool("all", false, "Also print messages other than events, in ASCII form")
+	if err := parseCommandFlags(fs, opts, args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return commandUsage(opts)
+	}
+
+	s, err := connect(ctx, opts)
+	if err != nil {
+		return err
+	}
+	defer s.close()
+	fmt.Fprintf(opts.stdout, "Connected to %s (%s), press Ctrl-C to stop\n", opts.address, s.encoding())
+
+	for {
+		select {
+		case msgs := <-s.fromPanel:
+			now := time.Now().Format("15:04:05.000")
+			for _, msg := range msgs {
+				for _, event := range msg.Events {
+					fmt.Fprintf(opts.stdout, "%s  %s\n", now, describeEvent(event))
+				}
+				if *all {
+					rest := proto.Clone(msg).(*rwp.OutboundMessage)
+					rest.Events = nil
+					for _, line := range helpers.OutboundMessagesToRawPanelASCIIstrings([]*rwp.OutboundMessage{rest}) {
+						fmt.Fprintf(opts.stdout, "%s  %s\n", now, line)
+					}
+				}
+			}
+		case <-s.stopped:
+			return fmt.Errorf("connection to %s closed", opts.address)
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// Readable form of an event, like "HWC 12: down (top)"
+func describeEvent(event *rwp.HWCEvent) string {
+	prefix := fmt.Sprintf("HWC %d: ", event.HWCID)
+	switch {
+	case event.Binary != nil:
+		state := "up"
+		if event.Binary.Pressed {
+			state = "down"
+		}
+		if event.Binary.Edge != rwp.BinaryEvent_UNKNOWN {
+			state += " (" + strings.ToLower(event.Binary.Edge.String()) + ")"
+		}
+		return prefix + state
+	case event.Pulsed != nil:
+		return prefix + fmt.Sprintf("pulse %+d", event.Pulsed.Value)
+	case event.Absolute != nil:
+		return prefix + fmt.Sprintf("absolute %d", event.Absolute.Value)
+	case event.Speed != nil:
+		return prefix + fmt.Sprintf("speed %d", event.Speed.Value)
+	case event.RawAnalog != nil:
+		return prefix + fmt.Sprintf("raw analog %d", event.RawAnalog.Value)
+	}
+	return prefix + strings.Join(helpers.OutboundMessagesToRawPanelASCIIstrings([]*rwp.OutboundMessage{{Events: []*rwp.HWCEvent{event}}}), " ")
+}
+
+func runSend(ctx context.Context, opts *options, args []string) error {
+	if len(args) == 0 {
+		return commandUsage(opts)
+	}
+	lines := args
+	if len(args) == 1 && args[0] == "-" {
+		lines = []string{}
+		scanner := bufio.NewScanner(opts.stdin)
+		for scanner.Scan() {
+			if line := strings.TrimSpace(scanner.Text()); line != "" {
+				lines = append(lines, line)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+	}
+
+	// Converted here and back to ASCII by ConnectToPanel for ASCII panels, so invalid lines are caught either way:
+	msgs := helpers.RawPanelASCIIstringsToInboundMessages(lines)
+	if len(msgs) == 0 {
+		return fmt.Errorf("no valid Raw Panel commands in %q", strings.Join(lines, " "))
+	}
+
+	s, err := connect(ctx, opts)
+	if err != nil {
+		return err
+	}
+	defer s.close()
+
+	// The ACK to our ping tells that everything was processed, replies after that are collected for the wait time:
+	s.send(msgs...)
+	s.send(&rwp.InboundMessage{FlowMessage: rwp.InboundMessage_PING})
+	timer := time.NewTimer(opts.timeout)
+	defer timer.Stop()
+	acked := false
+	for {
+		select {
+		case msgs := <-s.fromPanel:
+			for _, msg := range msgs {
+				if msg.FlowMessage == rwp.OutboundMessage_ACK && !acked {
+					acked = true
+					timer.Reset(opts.waitTime)
+					continue
+				}
+				for _, line := range helpers.OutboundMessagesToRawPanelASCIIstrings([]*rwp.OutboundMessage{msg}) {
+					fmt.Fprintln(opts.stdout, line)
+				}
+			}
+		case <-timer.C:
+			if !acked {
+				return fmt.Errorf("no reply from %s within %s", opts.address, opts.timeout)
+			}
+			return nil
+		case <-s.stopped:
+			return fmt.Errorf("connection to %s closed", opts.address)
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func runBrightness(ctx context.Context, opts *options, args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return commandUsage(opts)
+	}
+	values := []uint32{}
+	for _, arg := range args {
+		value, err := strconv.ParseUint(arg, 10, 32)
+		if err != nil || value > 8 {
+			return fmt.Errorf("brightness must be 0-8, got %q", arg)
+		}
+		values = append(values, uint32(value))
+	}
+	brightness := &rwp.Brightness{LEDs: values[0], OLEDs: values[0]}
+	if len(values) == 2 {
+		brightness.OLEDs = values[1]
+	}
+
+	s, err := connect(ctx, opts)
+	if err != nil {
+		return err
+	}
+	defer s.close()
+	s.sendCommand(&rwp.Command{PanelBrightness: brightness})
+	return s.sync(ctx)
+}
+
+func runSleep(ctx context.Context, opts *options, args []string) error {
+	if len(args) > 1 {
+		return commandUsage(opts)
+	}
+	var cmd *rwp.Command
+	switch {
+	case len(args) == 0:
+		cmd = &rwp.Command{GetSleepTimeout: true}
+	case args[0] == "wake":
+		cmd = &rwp.Command{WakeUp: true}
+	default:
+		minutes, err := strconv.ParseUint(args[0], 10, 32)
+		if err != nil {
+			return fmt.Errorf("sleep timeout must be a number of minutes or \"wake\", got %q", args[0])
+		}
+		cmd = &rwp.Command{SetSleepTimeout: &rwp.SleepTimeout{Value: uint32(minutes)}}
+	}
+
+	s, err := connect(ctx, opts)
+	if err != nil {
+		return err
+	}
+	defer s.close()
+	s.sendCommand(cmd)
+	if !cmd.GetSleepTimeout {
+		return s.sync(ctx)
+	}
+
+	return s.await(ctx, func(msg *rwp.OutboundMessage) bool {
+		if msg.SleepTimeout == nil {
+			return false
+		}
+		if msg.SleepTimeout.Value == 0 {
+			fmt.Fprintln(opts.stdout, "Sleep timeout: never")
+		} else {
+			fmt.Fprintf(opts.stdout, "Sleep timeout: %d minutes\n", msg.SleepTimeout.Value)
+		}
+		return true
+	})
+}
+
+func runReboot(ctx context.Context, opts *options, args []string) error {
+	if len(args) > 0 {
+		return commandUsage(opts)
+	}
+	s, err := connect(ctx, opts)
+	if err != nil {
+		return err
+	}
+	defer s.close()
+
+	s.sendCommand(&rwp.Command{Reboot: true})
+	if err := s.sync(ctx); err != nil {
+		// The panel may well be gone before it answers
+		fmt.Fprintf(opts.stdout, "Reboot sent, no confirmation from the panel: %v\n", err)
+	}
+	return nil
+}
+
+func runNetconfig(ctx context.Context, opts *options, args []string) error {
+	if len(args) == 0 || (args[0] != "get" && args[0] != "set") {
+		return commandUsage(opts)
+	}
+
+	fs := flag.NewFlagSet("netconfig set", flag.ContinueOnError)
+	dhcp := fs.Bool("dhcp", false, "Use DHCP")
+	address := fs.String("address", "", "Static IP address")
+	netmask := fs.String("netmask", "", "Netmask")
+	gateway := fs.String("gateway", "", "Gateway")
+	dns1 := fs.String("dns1", "", "First DNS server")
+	dns2 := fs.String("dns2", "", "Second DNS server")
+	noDefaultRoute := fs.Bool("no-default-route", false, "Don't add a default route")
+	if err := parseCommandFlags(fs, opts, args[1:]); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 || (args[0] == "get" && fs.NFlag() > 0) {
+		return commandUsage(opts)
+	}
+	if args[0] == "set" && fs.NFlag() == 0 {
+		fmt.Fprintln(opts.stdout, "Nothing to set, flags:")
+		fs.PrintDefaults()
+		return errUsage
+	}
+
+	s, err := connect(ctx, opts)
+	if err != nil {
+		return err
+	}
+	defer s.close()
+
+	// Settings not given as flags are kept, so we start from the current config:
+	config := &rwp.NetworkConfig{}
+	s.sendCommand(&rwp.Command{SendNetworkConfig: true})
+	err = s.await(ctx, func(msg *rwp.OutboundMessage) bool {
+		if msg.NetworkConfig == nil {
+			return false
+		}
+		config = msg.NetworkConfig
+		return true
+	})
+	if err != nil {
+		return fmt.Errorf("%w (the panel may not support network settings)", err)
+	}
+
+	if args[0] == "get" {
+		if opts.jsonOut {
+			return printJSON(opts, config)
+		}
+		out := opts.stdout
+		fmt.Fprintf(out, "DHCP:              %v\n", config.Dhcp)
+		fmt.Fprintf(out, "Address:           %s\n", config.Address)
+		fmt.Fprintf(out, "Netmask:           %s\n", config.Netmask)
+		fmt.Fprintf(out, "Gateway:           %s\n", config.Gateway)
+		fmt.Fprintf(out, "DNS:               %s\n", strings.TrimSpace(config.FirstDns+" "+config.SecondDns))
+		fmt.Fprintf(out, "No default route:  %v\n", config.NoDefaultRoute)
+		return nil
+	}
+
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "dhcp":
+			config.Dhcp = *dhcp
+		case "address":
+			config.Address = *address
+		case "netmask":
+			config.Netmask = *netmask
+		case "gateway":
+			config.Gateway = *gateway
+		case "dns1":
+			config.FirstDns = *dns1
+		case "dns2":
+			config.SecondDns = *dns2
+		case "no-default-route":
+			config.NoDefaultRoute = *noDefaultRoute
+		}
+	})
+	s.sendCommand(&rwp.Command{SetNetworkConfig: config})
+	if err := s.sync(ctx); err != nil {
+		fmt.Fprintf(opts.stdout, "Network configuration sent, no confirmation from the panel (it may have changed its address): %v\n", err)
+	}
+	return nil
+}
+
+func printJSON(opts *options, msg proto.Message) error {
+	data, err := protojson.MarshalOptions{Multiline: true}.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(opts.stdout, string(data))
+	return err
+}
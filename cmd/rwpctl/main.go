@@ -0,0 +1,174 @@
+/*
+   Copyright 2026 SKAARHOJ ApS
+
+   Released under MIT License
+*/
+
+// Command rwpctl talks to Raw Panels from the shell.
+//
+//	rwpctl [flags] <panel> <command> [arguments]
+//
+// The panel is an IP address (port 9923 is used if none is given), host:port,
+// or "native" for the hardware server socket on a SKAARHOJ device. Run rwpctl
+// without arguments for the list of commands.
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"sort"
+	"time"
+
+	helpers "github.com/SKAARHOJ/rawpanel-lib"
+)
+
+// Default Raw Panel TCP port
+const defaultPort = "9923"
+
+var errUsage = errors.New("usage")
+
+// Settings from the global flags
+type options struct {
+	address  string
+	mode     helpers.ProtocolMode
+	timeout  time.Duration
+	tls      *tls.Config
+	jsonOut  bool
+	stdout   io.Writer
+	stdin    io.Reader
+	waitTime time.Duration // How long commands wait for replies which may or may not come
+	usage    string        // Usage line of the command
+}
+
+type command struct {
+	usage string
+	help  string
+	run   func(ctx context.Context, opts *options, args []string) error
+}
+
+var commands = map[string]command{
+	"info":       {"info", "Print panel info and supported protocol features", runInfo},
+	"topology":   {"topology [-svg file] [-grid]", "Print the topology JSON, or write the composite SVG", runTopology},
+	"watch":      {"watch [-all]", "Print events until interrupted. With -all, other messages are printed too", runWatch},
+	"send":       {"send <line>... | send -", "Send Raw Panel ASCII lines (or lines from stdin) and print replies", runSend},
+	"brightness": {"brightness <leds> [oleds]", "Set the panel brightness (0-8). OLEDs default to the LED value", runBrightness},
+	"sleep":      {"sleep [minutes | wake]", "Print or set the sleep timeout, or wake the panel up", runSleep},
+	"reboot":     {"reboot", "Reboot the panel", runReboot},
+	"netconfig":  {"netconfig get | netconfig set [flags]", "Print or change the network configuration", runNetconfig},
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	err := run(ctx, os.Args[1:], os.Stdin, os.Stdout)
+	if err == errUsage {
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "rwpctl:", err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, args []string, stdin io.Reader, stdout io.Writer) error {
+	fs := flag.NewFlagSet("rwpctl", flag.ContinueOnError)
+	fs.SetOutput(stdout)
+	mode := fs.String("mode", "auto", "Protocol mode: auto, ASCII, binary or negotiate")
+	timeout := fs.Duration("timeout", 5*time.Second, "Max time for connecting and for replies")
+	useTLS := fs.Bool("tls", false, "Connect with TLS")
+	insecure := fs.Bool("insecure", false, "Don't verify the TLS certificate of the panel")
+	jsonOut := fs.Bool("json", false, "Print info and netconfig as JSON")
+	wait := fs.Duration("wait", 500*time.Millisecond, "Time send waits for replies")
+	fs.Usage = func() { usage(fs) }
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return nil
+		}
+		return errUsage
+	}
+	if fs.NArg() < 2 {
+		usage(fs)
+		return errUsage
+	}
+
+	cmd, exists := commands[fs.Arg(1)]
+	if !exists {
+		fmt.Fprintf(stdout, "Unknown command %q\n\n", fs.Arg(1))
+		usage(fs)
+		return errUsage
+	}
+
+	opts := &options{
+		address:  panelAddress(fs.Arg(0)),
+		timeout:  *timeout,
+		jsonOut:  *jsonOut,
+		stdout:   stdout,
+		stdin:    stdin,
+		waitTime: *wait,
+		usage:    cmd.usage,
+	}
+	var err error
+	if opts.mode, err = helpers.ParseProtocolMode(*mode); err != nil {
+		return err
+	}
+	if *useTLS {
+		opts.tls = &tls.Config{InsecureSkipVerify: *insecure}
+	}
+
+	return cmd.run(ctx, opts, fs.Args()[2:])
+}
+
+// Adds the default port to plain IP addresses and host names
+func panelAddress(address string) string {
+	if address == "native" || address == "host" {
+		return address
+	}
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		return net.JoinHostPort(address, defaultPort)
+	}
+	return address
+}
+
+func usage(fs *flag.FlagSet) {
+	out := fs.Output()
+	fmt.Fprintln(out, "Usage: rwpctl [flags] <panel> <command> [arguments]")
+	fmt.Fprintln(out, "\nCommands:")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(out, "  %-40s %s\n", commands[name].usage, commands[name].help)
+	}
+	fmt.Fprintln(out, "\nFlags:")
+	fs.PrintDefaults()
+	fmt.Fprintln(out, "\nExample: rwpctl 192.168.10.99 send \"HWCc#12=2\" \"HWC#12=36\"")
+}
+
+// Parses the flags of a command. Errors are printed with the usage of the command
+func parseCommandFlags(fs *flag.FlagSet, opts *options, args []string) error {
+	fs.SetOutput(opts.stdout)
+	fs.Usage = func() {
+		fmt.Fprintf(opts.stdout, "Usage: rwpctl [flags] <panel> %s\n", opts.usage)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	return nil
+}
+
+// Prints the usage line of the command
+func commandUsage(opts *options) error {
+	fmt.Fprintf(opts.stdout, "Usage: rwpctl [flags] <panel> %s\n", opts.usage)
+	return errUsage
+}
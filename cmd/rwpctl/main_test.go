@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/SKAARHOJ/rawpanel-lib/emulator"
+	rwp "github.com/SKAARHOJ/rawpanel-lib/ibeam_rawpanel"
+	topology "github.com/SKAARHOJ/rawpanel-lib/topology"
+)
+
+func startEmulator(t *testing.T, encoding emulator.Encoding) (*emulator.Emulator, string) {
+	top := &topology.Topology{
+		HWc: []topology.TopologyHWcomponent{
+			{Id: 1, X: 100, Y: 100, Txt: "Button", Type: 1},
+		},
+		TypeIndex: map[uint32]topology.TopologyHWcTypeDef{
+			1: {W: 100, H: 100, Out: "rgb", In: "b"},
+		},
+	}
+	emu := emulator.New(top, &emulator.Config{Name: "Desk", Encoding: encoding})
+	t.Cleanup(emu.Close)
+	addr, err := emu.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return emu, addr.String()
+}
+
+func rwpctl(stdin string, args ...string) (string, error) {
+	var stdout bytes.Buffer
+	err := run(context.Background(), args, strings.NewReader(stdin), &stdout)
+	return stdout.String(), err
+}
+
+func TestCommands(t *testing.T) {
+	tests := []struct {
+		encoding emulator.Encoding
+		mode     string
+	}{
+		{emulator.EncodingBinary, "auto"},
+		{emulator.EncodingASCII, "negotiate"}, // Auto would wait for the binary probe to time out on every connect
+	}
+	for _, test := range tests {
+		emu, addr := startEmulator(t, test.encoding)
+		rwpctl := func(stdin string, args ...string) (string, error) {
+			return rwpctl(stdin, append([]string{"-mode", test.mode}, args...)...)
+		}
+
+		out, err := rwpctl("", addr, "info")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(out, "SK_EMULATOR") || !strings.Contains(out, "Desk") {
+			t.Errorf("unexpected info output:\n%s", out)
+		}
+
+		out, err = rwpctl("", addr, "topology")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(out, `"txt": "Button"`) {
+			t.Errorf("unexpected topology output:\n%s", out)
+		}
+
+		if _, err := rwpctl("HWCc#1=2\n\nHWC#1=36\n", addr, "send", "-"); err != nil {
+			t.Fatal(err)
+		}
+		if state := emu.State(1); state.GetHWCMode().GetState() != rwp.HWCMode_ON || state.GetHWCColor().GetColorIndex().GetIndex() != rwp.ColorIndex_Colors(2) {
+			t.Errorf("unexpected state after send: %v", state)
+		}
+
+		if _, err := rwpctl("", addr, "brightness", "3", "5"); err != nil {
+			t.Fatal(err)
+		}
+		if brightness := emu.Brightness(); brightness.GetLEDs() != 3 || brightness.GetOLEDs() != 5 {
+			t.Errorf("unexpected brightness %v", brightness)
+		}
+
+		if _, err := rwpctl("", addr, "sleep", "10"); err != nil {
+			t.Fatal(err)
+		}
+		out, err = rwpctl("", addr, "sleep")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(out, "10 minutes") {
+			t.Errorf("unexpected sleep output: %s", out)
+		}
+	}
+}
+
+func TestUsage(t *testing.T) {
+	if _, err := rwpctl(""); err != errUsage {
+		t.Fatalf("expected usage error, got %v", err)
+	}
+	if _, err := rwpctl("", "127.0.0.1", "frobnicate"); err != errUsage {
+		t.Fatalf("expected usage error, got %v", err)
+	}
+	if _, err := rwpctl("", "127.0.0.1", "brightness", "12"); err == nil {
+		t.Fatal("brightness out of range accepted")
+	}
+	if got := panelAddress("10.0.0.5"); got != "10.0.0.5:9923" {
+		t.Fatalf("default port not added: %s", got)
+	}
+}
@@ -0,0 +1,217 @@
+/*
+   Copyright 2026 SKAARHOJ ApS
+
+   Released under MIT License
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	helpers "github.com/SKAARHOJ/rawpanel-lib"
+	rwp "github.com/SKAARHOJ/rawpanel-lib/ibeam_rawpanel"
+)
+
+// A single connection to a panel. Encoding is handled by ConnectToPanel, so commands only deal with messages
+type session struct {
+	opts      *options
+	binary    bool
+	toPanel   chan []*rwp.InboundMessage
+	fromPanel chan []*rwp.OutboundMessage // Without pings, they are answered right away
+	stopped   chan struct{}
+	cancel    context.CancelFunc
+	wg        sync.WaitGroup
+}
+
+// Gives up instead of retrying, a command line tool should fail fast
+type noRetry struct{}
+
+func (noRetry) NextDelay(attempt int) (time.Duration, bool) {
+	return 0, false
+}
+
+// Connects to the panel of the options and waits until messages can be sent
+func connect(ctx context.Context, opts *options) (*session, error) {
+	s := &session{
+		opts:      opts,
+		toPanel:   make(chan []*rwp.InboundMessage, 10),
+		fromPanel: make(chan []*rwp.OutboundMessage, 100),
+		stopped:   make(chan struct{}),
+	}
+	rawFromPanel := make(chan []*rwp.OutboundMessage, 100)
+
+	var transport helpers.Transport
+	if opts.address == "native" || opts.address == "host" {
+		transport = helpers.UnixTransport(helpers.HostSocketPath)
+	} else {
+		transport = &helpers.NetTransport{Network: "tcp", Address: opts.address, TLSConfig: opts.tls}
+	}
+
+	// Keep the dial error, ConnectToPanel only logs it:
+	var dialErr error
+	var dialErrMu sync.Mutex
+	recordingTransport := helpers.TransportFunc(func(ctx context.Context) (io.ReadWriteCloser, error) {
+		c, err := transport.Dial(ctx)
+		dialErrMu.Lock()
+		dialErr = err
+		dialErrMu.Unlock()
+		return c, err
+	})
+
+	type connection struct {
+		errorMsg string
+		binary   bool
+	}
+	connected := make(chan connection, 1)
+
+	var sessionCtx context.Context
+	sessionCtx, s.cancel = context.WithCancel(ctx)
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer close(s.stopped)
+		helpers.ConnectToPanel(opts.address, s.toPanel, rawFromPanel, sessionCtx, &s.wg, func(errorMsg string, binary bool, _ net.Conn) {
+			connected <- connection{errorMsg, binary}
+		}, nil, &helpers.ConnectToPanelConfig{
+			Transport:      recordingTransport,
+			ConnectTimeout: opts.timeout,
+			ProtocolMode:   opts.mode,
+			RetryPolicy:    noRetry{},
+		})
+	}()
+
+	// Panels with a heartbeat timer disconnect if their pings aren't answered:
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		for {
+			select {
+			case msgs := <-rawFromPanel:
+				filtered := []*rwp.OutboundMessage{}
+				for _, msg := range msgs {
+					if msg.FlowMessage == rwp.OutboundMessage_PING {
+						s.send(&rwp.InboundMessage{FlowMessage: rwp.InboundMessage_ACK})
+					} else {
+						filtered = append(filtered, msg)
+					}
+				}
+				if len(filtered) > 0 {
+					select {
+					case s.fromPanel <- filtered:
+					case <-sessionCtx.Done():
+						return
+					}
+				}
+			case <-sessionCtx.Done():
+				return
+			}
+		}
+	}()
+
+	select {
+	case c := <-connected:
+		if c.errorMsg != "" {
+			s.close()
+			return nil, fmt.Errorf("panel %s rejected the connection: %s", opts.address, c.errorMsg)
+		}
+		s.binary = c.binary
+		return s, nil
+	case <-s.stopped:
+		s.close()
+		dialErrMu.Lock()
+		defer dialErrMu.Unlock()
+		if dialErr != nil {
+			return nil, dialErr
+		}
+		return nil, fmt.Errorf("connection to %s closed", opts.address)
+	case <-ctx.Done():
+		s.close()
+		return nil, ctx.Err()
+	}
+}
+
+// Returns "binary" or "ASCII"
+func (s *session) encoding() string {
+	if s.binary {
+		return "binary"
+	}
+	return "ASCII"
+}
+
+// Queues messages for the panel. They are dropped if the connection is closed
+func (s *session) send(msgs ...*rwp.InboundMessage) {
+	select {
+	case s.toPanel <- msgs:
+	case <-s.stopped:
+	}
+}
+
+func (s *session) sendCommand(cmd *rwp.Command) {
+	s.send(&rwp.InboundMessage{Command: cmd})
+}
+
+// Waits for messages from the panel until handle returns true. Fails after the timeout of the options
+func (s *session) await(ctx context.Context, handle func(*rwp.OutboundMessage) bool) error {
+	timeout := time.NewTimer(s.opts.timeout)
+	defer timeout.Stop()
+	for {
+		select {
+		case msgs := <-s.fromPanel:
+			for _, msg := range msgs {
+				if handle(msg) {
+					return nil
+				}
+			}
+		case <-s.stopped:
+			return fmt.Errorf("connection to %s closed", s.opts.address)
+		case <-timeout.C:
+			return fmt.Errorf("no reply from %s within %s", s.opts.address, s.opts.timeout)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Like await, but collects for a short while after the first match since ASCII panels reply with one line per field
+func (s *session) collect(ctx context.Context, handle func(*rwp.OutboundMessage) bool) error {
+	if err := s.await(ctx, handle); err != nil {
+		return err
+	}
+	quiet := time.NewTimer(200 * time.Millisecond)
+	defer quiet.Stop()
+	for {
+		select {
+		case msgs := <-s.fromPanel:
+			for _, msg := range msgs {
+				if handle(msg) {
+					quiet.Reset(200 * time.Millisecond)
+				}
+			}
+		case <-quiet.C:
+			return nil
+		case <-s.stopped:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Makes sure everything sent so far has reached the panel by waiting for the reply to a ping
+func (s *session) sync(ctx context.Context) error {
+	s.send(&rwp.InboundMessage{FlowMessage: rwp.InboundMessage_PING})
+	return s.await(ctx, func(msg *rwp.OutboundMessage) bool {
+		return msg.FlowMessage == rwp.OutboundMessage_ACK
+	})
+}
+
+func (s *session) close() {
+	s.cancel()
+	s.wg.Wait()
+}
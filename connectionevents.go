@@ -0,0 +1,158 @@
+package rawpanellib
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"go.uber.org/atomic"
+)
+
+// Protocol encoding used on a panel connection
+type PanelEncoding int
+
+const (
+	PanelEncodingASCII PanelEncoding = iota
+	PanelEncodingBinary
+)
+
+func (pe PanelEncoding) String() string {
+	if pe == PanelEncodingBinary {
+		return "binary"
+	}
+	return "ASCII"
+}
+
+func encodingFromBool(binaryPanel bool) PanelEncoding {
+	if binaryPanel {
+		return PanelEncodingBinary
+	}
+	return PanelEncodingASCII
+}
+
+// Common information for all connection events
+type ConnectionEventInfo struct {
+	Address string    // Panel address as given to ConnectToPanel
+	Time    time.Time // When the event happened
+}
+
+func (cei ConnectionEventInfo) Info() ConnectionEventInfo {
+	return cei
+}
+
+// A lifecycle event of a ConnectToPanel connection. Use a type switch to get the concrete event:
+// *DialingEvent, *ProtocolDetectedEvent, *PanelRejectedEvent, *ConnectedEvent, *DisconnectedEvent or *StoppedEvent
+type ConnectionEvent interface {
+	Info() ConnectionEventInfo
+}
+
+// A connection attempt is about to be made
+type DialingEvent struct {
+	ConnectionEventInfo
+	Attempt int // 0 for the first attempt, then the retry attempt number
+}
+
+// Encoding of the panel was detected
+type ProtocolDetectedEvent struct {
+	ConnectionEventInfo
+	Encoding         PanelEncoding
+	DetectionLatency time.Duration // Time spent on detection
+}
+
+// The panel replied with an error message during detection, typically because it doesn't accept more clients
+type PanelRejectedEvent struct {
+	ConnectionEventInfo
+	ErrorMsg string
+}
+
+// Connection is established and messages flow
+type ConnectedEvent struct {
+	ConnectionEventInfo
+	Encoding         PanelEncoding
+	RemoteAddr       string
+	DetectionLatency time.Duration
+}
+
+// An established connection was lost or closed
+type DisconnectedEvent struct {
+	ConnectionEventInfo
+	Cause    error         // Read error which ended the connection, or the context error if cancelled
+	BytesIn  uint64        // Bytes received from the panel on this connection
+	BytesOut uint64        // Bytes sent to the panel on this connection
+	Uptime   time.Duration // Time the connection was established
+}
+
+// ConnectToPanel has returned
+type StoppedEvent struct {
+	ConnectionEventInfo
+	Reason error // Context error, or nil if the retry policy gave up
+}
+
+// Receives connection events, as an alternative to a channel
+type ConnectionEventHandler interface {
+	HandleConnectionEvent(ConnectionEvent)
+}
+
+// Adapter to use a function as a ConnectionEventHandler
+type ConnectionEventHandlerFunc func(ConnectionEvent)
+
+func (f ConnectionEventHandlerFunc) HandleConnectionEvent(ev ConnectionEvent) {
+	f(ev)
+}
+
+// Delivers events to the handler and channel of a config (both optional)
+type connectionEventEmitter struct {
+	address string
+	handler ConnectionEventHandler
+	events  chan<- ConnectionEvent
+	ctx     context.Context
+}
+
+func newConnectionEventEmitter(address string, config *ConnectToPanelConfig, ctx context.Context) *connectionEventEmitter {
+	cee := &connectionEventEmitter{address: address, ctx: ctx}
+	if config != nil {
+		cee.handler = config.EventHandler
+		cee.events = config.Events
+	}
+	return cee
+}
+
+func (cee *connectionEventEmitter) info() ConnectionEventInfo {
+	return ConnectionEventInfo{Address: cee.address, Time: time.Now()}
+}
+
+// Delivers an event. The channel send blocks like msgsFromPanel does, but once the context is done we only deliver if there is room.
+func (cee *connectionEventEmitter) emit(ev ConnectionEvent) {
+	if cee.handler != nil {
+		cee.handler.HandleConnectionEvent(ev)
+	}
+	if cee.events != nil {
+		select {
+		case cee.events <- ev:
+		case <-cee.ctx.Done():
+			select {
+			case cee.events <- ev:
+			default:
+			}
+		}
+	}
+}
+
+// Counts bytes read and written on a connection
+type countingConn struct {
+	net.Conn
+	bytesIn  atomic.Uint64
+	bytesOut atomic.Uint64
+}
+
+func (cc *countingConn) Read(b []byte) (int, error) {
+	n, err := cc.Conn.Read(b)
+	cc.bytesIn.Add(uint64(n))
+	return n, err
+}
+
+func (cc *countingConn) Write(b []byte) (int, error) {
+	n, err := cc.Conn.Write(b)
+	cc.bytesOut.Add(uint64(n))
+	return n, err
+}
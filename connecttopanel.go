@@ -1,10 +1,9 @@
 package rawpanellib
 
 import (
-	"bufio"
 	"context"
+	"crypto/tls"
 	"encoding/binary"
-	"io"
 	"net"
 	"strings"
 	"sync"
@@ -14,14 +13,106 @@ import (
 
 	rwp "github.com/SKAARHOJ/rawpanel-lib/ibeam_rawpanel"
 	log "github.com/s00500/env_logger"
-
-	"go.uber.org/atomic"
 )
 
 type ConnectToPanelConfig struct {
-	NoConnectionRetryPeriod int    // Period in seconds between retries in case of no
-	ReConnectionRetryPeriod int    // Period in seconds between retries in case of disconnect
-	NetworkAlternative      string // Alternative network interface to use, e.g. "en0" for WiFi on macOS
+	NoConnectionRetryPeriod int                                                   // Period in seconds between retries in case of no
+	ReConnectionRetryPeriod int                                                   // Period in seconds between retries in case of disconnect
+	NetworkAlternative      string                                                // Alternative network interface to use, e.g. "en0" for WiFi on macOS
+	TLSConfig               *tls.Config                                           // Connect with TLS (optional). Binary/ASCII autodetection runs on top of the encrypted connection
+	ConnectTimeout          time.Duration                                         // Max time for establishing a connection. 0 means no timeout other than the operating system's
+	DetectionTimeout        time.Duration                                         // Max time to wait for the reply when detecting binary or ASCII mode, default DefaultDetectionTimeout
+	ProtocolMode            ProtocolMode                                          // How the encoding of the panel is chosen, default ProtocolAuto which probes with a binary ping
+	Metrics                 *ConnectionMetrics                                    // Counts messages, bytes, reconnects and ping round trip times (optional)
+	Recorder                *Recorder                                             // Records the traffic of all connections to the panel, e.g. to a .rwplog file for Replay (optional)
+	MaxFrameSize            int                                                   // Max payload size of binary frames from and to the panel, default DefaultMaxFrameSize. Larger frames from the panel close the connection
+	Transport               Transport                                             // Opens the connection instead of dialing panelIPAndPort, which is then only used for logging and events. NetworkAlternative and TLSConfig are not used
+	RetryPolicy             RetryPolicy                                           // Policy for the delay between connection attempts, e.g. BackoffRetryPolicy. If set, the two retry periods above are not used
+	OnRetry                 func(attempt int, lastErr error, delay time.Duration) // Called before waiting for the next connection attempt. lastErr is the dial error, or nil after a disconnect
+	Liveness                *LivenessMonitor                                      // Pings the panel and forces a reconnect if it stops answering (optional). ACKs from the panel are not forwarded to msgsFromPanel when set
+	SendScheduler           *SendScheduler                                        // Coalesces feedback to the panel and rate limits writes (optional). Without it, every message is written right away
+	Events                  chan<- ConnectionEvent                                // Lifecycle events are sent here (optional). Must be read continuously like msgsFromPanel
+	EventHandler            ConnectionEventHandler                                // Lifecycle events are delivered to this handler (optional)
+}
+
+// Detects binary or ASCII mode of a panel by sending a binary ping (ProtocolAuto). Also returns the error message if an ASCII panel replied with one.
+// Returns the context error if ctx was done while waiting for the reply.
+func probePanelEncoding(ctx context.Context, conn net.Conn, panelIPAndPort string, detectionTimeout time.Duration) (bool, string, error) {
+	// Is panel ASCII or Binary? Try by sending a binary ping to the panel.
+	// Background: Since it's possible that a panel auto detects binary or ascii protocol mode itself, it's better to probe with a Binary package since otherwise a binary capable panel/system pair in auto mode would negotiate to use ASCII which is not efficient.
+	pingMessage := &rwp.InboundMessage{
+		FlowMessage: rwp.InboundMessage_PING,
+	}
+	pbdata, err := proto.Marshal(pingMessage)
+	log.Should(err)
+	header := make([]byte, 4)                                  // Create a 4-bytes header
+	binary.LittleEndian.PutUint32(header, uint32(len(pbdata))) // Fill it in
+	pbdata = append(header, pbdata...)                         // and concatenate it with the binary message
+	log.Debugln("Autodetecting binary / ascii mode of panel", panelIPAndPort, "by sending binary ping:", pbdata)
+	// Prepare reception of the response (times out after the detection timeout, or right away if the context is done)
+	err = conn.SetReadDeadline(time.Now().Add(detectionTimeout))
+	log.Should(err)
+	stopAbort := abortOnDone(ctx, conn)
+	_, err = conn.Write(pbdata) // Send "ping"
+	log.Should(err)
+	byteArray := make([]byte, 1000)
+	byteCount, err := conn.Read(byteArray) // Should timeout if ascii panel, otherwise respond promptly with an ACK message
+	stopAbort()
+	if ctx.Err() != nil {
+		return false, "", ctx.Err()
+	}
+	assumeASCII := false
+	binaryPanel := true
+	if err == nil {
+		if byteCount > 4 {
+			responsePayloadLength := binary.LittleEndian.Uint32(byteArray[0:4])
+			if responsePayloadLength+4 == uint32(byteCount) {
+				reply := &rwp.OutboundMessage{}
+				proto.Unmarshal(byteArray[4:byteCount], reply)
+				if reply.FlowMessage == rwp.OutboundMessage_ACK {
+					log.Debugln("Received ACK successfully: ", byteArray[0:byteCount])
+					log.Debugln("Using Binary Protocol Mode for panel ", panelIPAndPort)
+				} else {
+					log.Debugln("Received something else than an ack response, staying with Binary Protocol Mode for panel ", panelIPAndPort)
+				}
+			} else {
+				log.Debugln("Bytecount didn't match header for ", panelIPAndPort)
+				assumeASCII = true
+			}
+		} else {
+			log.Debugln("Unexpected reply length for ", panelIPAndPort)
+			assumeASCII = true
+		}
+	} else {
+		log.WithError(err).Debug("Tried to connect in binary mode failed for ", panelIPAndPort)
+		assumeASCII = true
+	}
+	err = conn.SetReadDeadline(time.Time{}) // Reset - necessary for ASCII line reading.
+
+	errorMsg := ""
+	if assumeASCII {
+		parts := strings.Split(string(byteArray[:byteCount]), "\n")
+		if strings.HasPrefix(parts[0], "ErrorMsg=") {
+			errorMsg = parts[0][9:]
+		}
+
+		log.Debugf("Reply from panel was: %s\n", strings.ReplaceAll(string(byteArray[:byteCount]), "\n", "\\n"))
+		log.Debugln("Using ASCII Protocol Mode for panel", panelIPAndPort)
+		_, err = conn.Write([]byte("\n")) // Clearing an ASCII panels buffer with a newline since we sent it binary stuff
+		binaryPanel = false
+	}
+
+	return binaryPanel, errorMsg, nil
+}
+
+// Dials the transport, giving up after timeout if it's not 0
+func dialWithTimeout(ctx context.Context, transport Transport, timeout time.Duration) (net.Conn, error) {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	return DialTransport(ctx, transport)
 }
 
 // Connects to a raw panel compliant device on IP:port
@@ -29,8 +120,9 @@ type ConnectToPanelConfig struct {
 // Supply channels for messages to and from the panel. You must make sure something is reading from the msgsFromPanel channel, while you send stuff into msgsToPanel
 // The context is a way for you to cancel the internal loop and goroutines
 // The Waitgroup helps you to know when everything is shut down internally (so you can safely close your supplied channels if you like)
-// onconnect/ondisconnect gets called when those events happen
+// onconnect/ondisconnect gets called when those events happen. For more details, use Events or EventHandler in the config
 // Config is optional additional configuration options
+// It returns when the context is done or when a configured RetryPolicy gives up
 func ConnectToPanel(panelIPAndPort string, msgsToPanel <-chan []*rwp.InboundMessage, msgsFromPanel chan<- []*rwp.OutboundMessage, ctx context.Context, wg *sync.WaitGroup, onconnect func(string, bool, net.Conn), ondisconnect func(bool), config *ConnectToPanelConfig) {
 
 	// Config:
@@ -56,193 +148,157 @@ func ConnectToPanel(panelIPAndPort string, msgsToPanel <-chan []*rwp.InboundMess
 		network = config.NetworkAlternative
 	}
 
-	// Main loop for continuous connection attempts:
-	for {
-		log.Debugln("Trying to connect to panel on " + network + " " + panelIPAndPort)
-		conn, err := net.Dial(network, panelIPAndPort)
-		log.Should(err)
+	var connectTimeout time.Duration
+	detectionTimeout := DefaultDetectionTimeout
+	protocolMode := ProtocolAuto
+	var transport Transport
+	var liveness *LivenessMonitor
+	var scheduler *SendScheduler
+	var maxFrameSize int
+	var metrics *ConnectionMetrics
+	var recorder *Recorder
+	if config != nil {
+		maxFrameSize = config.MaxFrameSize
+		metrics = config.Metrics
+		recorder = config.Recorder
+		connectTimeout = config.ConnectTimeout
+		if config.DetectionTimeout > 0 {
+			detectionTimeout = config.DetectionTimeout
+		}
+		transport = config.Transport
+		protocolMode = config.ProtocolMode
+		liveness = config.Liveness
+		scheduler = config.SendScheduler
+	}
 
-		if err != nil {
-			//fmt.Println(err)
-			timer1 := time.NewTimer(time.Duration(noConnectionRetryPeriod) * time.Second)
+	if liveness != nil && metrics != nil {
+		liveness.OnRTT(metrics.ObserveRTT)
+	}
+
+	if transport == nil {
+		var tlsConfig *tls.Config
+		if config != nil {
+			tlsConfig = config.TLSConfig
+		}
+		transport = &NetTransport{Network: network, Address: panelIPAndPort, TLSConfig: tlsConfig}
+	}
+
+	// Lifecycle events:
+	events := newConnectionEventEmitter(panelIPAndPort, config, ctx)
+	var stopReason error
+	defer func() {
+		events.emit(&StoppedEvent{ConnectionEventInfo: events.info(), Reason: stopReason})
+	}()
+
+	// Waits before the next connection attempt according to the retry policy. Returns false if we should stop trying.
+	retry := func(attempt int, lastErr error, reconnect bool) bool {
+		var delay time.Duration
+		if config != nil && config.RetryPolicy != nil {
+			var ok bool
+			delay, ok = config.RetryPolicy.NextDelay(attempt)
+			if !ok {
+				log.Debugf("Giving up connecting to %s after %d attempts\n", panelIPAndPort, attempt)
+				return false
+			}
+		} else if reconnect {
+			delay = time.Duration(reConnectionRetryPeriod) * time.Second
+		} else {
+			delay = time.Duration(noConnectionRetryPeriod) * time.Second
+		}
+		if config != nil && config.OnRetry != nil {
+			config.OnRetry(attempt, lastErr, delay)
+		}
+
+		log.Debugf("Retrying in %s for %s (attempt %d)\n", delay, panelIPAndPort, attempt)
+		timer1 := time.NewTimer(delay)
+		defer timer1.Stop()
+		for {
 			select {
 			case <-ctx.Done():
 				log.Debugln("Stop trying to connect to " + panelIPAndPort)
-				return
+				stopReason = ctx.Err()
+				return false
 			case <-msgsToPanel:
 				// Ignore incoming if we are unconnected. But it's important to read the channel to not pile up stuff there.
 			case <-timer1.C:
+				return true
+			}
+		}
+	}
+
+	// Main loop for continuous connection attempts:
+	attempt := 0
+	for {
+		log.Debugln("Trying to connect to panel on " + network + " " + panelIPAndPort)
+		events.emit(&DialingEvent{ConnectionEventInfo: events.info(), Attempt: attempt})
+		rawConn, err := dialWithTimeout(ctx, transport, connectTimeout)
+		log.Should(err)
+
+		if err != nil {
+			attempt++
+			if !retry(attempt, err, false) {
+				return
 			}
 		} else {
+			attempt = 0
 			log.Debugln("TCP Connection established...")
+			conn := &countingConn{Conn: metrics.Conn(rawConn)}
+			connectedTime := time.Now()
 
-			// Is panel ASCII or Binary? Try by sending a binary ping to the panel.
-			// Background: Since it's possible that a panel auto detects binary or ascii protocol mode itself, it's better to probe with a Binary package since otherwise a binary capable panel/system pair in auto mode would negotiate to use ASCII which is not efficient.
-			pingMessage := &rwp.InboundMessage{
-				FlowMessage: rwp.InboundMessage_PING,
-			}
-			pbdata, err := proto.Marshal(pingMessage)
-			log.Should(err)
-			header := make([]byte, 4)                                  // Create a 4-bytes header
-			binary.LittleEndian.PutUint32(header, uint32(len(pbdata))) // Fill it in
-			pbdata = append(header, pbdata...)                         // and concatenate it with the binary message
-			log.Debugln("Autodetecting binary / ascii mode of panel", panelIPAndPort, "by sending binary ping:", pbdata)
-			_, err = conn.Write(pbdata) // Send "ping"
-			log.Should(err)
-
-			// Prepare reception of the response (times out after two seconds)
-			byteArray := make([]byte, 1000)
-			err = conn.SetReadDeadline(time.Now().Add(2000 * time.Millisecond))
-			log.Should(err)
-			byteCount, err := conn.Read(byteArray) // Should timeout after 2 seconds if ascii panel, otherwise respond promptly with an ACK message
-			assumeASCII := false
-			binaryPanel := true
-			if err == nil {
-				if byteCount > 4 {
-					responsePayloadLength := binary.LittleEndian.Uint32(byteArray[0:4])
-					if responsePayloadLength+4 == uint32(byteCount) {
-						reply := &rwp.OutboundMessage{}
-						proto.Unmarshal(byteArray[4:byteCount], reply)
-						if reply.FlowMessage == rwp.OutboundMessage_ACK {
-							log.Debugln("Received ACK successfully: ", byteArray[0:byteCount])
-							log.Debugln("Using Binary Protocol Mode for panel ", panelIPAndPort)
-						} else {
-							log.Debugln("Received something else than an ack response, staying with Binary Protocol Mode for panel ", panelIPAndPort)
-						}
-					} else {
-						log.Debugln("Bytecount didn't match header for ", panelIPAndPort)
-						assumeASCII = true
-					}
-				} else {
-					log.Debugln("Unexpected reply length for ", panelIPAndPort)
-					assumeASCII = true
-				}
+			// Is panel ASCII or Binary?
+			var binaryPanel bool
+			var errorMsg string
+			if protocolMode == ProtocolAuto {
+				binaryPanel, errorMsg, err = probePanelEncoding(ctx, conn, panelIPAndPort, detectionTimeout)
 			} else {
-				log.WithError(err).Debug("Tried to connect in binary mode failed for ", panelIPAndPort)
-				assumeASCII = true
+				binaryPanel, errorMsg, err = DetectPanelEncodingWithMode(ctx, conn, panelIPAndPort, protocolMode, detectionTimeout)
 			}
-			err = conn.SetReadDeadline(time.Time{}) // Reset - necessary for ASCII line reading.
-
-			errorMsg := ""
-			if assumeASCII {
-				parts := strings.Split(string(byteArray[:byteCount]), "\n")
-				if strings.HasPrefix(parts[0], "ErrorMsg=") {
-					errorMsg = parts[0][9:]
-				}
-
-				log.Debugf("Reply from panel was: %s\n", strings.ReplaceAll(string(byteArray[:byteCount]), "\n", "\\n"))
-				log.Debugln("Using ASCII Protocol Mode for panel", panelIPAndPort)
-				_, err = conn.Write([]byte("\n")) // Clearing an ASCII panels buffer with a newline since we sent it binary stuff
-				binaryPanel = false
+			if err != nil {
+				log.Debugln("Stop detecting protocol mode of " + panelIPAndPort + " because context was done")
+				conn.Close()
+				stopReason = err
+				return
 			}
-
-			// This goroutine is reading the msgsToPanel channel and sending over the panel in the proper encoding (binary or ASCII)
-			var exit atomic.Bool
-			quit := make(chan bool)
-			go func() {
-				if wg != nil {
-					wg.Add(1)
-					defer wg.Done()
-				}
-				for {
-					select {
-					case <-ctx.Done(): // Context shutdown.
-						log.Debugln("Closing network connection because context was done, ", panelIPAndPort)
-						exit.Store(true)
-						conn.Close() // The implications of closing the connection should be that the listening code below will also fail and exit - but it might not happen if the msgsFromPanel channel reader outside this function has been stopped prematurely, so watch out for that.
-						return
-					case <-quit:
-						return
-					case incomingMessages := <-msgsToPanel:
-						if binaryPanel {
-							for _, msg := range incomingMessages {
-								pbdata, err := proto.Marshal(msg)
-								log.Should(err)
-								header := make([]byte, 4)                                  // Create a 4-bytes header
-								binary.LittleEndian.PutUint32(header, uint32(len(pbdata))) // Fill it in
-								pbdata = append(header, pbdata...)                         // and concatenate it with the binary message
-								//log.Debugln("System -> Panel: ", pbdata)
-								_, err = conn.Write(pbdata)
-								log.Should(err)
-							}
-						} else {
-							lines := InboundMessagesToRawPanelASCIIstrings(incomingMessages)
-							for _, line := range lines {
-								//fmt.Println(string("System -> Panel: " + strings.TrimSpace(string(line))))
-								conn.Write([]byte(line + "\n"))
-							}
-						}
-					}
-				}
-			}()
-
-			// At this point we should be connected and know what prototol to use. We may also have received an errormessage and been disconnected, but in that case we will figure it out later.
-			if onconnect != nil {
-				onconnect(errorMsg, binaryPanel, conn)
+			detectionLatency := time.Since(connectedTime)
+			metrics.Detected(binaryPanel)
+			events.emit(&ProtocolDetectedEvent{ConnectionEventInfo: events.info(), Encoding: encodingFromBool(binaryPanel), DetectionLatency: detectionLatency})
+			if errorMsg != "" {
+				events.emit(&PanelRejectedEvent{ConnectionEventInfo: events.info(), ErrorMsg: errorMsg})
 			}
 
-			// Below, we will listen to messages from the panel, decode it and forward to the msgsFromPanel channel (which must be read externally)
-			if binaryPanel {
-				for {
-					conn.SetReadDeadline(time.Time{}) // Reset deadline, waiting for header
-					headerArray := make([]byte, 4)
-					_, err := io.ReadFull(conn, headerArray) // Read 4 header bytes
-					if err != nil {
-						log.Debugln("Binary: ", err)
-						break
-					} else {
-						currentPayloadLength := binary.LittleEndian.Uint32(headerArray[0:4])
-						if currentPayloadLength < 500000 {
-							payload := make([]byte, currentPayloadLength)
-							conn.SetReadDeadline(time.Now().Add(2 * time.Second)) // Set a deadline that we want all data within at most 2 seconds. This helps a run-away scenario where not all data arrives or we read the wront (and too big) header
-							_, err := io.ReadFull(conn, payload)
-							if err != nil {
-								log.Debugln(err)
-								break
-							} else {
-								outcomingMessage := &rwp.OutboundMessage{}
-								proto.Unmarshal(payload, outcomingMessage)
-								msgsFromPanel <- []*rwp.OutboundMessage{outcomingMessage}
-							}
-						} else {
-							log.Debugln("Error: Payload", currentPayloadLength, "exceed limit")
-							break
-						}
-					}
-				}
-			} else {
-				//log.Debugln("Reading ASCII lines...")
-				connectionReader := bufio.NewReader(conn) // Define OUTSIDE the for loop
-				for {
-					netData, err := connectionReader.ReadString('\n')
-					if err != nil {
-						if err == io.EOF {
-							log.Debugln("Panel: " + conn.RemoteAddr().String() + " disconnected")
-							time.Sleep(time.Second)
-						} else {
-							log.Debugln(err)
-						}
-						break
-					} else {
-						msgsFromPanel <- RawPanelASCIIstringsToOutboundMessages([]string{strings.TrimSpace(netData)})
+			// Run the connection until it fails or the context is done. onconnect is called once the reader and writer are running.
+			doExit, cause := servePanelConnection(conn, binaryPanel, panelIPAndPort, msgsToPanel, msgsFromPanel, ctx, wg, panelConnectionOptions{
+				onconnected: func() {
+					// At this point we should be connected and know what prototol to use. We may also have received an errormessage and been disconnected, but in that case we will figure it out later.
+					metrics.ConnectionUp()
+					events.emit(&ConnectedEvent{ConnectionEventInfo: events.info(), Encoding: encodingFromBool(binaryPanel), RemoteAddr: rawConn.RemoteAddr().String(), DetectionLatency: detectionLatency})
+					if onconnect != nil {
+						onconnect(errorMsg, binaryPanel, rawConn)
 					}
-				}
-			}
+				},
+				liveness:     liveness,
+				scheduler:    scheduler,
+				maxFrameSize: maxFrameSize,
+				metrics:      metrics,
+				recorder:     recorder,
+			})
 
 			// Assume disconnected or otherwise in error state:
 			log.Debugln("Network connection closed or failed for ", panelIPAndPort)
-			close(quit)
-			conn.Close()
-			doExit := exit.Load()
+			metrics.ConnectionDown()
+			events.emit(&DisconnectedEvent{ConnectionEventInfo: events.info(), Cause: cause, BytesIn: conn.bytesIn.Load(), BytesOut: conn.bytesOut.Load(), Uptime: time.Since(connectedTime)})
 			if ondisconnect != nil {
 				ondisconnect(doExit)
 			}
 			if doExit { // This is true in case context cancellation is the reason.
+				stopReason = ctx.Err()
 				return
 			}
 
-			log.Debugf("Retrying in %d seconds for %s\n", reConnectionRetryPeriod, panelIPAndPort)
-			time.Sleep(time.Duration(reConnectionRetryPeriod) * time.Second)
+			attempt++
+			if !retry(attempt, nil, true) {
+				return
+			}
 		}
 	}
 }
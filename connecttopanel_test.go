@@ -0,0 +1,65 @@
+package rawpanellib
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	rwp "github.com/SKAARHOJ/rawpanel-lib/ibeam_rawpanel"
+)
+
+func TestCancelDuringDetection(t *testing.T) {
+	// A "panel" which accepts connections and never answers:
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	events := make(chan ConnectionEvent, 10)
+	done := make(chan bool)
+	go func() {
+		ConnectToPanel(listener.Addr().String(), make(chan []*rwp.InboundMessage), make(chan []*rwp.OutboundMessage), ctx, &wg, nil, nil, &ConnectToPanelConfig{
+			ConnectTimeout:   time.Second,
+			DetectionTimeout: time.Minute,
+			Events:           events,
+		})
+		close(done)
+	}()
+
+	<-events // Dialing
+	time.Sleep(100 * time.Millisecond)
+	cancelled := time.Now()
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("ConnectToPanel didn't return after cancel")
+	}
+	wg.Wait()
+	if time.Since(cancelled) > 500*time.Millisecond {
+		t.Fatalf("cancelling took %s", time.Since(cancelled))
+	}
+
+	// The same for the detection function, on a pipe where even the ping can't be written:
+	system, panel := net.Pipe()
+	defer panel.Close()
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := AutoDetectIfPanelEncodingIsBinaryContext(ctx, system, "pipe", time.Minute); err != context.DeadlineExceeded {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+}
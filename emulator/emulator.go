@@ -0,0 +1,348 @@
+/*
+   Copyright 2026 SKAARHOJ ApS
+
+   Released under MIT License
+*/
+
+// Package emulator provides an in-process SKAARHOJ Raw Panel which
+// implements the panel side of the Raw Panel protocol.
+//
+// The emulator answers the standard initialization queries from a
+// supplied topology, replies to pings and keeps the feedback state
+// for every hardware component, so systems can be developed and
+// tested without a panel on the desk.
+
+package emulator
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	helpers "github.com/SKAARHOJ/rawpanel-lib"
+	rwp "github.com/SKAARHOJ/rawpanel-lib/ibeam_rawpanel"
+	"github.com/SKAARHOJ/rawpanel-lib/internal/panelserver"
+	topology "github.com/SKAARHOJ/rawpanel-lib/topology"
+	log "github.com/s00500/env_logger"
+)
+
+// Type Encoding selects which protocol encoding the emulator accepts
+type Encoding int
+
+const (
+	EncodingAuto   Encoding = iota // Detect ASCII or binary per connection (like Blue Pill panels)
+	EncodingASCII                  // Only ASCII (like older UniSketch panels)
+	EncodingBinary                 // Only binary
+)
+
+// Config holds optional settings for the emulator. Empty fields get defaults.
+type Config struct {
+	Model           string          // Model reported in PanelInfo, default "SK_EMULATOR"
+	Serial          string          // Serial reported in PanelInfo, default "EMU00001"
+	Name            string          // Name reported in PanelInfo
+	SoftwareVersion string          // Software version reported in PanelInfo
+	Platform        string          // Platform reported in PanelInfo
+	MaxClients      uint32          // Max clients reported in PanelInfo
+	TopologySVG     string          // Base SVG sent with the topology. A blank SVG is used if empty.
+	Encoding        Encoding        // Accepted encoding, default EncodingAuto
+	TLSConfig       *tls.Config     // Listen accepts TLS connections if set. Set ClientAuth to require client certificates
+	Registers       []*rwp.Register // Sent when asked for registers, one line each in ASCII
+}
+
+// Type Emulator is an emulated Raw Panel device
+type Emulator struct {
+	config       Config
+	topology     *topology.Topology
+	topologyJSON string
+	startTime    time.Time
+
+	mu             sync.RWMutex
+	states         map[uint32]*rwp.HWCState // Accumulated feedback per HWC
+	availability   map[uint32]uint32
+	brightness     *rwp.Brightness
+	heartBeatTimer uint32
+	sleepTimeout   uint32
+	absoluteValues map[uint32]uint32 // Last injected absolute value per HWC
+	speedValues    map[uint32]int32  // Last injected speed value per HWC
+	unresponsive   bool              // Simulates a hung panel: Nothing is answered
+	clients        map[*panelserver.Conn]bool
+	listeners      panelserver.Listeners
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// Creates a new emulator presenting the given topology. Config is optional.
+func New(top *topology.Topology, config *Config) *Emulator {
+	e := &Emulator{
+		topology:       top,
+		topologyJSON:   top.JSONstring(),
+		startTime:      time.Now(),
+		states:         make(map[uint32]*rwp.HWCState),
+		availability:   make(map[uint32]uint32),
+		absoluteValues: make(map[uint32]uint32),
+		speedValues:    make(map[uint32]int32),
+		clients:        make(map[*panelserver.Conn]bool),
+	}
+	if config != nil {
+		e.config = *config
+	}
+	if e.config.Model == "" {
+		e.config.Model = "SK_EMULATOR"
+	}
+	if e.config.Serial == "" {
+		e.config.Serial = "EMU00001"
+	}
+	if e.config.TopologySVG == "" {
+		e.config.TopologySVG = `<svg xmlns="http://www.w3.org/2000/svg" width="1000" height="1000"></svg>`
+	}
+
+	// All enabled HWCs are mapped to themselves:
+	for _, hwc := range top.HWc {
+		if hwc.Type != 0 {
+			e.availability[hwc.Id] = hwc.Id
+		}
+	}
+
+	e.ctx, e.cancel = context.WithCancel(context.Background())
+	return e
+}
+
+// Starts listening for systems on a network ("tcp" or "unix") and address, e.g. "127.0.0.1:9923".
+// Returns the address actually listened on, which is useful with port 0.
+func (e *Emulator) Listen(network string, address string) (net.Addr, error) {
+	return e.listeners.Listen(e.ctx, &e.wg, network, address, e.config.TLSConfig, e.ServeConn)
+}
+
+// Serves a single system connection, for example one end of a net.Pipe. Returns immediately.
+func (e *Emulator) ServeConn(conn net.Conn) {
+	c := panelserver.NewConn(conn, 0)
+
+	e.wg.Add(1)
+	go func() {
+		defer e.wg.Done()
+		e.serveClient(c)
+	}()
+}
+
+// Stops all listeners and disconnects all systems
+func (e *Emulator) Close() {
+	e.cancel()
+	e.listeners.Close()
+	e.mu.Lock()
+	for c := range e.clients {
+		c.Close()
+	}
+	e.mu.Unlock()
+	e.wg.Wait()
+}
+
+// Returns the accumulated feedback state of a HWC, or nil if nothing was received for it
+func (e *Emulator) State(hwc uint32) *rwp.HWCState {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	if state, exists := e.states[hwc]; exists {
+		return proto.Clone(state).(*rwp.HWCState)
+	}
+	return nil
+}
+
+// Returns the last brightness set by a system, or nil
+func (e *Emulator) Brightness() *rwp.Brightness {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	if e.brightness == nil {
+		return nil
+	}
+	return proto.Clone(e.brightness).(*rwp.Brightness)
+}
+
+// Makes the emulator stop answering anything (including pings) while
+// keeping connections open, like a hung panel or a half-open TCP link.
+func (e *Emulator) SetUnresponsive(unresponsive bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.unresponsive = unresponsive
+}
+
+// Returns the number of connected systems
+func (e *Emulator) ClientCount() int {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return len(e.clients)
+}
+
+func (e *Emulator) serveClient(c *panelserver.Conn) {
+	defer c.Close()
+
+	remoteAddr := c.RemoteAddr().String()
+	switch e.config.Encoding {
+	case EncodingASCII:
+		c.SetBinary(false)
+	case EncodingBinary:
+		c.SetBinary(true)
+	default:
+		if err := c.DetectEncoding(); err != nil {
+			log.Debugln("Emulator: System", remoteAddr, "disconnected before sending anything")
+			return
+		}
+	}
+	log.Debugln("Emulator: System connected from", remoteAddr, "binary:", c.Binary())
+
+	e.mu.Lock()
+	e.clients[c] = true
+	e.mu.Unlock()
+	defer func() {
+		e.mu.Lock()
+		delete(e.clients, c)
+		e.mu.Unlock()
+	}()
+
+	// Systems can switch to binary after negotiating in ASCII, in EncodingAuto
+	err := c.ReadMessages(e.config.Encoding == EncodingAuto, func(msgs []*rwp.InboundMessage) { e.processInbound(c, msgs) })
+	if err != nil {
+		log.Errorln("Emulator:", err)
+	}
+}
+
+// Acts on messages from a system and replies as a panel would
+func (e *Emulator) processInbound(c *panelserver.Conn, msgs []*rwp.InboundMessage) {
+	e.mu.RLock()
+	unresponsive := e.unresponsive
+	e.mu.RUnlock()
+	if unresponsive {
+		return
+	}
+
+	for _, msg := range msgs {
+		replies := []*rwp.OutboundMessage{}
+
+		if msg.FlowMessage == rwp.InboundMessage_PING {
+			replies = append(replies, &rwp.OutboundMessage{FlowMessage: rwp.OutboundMessage_ACK})
+		}
+
+		if msg.Command != nil {
+			replies = append(replies, e.processCommand(msg.Command)...)
+		}
+
+		e.applyStates(msg.States)
+
+		if len(replies) > 0 {
+			c.Send(replies)
+		}
+	}
+}
+
+// Merges feedback into the accumulated state per HWC
+func (e *Emulator) applyStates(states []*rwp.HWCState) {
+	if len(states) == 0 {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, state := range states {
+		for _, hwc := range state.HWCIDs {
+			if _, exists := e.states[hwc]; !exists {
+				e.states[hwc] = &rwp.HWCState{HWCIDs: []uint32{hwc}}
+			}
+			helpers.MergeHWCState(e.states[hwc], state)
+		}
+	}
+}
+
+func (e *Emulator) processCommand(cmd *rwp.Command) []*rwp.OutboundMessage {
+	replies := []*rwp.OutboundMessage{}
+
+	if cmd.SendPanelInfo {
+		replies = append(replies, &rwp.OutboundMessage{PanelInfo: e.panelInfo()})
+	}
+	if cmd.SendPanelTopology {
+		replies = append(replies, &rwp.OutboundMessage{
+			PanelTopology: &rwp.PanelTopology{
+				Json:    e.topologyJSON,
+				Svgbase: e.config.TopologySVG,
+			},
+		})
+	}
+	if cmd.ReportHWCavailability {
+		e.mu.RLock()
+		availability := make(map[uint32]uint32, len(e.availability))
+		for k, v := range e.availability {
+			availability[k] = v
+		}
+		e.mu.RUnlock()
+		replies = append(replies, &rwp.OutboundMessage{HWCavailability: availability})
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if cmd.SetHeartBeatTimer != nil {
+		e.heartBeatTimer = cmd.SetHeartBeatTimer.Value
+		replies = append(replies, &rwp.OutboundMessage{HeartBeatTimer: &rwp.HeartBeatTimer{Value: e.heartBeatTimer}})
+	}
+	if cmd.PanelBrightness != nil {
+		e.brightness = proto.Clone(cmd.PanelBrightness).(*rwp.Brightness)
+	}
+	if cmd.SetSleepTimeout != nil {
+		e.sleepTimeout = cmd.SetSleepTimeout.Value
+	}
+	if cmd.GetSleepTimeout {
+		replies = append(replies, &rwp.OutboundMessage{SleepTimeout: &rwp.SleepTimeout{Value: e.sleepTimeout}})
+	}
+	if cmd.GetConnections {
+		connections := &rwp.Connections{}
+		for c := range e.clients {
+			connections.Connection = append(connections.Connection, c.RemoteAddr().String())
+		}
+		replies = append(replies, &rwp.OutboundMessage{Connections: connections})
+	}
+	if cmd.GetRunTimeStats {
+		replies = append(replies, &rwp.OutboundMessage{
+			RunTimeStats: &rwp.RunTimeStats{
+				BootsCount:    1,
+				TotalUptime:   uint32(time.Since(e.startTime).Minutes()),
+				SessionUptime: uint32(time.Since(e.startTime).Minutes()),
+			},
+		})
+	}
+	if cmd.SendRegisters && len(e.config.Registers) > 0 {
+		replies = append(replies, &rwp.OutboundMessage{Registers: e.config.Registers})
+	}
+	if cmd.ClearAll || cmd.ClearLEDs || cmd.ClearDisplays {
+		for _, state := range e.states {
+			if cmd.ClearAll || cmd.ClearLEDs {
+				state.HWCMode = nil
+				state.HWCColor = nil
+			}
+			if cmd.ClearAll || cmd.ClearDisplays {
+				state.HWCText = nil
+				state.HWCGfx = nil
+				state.Processors = nil
+			}
+		}
+	}
+
+	return replies
+}
+
+func (e *Emulator) panelInfo() *rwp.PanelInfo {
+	return &rwp.PanelInfo{
+		Model:           e.config.Model,
+		Serial:          e.config.Serial,
+		Name:            e.config.Name,
+		SoftwareVersion: e.config.SoftwareVersion,
+		Platform:        e.config.Platform,
+		MaxClients:      e.config.MaxClients,
+		PanelType:       rwp.PanelInfo_EMULATION,
+		RawPanelSupport: &rwp.RawPanelSupport{
+			ASCII:  e.config.Encoding != EncodingBinary,
+			Binary: e.config.Encoding != EncodingASCII,
+		},
+	}
+}
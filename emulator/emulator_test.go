@@ -0,0 +1,193 @@
+package emulator
+
+import (
+	"context"
+	"image/color"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	helpers "github.com/SKAARHOJ/rawpanel-lib"
+	gorwp "github.com/SKAARHOJ/rawpanel-lib/gorwp"
+	rwp "github.com/SKAARHOJ/rawpanel-lib/ibeam_rawpanel"
+	topology "github.com/SKAARHOJ/rawpanel-lib/topology"
+)
+
+func testTopology() *topology.Topology {
+	return &topology.Topology{
+		HWc: []topology.TopologyHWcomponent{
+			{Id: 1, X: 100, Y: 100, Txt: "Button", Type: 1},
+			{Id: 2, X: 300, Y: 100, Txt: "Encoder", Type: 2},
+			{Id: 3, X: 500, Y: 100, Txt: "Fader", Type: 3},
+		},
+		TypeIndex: map[uint32]topology.TopologyHWcTypeDef{
+			1: {W: 100, H: 100, Out: "rgb", In: "b"},
+			2: {W: 100, Out: "rgb", In: "pb"},
+			3: {W: 50, H: 300, In: "av"},
+		},
+	}
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestEmulatorWithGorwp(t *testing.T) {
+	emu := New(testTopology(), &Config{Name: "Test Panel"})
+	defer emu.Close()
+	addr, err := emu.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	rp, err := gorwp.Connect(addr.String(), ctx, cancel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rp.State.GetModel() != "SK_EMULATOR" || rp.State.GetName() != "Test Panel" {
+		t.Fatalf("unexpected panel info: %s, %s", rp.State.GetModel(), rp.State.GetName())
+	}
+	if len(rp.State.GetTopology().GetHWCs()) != 3 {
+		t.Fatal("topology not received")
+	}
+
+	rp.SetBrightness(5)
+	rp.SetLEDColor(1, color.RGBA{255, 0, 0, 255}, rwp.HWCMode_ON)
+	rp.SetRWPText(2, "Title", "Line 1", "", false)
+
+	waitFor(t, "feedback state", func() bool {
+		state := emu.State(1)
+		text := emu.State(2)
+		return state != nil && state.HWCColor != nil && state.HWCMode.State == rwp.HWCMode_ON &&
+			text != nil && text.HWCText != nil && text.HWCText.Title == "Title" &&
+			emu.Brightness() != nil && emu.Brightness().LEDs == 5
+	})
+}
+
+func TestEmulatorASCIIWithConnectToPanel(t *testing.T) {
+	emu := New(testTopology(), &Config{Encoding: EncodingASCII})
+	defer emu.Close()
+	addr, err := emu.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	toPanel := make(chan []*rwp.InboundMessage, 10)
+	fromPanel := make(chan []*rwp.OutboundMessage, 10)
+	connected := make(chan bool, 1)
+	events := make(chan helpers.ConnectionEvent, 20)
+	go helpers.ConnectToPanel(addr.String(), toPanel, fromPanel, ctx, &wg, func(errorMsg string, binary bool, _ net.Conn) { connected <- binary }, nil, &helpers.ConnectToPanelConfig{Events: events})
+
+	select {
+	case binary := <-connected:
+		if binary {
+			t.Fatal("expected ASCII mode")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("not connected")
+	}
+
+	toPanel <- []*rwp.InboundMessage{{Command: &rwp.Command{SendPanelInfo: true}}}
+	toPanel <- []*rwp.InboundMessage{{States: []*rwp.HWCState{{HWCIDs: []uint32{1, 2}, HWCMode: &rwp.HWCMode{State: rwp.HWCMode_DIMMED}}}}}
+
+	timeout := time.After(5 * time.Second)
+	for gotModel := false; !gotModel; {
+		select {
+		case msgs := <-fromPanel:
+			for _, msg := range msgs {
+				if msg.PanelInfo != nil && msg.PanelInfo.Model == "SK_EMULATOR" {
+					gotModel = true
+				}
+			}
+		case <-timeout:
+			t.Fatal("no panel info received")
+		}
+	}
+	waitFor(t, "feedback state", func() bool {
+		state := emu.State(2)
+		return state != nil && state.HWCMode != nil && state.HWCMode.State == rwp.HWCMode_DIMMED
+	})
+
+	cancel()
+	wg.Wait()
+
+	// Lifecycle events in order:
+	close(events)
+	got := []string{}
+	for ev := range events {
+		switch ev := ev.(type) {
+		case *helpers.DialingEvent:
+			got = append(got, "dialing")
+		case *helpers.ProtocolDetectedEvent:
+			got = append(got, "detected:"+ev.Encoding.String())
+		case *helpers.ConnectedEvent:
+			got = append(got, "connected")
+		case *helpers.DisconnectedEvent:
+			if ev.BytesIn == 0 || ev.BytesOut == 0 {
+				t.Errorf("no bytes counted: %+v", ev)
+			}
+			got = append(got, "disconnected")
+		case *helpers.StoppedEvent:
+			got = append(got, "stopped")
+		}
+	}
+	want := []string{"dialing", "detected:ASCII", "connected", "disconnected", "stopped"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("got events %v, want %v", got, want)
+	}
+}
+
+func TestLivenessDetectsHungPanel(t *testing.T) {
+	emu := New(testTopology(), nil)
+	defer emu.Close()
+	addr, err := emu.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	toPanel := make(chan []*rwp.InboundMessage, 10)
+	fromPanel := make(chan []*rwp.OutboundMessage, 10)
+	events := make(chan helpers.ConnectionEvent, 20)
+	liveness := helpers.NewLivenessMonitor(&helpers.HeartbeatConfig{Interval: 20 * time.Millisecond, MaxMissed: 3})
+	go helpers.ConnectToPanel(addr.String(), toPanel, fromPanel, ctx, &wg, nil, nil, &helpers.ConnectToPanelConfig{Liveness: liveness, Events: events, RetryPolicy: &helpers.FixedRetryPolicy{Delay: time.Hour}})
+	go func() {
+		for range fromPanel {
+		}
+	}()
+
+	waitFor(t, "round trips", func() bool { return liveness.Stats().Count >= 3 })
+	emu.SetUnresponsive(true)
+
+	timeout := time.After(5 * time.Second)
+	for done := false; !done; {
+		select {
+		case ev := <-events:
+			if ev, ok := ev.(*helpers.DisconnectedEvent); ok {
+				if ev.Cause != helpers.ErrPanelNotResponding {
+					t.Fatalf("unexpected disconnect cause: %v", ev.Cause)
+				}
+				done = true
+			}
+		case <-timeout:
+			t.Fatal("hung panel not detected")
+		}
+	}
+
+	cancel()
+	wg.Wait()
+	close(fromPanel)
+}
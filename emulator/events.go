@@ -0,0 +1,198 @@
+/*
+   Copyright 2026 SKAARHOJ ApS
+
+   Released under MIT License
+*/
+
+package emulator
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+	"gopkg.in/yaml.v3"
+
+	rwp "github.com/SKAARHOJ/rawpanel-lib/ibeam_rawpanel"
+	"github.com/SKAARHOJ/rawpanel-lib/internal/panelserver"
+	log "github.com/s00500/env_logger"
+)
+
+// Function SendEvents sends raw events to all connected systems as
+// one OutboundMessage. Events without a Timestamp get the emulator
+// time in milliseconds, like a panel would set it.
+func (e *Emulator) SendEvents(events ...*rwp.HWCEvent) {
+	timestamp := e.timestamp()
+	for _, event := range events {
+		if event.Timestamp == 0 {
+			event.Timestamp = timestamp
+		}
+	}
+
+	e.broadcast([]*rwp.OutboundMessage{{Events: events}})
+}
+
+// Function Press sends a binary down event for a HWC. Edge is
+// BinaryEvent_UNKNOWN for normal buttons.
+func (e *Emulator) Press(hwc uint32, edge rwp.BinaryEvent_EdgeID) {
+	e.SendEvents(&rwp.HWCEvent{HWCID: hwc, Binary: &rwp.BinaryEvent{Pressed: true, Edge: edge}})
+}
+
+// Function Release sends a binary up event for a HWC
+func (e *Emulator) Release(hwc uint32, edge rwp.BinaryEvent_EdgeID) {
+	e.SendEvents(&rwp.HWCEvent{HWCID: hwc, Binary: &rwp.BinaryEvent{Pressed: false, Edge: edge}})
+}
+
+// Function Pulse sends a pulsed (encoder) event, value is typically +1/-1
+func (e *Emulator) Pulse(hwc uint32, value int32) {
+	e.SendEvents(&rwp.HWCEvent{HWCID: hwc, Pulsed: &rwp.PulsedEvent{Value: value}})
+}
+
+// Function Absolute sends an absolute (fader) event with a value
+// 0-1000. PrevValue is set from the previous absolute event on the HWC.
+func (e *Emulator) Absolute(hwc uint32, value uint32) {
+	e.mu.Lock()
+	prevValue := e.absoluteValues[hwc]
+	e.absoluteValues[hwc] = value
+	e.mu.Unlock()
+
+	e.SendEvents(&rwp.HWCEvent{HWCID: hwc, Absolute: &rwp.AbsoluteEvent{Value: value, PrevValue: prevValue}})
+}
+
+// Function Speed sends an intensity (joystick) event with a value
+// -500 to 500. PrevValue is set from the previous speed event on the HWC.
+func (e *Emulator) Speed(hwc uint32, value int32) {
+	e.mu.Lock()
+	prevValue := e.speedValues[hwc]
+	e.speedValues[hwc] = value
+	e.mu.Unlock()
+
+	e.SendEvents(&rwp.HWCEvent{HWCID: hwc, Speed: &rwp.SpeedEvent{Value: value, PrevValue: prevValue}})
+}
+
+// Function RawAnalog sends a raw analog (ADC) value event
+func (e *Emulator) RawAnalog(hwc uint32, value uint32) {
+	e.SendEvents(&rwp.HWCEvent{HWCID: hwc, RawAnalog: &rwp.RawAnalogEvent{Value: value}})
+}
+
+// Panel timestamp: Milliseconds since the emulator was started
+func (e *Emulator) timestamp() uint32 {
+	return uint32(time.Since(e.startTime).Milliseconds())
+}
+
+// Sends messages to all connected systems
+func (e *Emulator) broadcast(msgs []*rwp.OutboundMessage) {
+	e.mu.RLock()
+	clients := make([]*panelserver.Conn, 0, len(e.clients))
+	for c := range e.clients {
+		clients = append(clients, c)
+	}
+	e.mu.RUnlock()
+
+	for _, c := range clients {
+		// Each client gets its own copy since the ASCII converter and marshalling may run concurrently:
+		clientMsgs := make([]*rwp.OutboundMessage, len(msgs))
+		for i, msg := range msgs {
+			clientMsgs[i] = proto.Clone(msg).(*rwp.OutboundMessage)
+		}
+		log.Should(c.Send(clientMsgs))
+	}
+}
+
+// Type Script is a timed sequence of events, typically loaded from a
+// YAML or JSON file:
+//
+//	steps:
+//	  - {delay: 100ms, hwc: 1, action: press}
+//	  - {delay: 50ms, hwc: 1, action: release}
+//	  - {hwc: 5, action: pulse, value: -1}
+//	  - {delay: 1s, hwc: 9, action: absolute, value: 500}
+type Script struct {
+	Steps []ScriptStep `yaml:"steps" json:"steps"`
+}
+
+// Type ScriptStep is a single event in a script
+type ScriptStep struct {
+	Delay  time.Duration `yaml:"delay" json:"delay"`   // Time to wait before the step, relative to the previous step (e.g. "250ms")
+	HWC    uint32        `yaml:"hwc" json:"hwc"`       // Hardware component ID
+	Action string        `yaml:"action" json:"action"` // press, release, pulse, absolute, speed or raw
+	Edge   string        `yaml:"edge" json:"edge"`     // For press/release: top, left, bottom, right or encoder. Empty for normal buttons.
+	Value  int           `yaml:"value" json:"value"`   // For pulse, absolute, speed and raw
+}
+
+var scriptEdges = map[string]rwp.BinaryEvent_EdgeID{
+	"":        rwp.BinaryEvent_UNKNOWN,
+	"top":     rwp.BinaryEvent_TOP,
+	"left":    rwp.BinaryEvent_LEFT,
+	"bottom":  rwp.BinaryEvent_BOTTOM,
+	"right":   rwp.BinaryEvent_RIGHT,
+	"encoder": rwp.BinaryEvent_ENCODER,
+}
+
+// Function ParseScript parses a script in YAML or JSON (which is
+// valid YAML) and validates all steps.
+func ParseScript(data []byte) (*Script, error) {
+	script := &Script{}
+	if err := yaml.Unmarshal(data, script); err != nil {
+		return nil, err
+	}
+
+	for i, step := range script.Steps {
+		if _, exists := scriptEdges[strings.ToLower(step.Edge)]; !exists {
+			return nil, fmt.Errorf("step %d: unknown edge %q", i, step.Edge)
+		}
+		switch step.Action {
+		case "press", "release", "pulse", "absolute", "speed", "raw":
+		default:
+			return nil, fmt.Errorf("step %d: unknown action %q", i, step.Action)
+		}
+	}
+
+	return script, nil
+}
+
+// Function LoadScript reads and parses a script file
+func LoadScript(path string) (*Script, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseScript(data)
+}
+
+// Function RunScript sends the events of a script to all connected
+// systems with the delays of the script. It blocks until the script
+// is done or the context is cancelled.
+func (e *Emulator) RunScript(ctx context.Context, script *Script) error {
+	for _, step := range script.Steps {
+		if step.Delay > 0 {
+			timer := time.NewTimer(step.Delay)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			case <-timer.C:
+			}
+		}
+
+		edge := scriptEdges[strings.ToLower(step.Edge)]
+		switch step.Action {
+		case "press":
+			e.Press(step.HWC, edge)
+		case "release":
+			e.Release(step.HWC, edge)
+		case "pulse":
+			e.Pulse(step.HWC, int32(step.Value))
+		case "absolute":
+			e.Absolute(step.HWC, uint32(step.Value))
+		case "speed":
+			e.Speed(step.HWC, int32(step.Value))
+		case "raw":
+			e.RawAnalog(step.HWC, uint32(step.Value))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package emulator
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	gorwp "github.com/SKAARHOJ/rawpanel-lib/gorwp"
+)
+
+func TestScriptWithGorwpBindings(t *testing.T) {
+	script, err := ParseScript([]byte(`{"steps": [
+		{"hwc": 1, "action": "press", "edge": "top"},
+		{"delay": "20ms", "hwc": 1, "action": "release", "edge": "top"},
+		{"hwc": 2, "action": "pulse", "value": -1},
+		{"hwc": 3, "action": "absolute", "value": 250},
+		{"hwc": 3, "action": "absolute", "value": 750}
+	]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if script.Steps[1].Delay != 20*time.Millisecond {
+		t.Fatalf("unexpected delay %v", script.Steps[1].Delay)
+	}
+	if _, err := ParseScript([]byte("steps:\n  - {hwc: 1, action: wiggle}\n")); err == nil {
+		t.Fatal("expected error for unknown action")
+	}
+
+	emu := New(testTopology(), nil)
+	defer emu.Close()
+	addr, err := emu.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	rp, err := gorwp.Connect(addr.String(), ctx, cancel)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	received := []string{}
+	record := func(s string) {
+		mu.Lock()
+		received = append(received, s)
+		mu.Unlock()
+	}
+	rp.BindBinary(1, func(hwc uint32, status gorwp.BinaryStatus, edge gorwp.BinaryEdge) {
+		if edge == gorwp.Top {
+			record(map[gorwp.BinaryStatus]string{gorwp.Down: "down", gorwp.Up: "up"}[status])
+		}
+	})
+	rp.BindPulsed(2, func(hwc uint32, value int) {
+		if value == -1 {
+			record("pulse")
+		}
+	})
+	rp.BindAbsolute(3, func(hwc uint32, value int) {
+		record("abs")
+	})
+
+	if err := emu.RunScript(ctx, script); err != nil {
+		t.Fatal(err)
+	}
+
+	waitFor(t, "script events", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(received) == 5
+	})
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{"down", "up", "pulse", "abs", "abs"}
+	for i := range want {
+		if received[i] != want[i] {
+			t.Fatalf("got events %v, want %v", received, want)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+/*
+   Copyright 2026 SKAARHOJ ApS
+
+   Released under MIT License
+*/
+
+package emulator
+
+import (
+	"context"
+
+	helpers "github.com/SKAARHOJ/rawpanel-lib"
+	rwp "github.com/SKAARHOJ/rawpanel-lib/ibeam_rawpanel"
+)
+
+// Function Replay plays a recording back with the emulator in the
+// role of the recorded panel: Messages the panel sent are sent to all
+// connected systems, and feedback the system sent is applied to the
+// emulator state (see State) as if it was received. Speed is like in
+// helpers.ReplayConfig. It blocks until the recording is done or the
+// context is cancelled.
+func (e *Emulator) Replay(ctx context.Context, recording *helpers.RecordingReader, speed float64) error {
+	return helpers.Replay(ctx, recording, helpers.ReplayConfig{
+		Speed:     speed,
+		FromPanel: e.broadcast,
+		ToPanel: func(msgs []*rwp.InboundMessage) {
+			for _, msg := range msgs {
+				e.applyStates(msg.States)
+			}
+		},
+	})
+}
@@ -0,0 +1,24 @@
+/*
+   Copyright 2026 SKAARHOJ ApS
+
+   Released under MIT License
+*/
+
+// Package expvarmetrics publishes connection metrics with expvar. It is
+// kept out of the main package because importing expvar registers
+// /debug/vars on http.DefaultServeMux.
+
+package expvarmetrics
+
+import (
+	"expvar"
+
+	helpers "github.com/SKAARHOJ/rawpanel-lib"
+)
+
+// Publishes the metrics as an expvar with the given name (shown on /debug/vars). Like expvar.Publish, it panics if the name is already in use
+func Publish(name string, metrics *helpers.ConnectionMetrics) {
+	expvar.Publish(name, expvar.Func(func() interface{} {
+		return metrics.Snapshot()
+	}))
+}
@@ -0,0 +1,23 @@
+package expvarmetrics
+
+import (
+	"expvar"
+	"strings"
+	"testing"
+
+	helpers "github.com/SKAARHOJ/rawpanel-lib"
+)
+
+func TestPublish(t *testing.T) {
+	metrics := helpers.NewConnectionMetrics("panel")
+	metrics.ConnectionUp()
+	Publish("rawpanel_test", metrics)
+
+	v := expvar.Get("rawpanel_test")
+	if v == nil {
+		t.Fatal("not published")
+	}
+	if !strings.Contains(v.String(), `"Connected":true`) {
+		t.Fatalf("unexpected value %s", v.String())
+	}
+}
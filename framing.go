@@ -0,0 +1,172 @@
+package rawpanellib
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// Default max payload size of a binary frame
+const DefaultMaxFrameSize = 500000
+
+// Time allowed for the payload of a frame to arrive once the header is read (if the reader supports deadlines)
+const DefaultFramePayloadTimeout = 2 * time.Second
+
+// Kinds of frame errors. Use errors.Is to check which one a *FrameError is
+var (
+	ErrFrameOversize  = errors.New("frame exceeds max size")
+	ErrFrameTruncated = errors.New("frame truncated")
+	ErrFrameDecode    = errors.New("frame could not be decoded")
+)
+
+// Error reading or writing a binary frame
+type FrameError struct {
+	Kind  error  // ErrFrameOversize, ErrFrameTruncated or ErrFrameDecode
+	Size  uint32 // Payload size from the header
+	Cause error  // Underlying error, if any
+}
+
+func (fe *FrameError) Error() string {
+	if fe.Cause != nil {
+		return fmt.Sprintf("%s (%d bytes): %s", fe.Kind, fe.Size, fe.Cause)
+	}
+	return fmt.Sprintf("%s (%d bytes)", fe.Kind, fe.Size)
+}
+
+func (fe *FrameError) Unwrap() error {
+	return fe.Kind
+}
+
+// True if the stream can't be trusted after this error and the connection should be closed.
+// A frame which fails to decode was still read completely, so the next frame can be read as usual.
+func (fe *FrameError) Fatal() bool {
+	return fe.Kind != ErrFrameDecode
+}
+
+// Reports whether err is a *FrameError after which the connection can still be used
+func IsRecoverableFrameError(err error) bool {
+	var fe *FrameError
+	return errors.As(err, &fe) && !fe.Fatal()
+}
+
+// Buffers for frame payloads, shared by all readers and writers
+var frameBufferPool = sync.Pool{
+	New: func() interface{} {
+		buf := make([]byte, 0, 1024)
+		return &buf
+	},
+}
+
+func getFrameBuffer(size int) *[]byte {
+	buf := frameBufferPool.Get().(*[]byte)
+	if cap(*buf) < size {
+		*buf = make([]byte, size)
+	}
+	*buf = (*buf)[:size]
+	return buf
+}
+
+func putFrameBuffer(buf *[]byte) {
+	if cap(*buf) > DefaultMaxFrameSize { // Don't keep huge buffers around
+		return
+	}
+	frameBufferPool.Put(buf)
+}
+
+// Reads length prefixed protobuf frames (4 bytes little endian payload length, then the payload) from a binary panel or system
+type FrameReader struct {
+	r              io.Reader
+	maxSize        uint32
+	PayloadTimeout time.Duration // Time allowed for the payload once the header is read, if r has SetReadDeadline. Default DefaultFramePayloadTimeout, negative disables
+}
+
+// Creates a FrameReader. maxSize is the max payload size, 0 means DefaultMaxFrameSize
+func NewFrameReader(r io.Reader, maxSize int) *FrameReader {
+	if maxSize <= 0 {
+		maxSize = DefaultMaxFrameSize
+	}
+	return &FrameReader{r: r, maxSize: uint32(maxSize), PayloadTimeout: DefaultFramePayloadTimeout}
+}
+
+// Reads the next frame into msg. Errors from the underlying reader while waiting for a header (like io.EOF) are returned as is, everything else as a *FrameError.
+// After a recoverable error (see IsRecoverableFrameError) the next frame can be read.
+func (fr *FrameReader) ReadMessage(msg proto.Message) error {
+	deadliner, hasDeadline := fr.r.(interface{ SetReadDeadline(time.Time) error })
+	if hasDeadline && fr.PayloadTimeout >= 0 {
+		deadliner.SetReadDeadline(time.Time{}) // No deadline while waiting for the header
+	}
+
+	header := make([]byte, 4)
+	if n, err := io.ReadFull(fr.r, header); err != nil {
+		if n > 0 {
+			return &FrameError{Kind: ErrFrameTruncated, Cause: err}
+		}
+		return err
+	}
+
+	size := binary.LittleEndian.Uint32(header)
+	if size > fr.maxSize {
+		return &FrameError{Kind: ErrFrameOversize, Size: size} // The stream is out of sync or the peer misbehaves, we can't tell where the next frame starts
+	}
+
+	if hasDeadline && fr.PayloadTimeout >= 0 {
+		timeout := fr.PayloadTimeout
+		if timeout == 0 {
+			timeout = DefaultFramePayloadTimeout
+		}
+		deadliner.SetReadDeadline(time.Now().Add(timeout)) // Helps a run-away scenario where not all data arrives or we read a wrong header
+	}
+
+	buf := getFrameBuffer(int(size))
+	defer putFrameBuffer(buf)
+	if _, err := io.ReadFull(fr.r, *buf); err != nil {
+		return &FrameError{Kind: ErrFrameTruncated, Size: size, Cause: err}
+	}
+
+	if err := proto.Unmarshal(*buf, msg); err != nil {
+		return &FrameError{Kind: ErrFrameDecode, Size: size, Cause: err}
+	}
+	return nil
+}
+
+// Writes length prefixed protobuf frames. Safe for concurrent use; every frame is written with a single Write
+type FrameWriter struct {
+	sync.Mutex
+	w       io.Writer
+	maxSize int
+}
+
+// Creates a FrameWriter. maxSize is the max payload size, 0 means DefaultMaxFrameSize
+func NewFrameWriter(w io.Writer, maxSize int) *FrameWriter {
+	if maxSize <= 0 {
+		maxSize = DefaultMaxFrameSize
+	}
+	return &FrameWriter{w: w, maxSize: maxSize}
+}
+
+// Writes msg as one frame. A message larger than the max size is not written and an ErrFrameOversize *FrameError is returned
+func (fw *FrameWriter) WriteMessage(msg proto.Message) error {
+	size := proto.Size(msg)
+	if size > fw.maxSize {
+		return &FrameError{Kind: ErrFrameOversize, Size: uint32(size)}
+	}
+
+	buf := getFrameBuffer(4)
+	defer putFrameBuffer(buf)
+	data, err := proto.MarshalOptions{}.MarshalAppend(*buf, msg)
+	if err != nil {
+		return err
+	}
+	*buf = data
+	binary.LittleEndian.PutUint32(data[0:4], uint32(len(data)-4))
+
+	fw.Lock()
+	defer fw.Unlock()
+	_, err = fw.w.Write(data)
+	return err
+}
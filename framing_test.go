@@ -0,0 +1,59 @@
+package rawpanellib
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+
+	rwp "github.com/SKAARHOJ/rawpanel-lib/ibeam_rawpanel"
+)
+
+func TestFrameReaderWriter(t *testing.T) {
+	var stream bytes.Buffer
+	fw := NewFrameWriter(&stream, 100)
+	if err := fw.WriteMessage(&rwp.OutboundMessage{FlowMessage: rwp.OutboundMessage_ACK}); err != nil {
+		t.Fatal(err)
+	}
+	if err := fw.WriteMessage(&rwp.OutboundMessage{PanelInfo: &rwp.PanelInfo{Name: string(make([]byte, 200))}}); !errors.Is(err, ErrFrameOversize) {
+		t.Fatalf("expected oversize error when writing, got %v", err)
+	}
+
+	// A frame which doesn't decode, followed by a good one:
+	stream.Write([]byte{2, 0, 0, 0, 0xff, 0xff})
+	fw.WriteMessage(&rwp.OutboundMessage{PanelInfo: &rwp.PanelInfo{Model: "SK_TEST"}})
+
+	// An oversize header:
+	header := make([]byte, 4)
+	binary.LittleEndian.PutUint32(header, 1000)
+	stream.Write(header)
+
+	fr := NewFrameReader(&stream, 100)
+	msg := &rwp.OutboundMessage{}
+	if err := fr.ReadMessage(msg); err != nil || msg.FlowMessage != rwp.OutboundMessage_ACK {
+		t.Fatalf("expected ACK, got %v, %v", msg, err)
+	}
+	err := fr.ReadMessage(&rwp.OutboundMessage{})
+	if !errors.Is(err, ErrFrameDecode) || !IsRecoverableFrameError(err) {
+		t.Fatalf("expected recoverable decode error, got %v", err)
+	}
+	msg = &rwp.OutboundMessage{}
+	if err := fr.ReadMessage(msg); err != nil || msg.GetPanelInfo().GetModel() != "SK_TEST" {
+		t.Fatalf("expected panel info after decode error, got %v, %v", msg, err)
+	}
+	err = fr.ReadMessage(&rwp.OutboundMessage{})
+	if !errors.Is(err, ErrFrameOversize) || IsRecoverableFrameError(err) {
+		t.Fatalf("expected fatal oversize error, got %v", err)
+	}
+
+	// Truncated payload, then EOF:
+	stream.Reset()
+	stream.Write([]byte{10, 0, 0, 0, 1, 2})
+	if err := fr.ReadMessage(&rwp.OutboundMessage{}); !errors.Is(err, ErrFrameTruncated) {
+		t.Fatalf("expected truncated error, got %v", err)
+	}
+	if err := fr.ReadMessage(&rwp.OutboundMessage{}); err != io.EOF {
+		t.Fatalf("expected EOF, got %v", err)
+	}
+}
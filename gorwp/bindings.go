@@ -0,0 +1,279 @@
+/*
+   Copyright 2026 SKAARHOJ ApS
+
+   Released under MIT License
+*/
+
+package gorwp
+
+import (
+	"sync"
+
+	su "github.com/SKAARHOJ/ibeam-lib-utils"
+	rwp "github.com/SKAARHOJ/rawpanel-lib/ibeam_rawpanel"
+	topology "github.com/SKAARHOJ/rawpanel-lib/topology"
+	"go.uber.org/atomic"
+)
+
+// Type HWCSelector decides which hardware components a wildcard binding
+// covers. The topology is the one reported by the panel, and may be nil
+// before it arrived.
+type HWCSelector func(hwc uint32, top *topology.Topology) bool
+
+// Function AllHWCs selects every hardware component
+func AllHWCs() HWCSelector {
+	return func(hwc uint32, top *topology.Topology) bool { return true }
+}
+
+// Function HWCs selects a set of hardware components by ID
+func HWCs(hwcs ...uint32) HWCSelector {
+	set := make(map[uint32]bool, len(hwcs))
+	for _, hwc := range hwcs {
+		set[hwc] = true
+	}
+	return func(hwc uint32, top *topology.Topology) bool { return set[hwc] }
+}
+
+// Type InputType is a kind of input found in the topology
+type InputType string
+
+const (
+	InputBinary    InputType = "Binary"    // Buttons, GPIs and pushable encoders
+	InputPulsed    InputType = "Pulsed"    // Encoders
+	InputAbsolute  InputType = "Absolute"  // Faders and T-bars
+	InputIntensity InputType = "Intensity" // Joysticks
+)
+
+// Function HWCsWithInput selects all hardware components with a given
+// type of input in the topology, e.g. HWCsWithInput(InputPulsed) for all
+// encoders. Nothing is selected until the topology is known.
+func HWCsWithInput(inputType InputType) HWCSelector {
+	return func(hwc uint32, top *topology.Topology) bool {
+		if top == nil {
+			return false
+		}
+		typeDef, err := top.GetHWCtype(hwc)
+		if err != nil {
+			return false
+		}
+		switch inputType {
+		case InputBinary:
+			return typeDef.IsBinary()
+		case InputPulsed:
+			return typeDef.IsPulsed()
+		case InputAbsolute:
+			return typeDef.IsAbsolute()
+		case InputIntensity:
+			return typeDef.IsIntensity()
+		}
+		return false
+	}
+}
+
+// Type Binding is a handle for a bound function, returned by the Bind
+// methods
+type Binding struct {
+	registry *bindingRegistry
+	id       uint64
+}
+
+// Removes the binding. Calling it more than once does nothing.
+func (b *Binding) Unbind() {
+	if b != nil {
+		b.registry.remove(b.id)
+	}
+}
+
+// Type bindingSlot is the kind of a binding which replaces the previous one
+// of the same kind on its HWC, as the Bind methods for single HWCs do. The
+// order is the order such bindings are called in.
+type bindingSlot int
+
+const (
+	slotNone bindingSlot = iota // Stacking binding
+	slotTrigger
+	slotBinary
+	slotPulsed
+	slotAbsolute
+	slotIntensity
+)
+
+type binding struct {
+	id       uint64
+	slot     bindingSlot
+	selector HWCSelector // Nil for bindings of a single HWC
+	handle   func(hwc uint32, event *rwp.HWCEvent)
+}
+
+// The bindings at one point in time. Never modified once published.
+type bindingSet struct {
+	byHWC     map[uint32][]*binding // Bindings with a slot first, ordered by slot, then the stacking ones
+	wildcards []*binding
+}
+
+// Type bindingRegistry holds the functions bound to events. Binding and
+// unbinding copy the set of bindings (copy-on-write), so events are
+// dispatched without locking, also while functions bind and unbind.
+type bindingRegistry struct {
+	mu     sync.Mutex // Serializes writers
+	nextID uint64
+	set    atomic.Value // *bindingSet
+}
+
+func newBindingRegistry() *bindingRegistry {
+	registry := &bindingRegistry{}
+	registry.set.Store(&bindingSet{byHWC: make(map[uint32][]*binding)})
+	return registry
+}
+
+func (br *bindingRegistry) load() *bindingSet {
+	return br.set.Load().(*bindingSet)
+}
+
+// Adds a binding for one HWC, or for the HWCs of a selector if hwc is ignored (selector not nil)
+func (br *bindingRegistry) add(hwc uint32, selector HWCSelector, handle func(hwc uint32, event *rwp.HWCEvent)) *Binding {
+	return br.addToSlot(hwc, selector, slotNone, handle)
+}
+
+// Binds to one HWC, replacing the binding in the same slot
+func (br *bindingRegistry) replace(hwc uint32, slot bindingSlot, handle func(hwc uint32, event *rwp.HWCEvent)) *Binding {
+	return br.addToSlot(hwc, nil, slot, handle)
+}
+
+func (br *bindingRegistry) addToSlot(hwc uint32, selector HWCSelector, slot bindingSlot, handle func(hwc uint32, event *rwp.HWCEvent)) *Binding {
+	br.mu.Lock()
+	defer br.mu.Unlock()
+	br.nextID++
+	b := &binding{id: br.nextID, slot: slot, selector: selector, handle: handle}
+
+	current := br.load()
+	next := &bindingSet{byHWC: make(map[uint32][]*binding, len(current.byHWC)+1), wildcards: current.wildcards}
+	for k, v := range current.byHWC {
+		next.byHWC[k] = v
+	}
+	if selector != nil {
+		next.wildcards = append(append([]*binding{}, current.wildcards...), b)
+	} else if slot == slotNone {
+		next.byHWC[hwc] = append(append([]*binding{}, current.byHWC[hwc]...), b)
+	} else {
+		bindings := []*binding{}
+		inserted := false
+		for _, existing := range current.byHWC[hwc] {
+			if existing.slot == slot {
+				continue // Replaced
+			}
+			if !inserted && (existing.slot == slotNone || existing.slot > slot) {
+				bindings = append(bindings, b)
+				inserted = true
+			}
+			bindings = append(bindings, existing)
+		}
+		if !inserted {
+			bindings = append(bindings, b)
+		}
+		next.byHWC[hwc] = bindings
+	}
+	br.set.Store(next)
+	return &Binding{registry: br, id: b.id}
+}
+
+func (br *bindingRegistry) remove(id uint64) {
+	br.mu.Lock()
+	defer br.mu.Unlock()
+
+	without := func(bindings []*binding) ([]*binding, bool) {
+		for i, b := range bindings {
+			if b.id == id {
+				return append(append([]*binding{}, bindings[:i]...), bindings[i+1:]...), true
+			}
+		}
+		return bindings, false
+	}
+
+	current := br.load()
+	next := &bindingSet{byHWC: make(map[uint32][]*binding, len(current.byHWC)), wildcards: current.wildcards}
+	found := false
+	for hwc, bindings := range current.byHWC {
+		if !found {
+			var removed bool
+			bindings, removed = without(bindings)
+			found = removed
+		}
+		if len(bindings) > 0 {
+			next.byHWC[hwc] = bindings
+		}
+	}
+	if !found {
+		next.wildcards, found = without(current.wildcards)
+	}
+	if found {
+		br.set.Store(next)
+	}
+}
+
+// Calls the functions bound to the HWC of an event through call: First
+// the ones bound with Bind (BindTrigger before the typed ones, like
+// always), then the ones bound with Add and the wildcards in the order
+// they were bound.
+func (br *bindingRegistry) dispatch(event *rwp.HWCEvent, getTopology func() *topology.Topology, call func(handle func(uint32, *rwp.HWCEvent))) {
+	set := br.load()
+	exact := set.byHWC[event.HWCID]
+	for len(exact) > 0 && exact[0].slot != slotNone {
+		call(exact[0].handle)
+		exact = exact[1:]
+	}
+	if len(set.wildcards) == 0 {
+		for _, b := range exact {
+			call(b.handle)
+		}
+		return
+	}
+
+	top := getTopology()
+	wildcards := set.wildcards
+	for len(exact) > 0 || len(wildcards) > 0 {
+		if len(wildcards) == 0 || (len(exact) > 0 && exact[0].id < wildcards[0].id) {
+			call(exact[0].handle)
+			exact = exact[1:]
+			continue
+		}
+		if wildcards[0].selector(event.HWCID, top) {
+			call(wildcards[0].handle)
+		}
+		wildcards = wildcards[1:]
+	}
+}
+
+// Event handlers calling the typed functions:
+
+func binaryHandler(f BinaryFunc) func(uint32, *rwp.HWCEvent) {
+	return func(hwc uint32, event *rwp.HWCEvent) {
+		if event.Binary != nil {
+			f(hwc, BinaryStatus(su.Qint(event.Binary.Pressed, 1, 0)), BinaryEdge(event.Binary.Edge))
+		}
+	}
+}
+
+func pulsedHandler(f PulsedFunc) func(uint32, *rwp.HWCEvent) {
+	return func(hwc uint32, event *rwp.HWCEvent) {
+		if event.Pulsed != nil {
+			f(hwc, int(event.Pulsed.Value))
+		}
+	}
+}
+
+func absoluteHandler(f AbsoluteFunc) func(uint32, *rwp.HWCEvent) {
+	return func(hwc uint32, event *rwp.HWCEvent) {
+		if event.Absolute != nil {
+			f(hwc, int(event.Absolute.Value))
+		}
+	}
+}
+
+func intensityHandler(f IntensityFunc) func(uint32, *rwp.HWCEvent) {
+	return func(hwc uint32, event *rwp.HWCEvent) {
+		if event.Speed != nil {
+			f(hwc, int(event.Speed.Value))
+		}
+	}
+}
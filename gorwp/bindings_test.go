@@ -0,0 +1,86 @@
+package gorwp_test
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	gorwp "github.com/SKAARHOJ/rawpanel-lib/gorwp"
+	rwp "github.com/SKAARHOJ/rawpanel-lib/ibeam_rawpanel"
+	"github.com/SKAARHOJ/rawpanel-lib/internal/testpanel"
+)
+
+func TestGorwpBindings(t *testing.T) {
+	emu, rp := testpanel.StartAndConnect(t, nil, nil)
+
+	var mu sync.Mutex
+	received := []string{}
+	record := func(s string) {
+		mu.Lock()
+		received = append(received, s)
+		mu.Unlock()
+	}
+	reset := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		r := received
+		received = []string{}
+		return r
+	}
+	await := func(want int) []string {
+		testpanel.WaitFor(t, fmt.Sprintf("%d calls", want), func() bool {
+			mu.Lock()
+			defer mu.Unlock()
+			return len(received) >= want
+		})
+		return reset()
+	}
+
+	added := rp.AddBinary(1, func(hwc uint32, status gorwp.BinaryStatus, edge gorwp.BinaryEdge) { record("added") })
+	replaced := rp.BindBinary(1, func(hwc uint32, status gorwp.BinaryStatus, edge gorwp.BinaryEdge) { record("replaced") })
+	bound := rp.BindBinary(1, func(hwc uint32, status gorwp.BinaryStatus, edge gorwp.BinaryEdge) { record("bound") })
+	rp.BindTrigger(1, func(hwc uint32, event *rwp.HWCEvent) { record("trigger") })
+	rp.BindTriggerTo(gorwp.HWCs(1, 3), func(hwc uint32, event *rwp.HWCEvent) { record(fmt.Sprintf("set %d", hwc)) })
+	rp.BindPulsedTo(gorwp.HWCsWithInput(gorwp.InputPulsed), func(hwc uint32, value int) { record(fmt.Sprintf("encoder %d", hwc)) })
+	all := rp.BindTriggerTo(gorwp.AllHWCs(), func(hwc uint32, event *rwp.HWCEvent) { record(fmt.Sprintf("all %d", hwc)) })
+
+	// Bind replaces, and its handlers come first (trigger before typed), then the others in the order they were added:
+	emu.Press(1, rwp.BinaryEvent_UNKNOWN)
+	if got := fmt.Sprint(await(5)); got != "[trigger bound added set 1 all 1]" {
+		t.Fatalf("unexpected calls %s", got)
+	}
+	emu.Pulse(2, 1)
+	if got := fmt.Sprint(await(2)); got != "[encoder 2 all 2]" {
+		t.Fatalf("unexpected calls %s", got)
+	}
+
+	// Unbinding a replaced handler does nothing:
+	replaced.Unbind()
+	added.Unbind()
+	all.Unbind()
+	emu.Press(1, rwp.BinaryEvent_UNKNOWN)
+	if got := fmt.Sprint(await(3)); got != "[trigger bound set 1]" {
+		t.Fatalf("unexpected calls after unbinding %s", got)
+	}
+	bound.Unbind()
+	bound.Unbind()
+	emu.Press(1, rwp.BinaryEvent_UNKNOWN)
+	if got := fmt.Sprint(await(2)); got != "[trigger set 1]" {
+		t.Fatalf("unexpected calls after unbinding %s", got)
+	}
+
+	// Binding and unbinding while events are dispatched:
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			rp.BindAbsolute(3, func(hwc uint32, value int) {}).Unbind()
+		}
+	}()
+	for i := 0; i < 20; i++ {
+		emu.Absolute(3, uint32(i))
+	}
+	wg.Wait()
+	await(20)
+}
@@ -0,0 +1,60 @@
+package gorwp_test
+
+import (
+	"context"
+	"crypto/tls"
+	"testing"
+	"time"
+
+	helpers "github.com/SKAARHOJ/rawpanel-lib"
+	"github.com/SKAARHOJ/rawpanel-lib/emulator"
+	gorwp "github.com/SKAARHOJ/rawpanel-lib/gorwp"
+	"github.com/SKAARHOJ/rawpanel-lib/internal/testpanel"
+)
+
+func TestGorwpForcedASCII(t *testing.T) {
+	_, rp := testpanel.StartAndConnect(t, nil, &gorwp.ConnectConfig{ProtocolMode: helpers.ProtocolASCII})
+	if rp.State.GetModel() != "SK_EMULATOR" {
+		t.Fatalf("unexpected model %s", rp.State.GetModel())
+	}
+}
+
+func TestGorwpMutualTLS(t *testing.T) {
+	pki := testpanel.NewPKI(t)
+	serverTLS, clientTLS := pki.MutualTLS(t)
+	_, addr := testpanel.Start(t, &emulator.Config{TLSConfig: serverTLS})
+
+	rp := testpanel.Connect(t, addr, &gorwp.ConnectConfig{TLSConfig: clientTLS})
+	if rp.State.GetModel() != "SK_EMULATOR" {
+		t.Fatalf("unexpected model %s", rp.State.GetModel())
+	}
+
+	// Without a client certificate the panel must not talk to us:
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if _, err := gorwp.ConnectWithConfig(addr, ctx, cancel, &gorwp.ConnectConfig{TLSConfig: &tls.Config{RootCAs: pki.Pool}}); err == nil {
+		t.Fatal("connected without client certificate")
+	}
+}
+
+func TestGorwpPipeTransport(t *testing.T) {
+	emu := emulator.New(testpanel.Topology(), nil)
+	t.Cleanup(emu.Close)
+
+	rp := testpanel.Connect(t, "emulator", &gorwp.ConnectConfig{Transport: helpers.PipeTransport(emu.ServeConn)})
+	if rp.State.GetModel() != "SK_EMULATOR" {
+		t.Fatalf("unexpected model %s", rp.State.GetModel())
+	}
+}
+
+// Cancelling while the panel initializes is not an error, a panel closing the connection is
+func TestGorwpInitCancelled(t *testing.T) {
+	emu, addr := testpanel.Start(t, nil)
+	emu.SetUnresponsive(true)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	if _, err := gorwp.ConnectWithConfig(addr, ctx, cancel, &gorwp.ConnectConfig{ProtocolMode: helpers.ProtocolBinary}); err != nil {
+		t.Fatalf("expected no error when cancelled, got %v", err)
+	}
+}
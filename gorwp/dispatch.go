@@ -0,0 +1,159 @@
+/*
+   Copyright 2026 SKAARHOJ ApS
+
+   Released under MIT License
+*/
+
+package gorwp
+
+import (
+	"context"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	rwp "github.com/SKAARHOJ/rawpanel-lib/ibeam_rawpanel"
+	topology "github.com/SKAARHOJ/rawpanel-lib/topology"
+	log "github.com/s00500/env_logger"
+)
+
+// Type DispatchOrdering decides which events are handled one after the
+// other
+type DispatchOrdering int
+
+const (
+	OrderGlobal DispatchOrdering = iota // All events in the order they arrived, by a single worker (default)
+	OrderPerHWC                         // Events of the same HWC in order, different HWCs in parallel
+	OrderNone                           // Any event on any worker
+)
+
+// Default time after which a handler is reported as slow
+const DefaultSlowHandlerThreshold = time.Second
+
+// Type DispatchConfig holds the settings for calling bound functions.
+// They are called on worker goroutines, so they can send feedback to the
+// panel (which waits for the goroutine reading from the panel) without
+// deadlocking.
+type DispatchConfig struct {
+	Ordering             DispatchOrdering                                      // Which events are handled in order
+	Workers              int                                                   // Number of workers for OrderPerHWC and OrderNone, default 4
+	SlowHandlerThreshold time.Duration                                         // Handlers still running after this are reported, default DefaultSlowHandlerThreshold. Negative disables it
+	OnSlowHandler        func(hwc uint32, running time.Duration)               // Called (in addition to a warning in the log) when a handler is slow (optional)
+	OnPanic              func(hwc uint32, recovered interface{}, stack []byte) // Called (in addition to an error in the log) when a handler panicked (optional)
+}
+
+// Type dispatcher hands events to workers. Queues are unbounded, so the
+// goroutine reading from the panel never waits for handlers.
+type dispatcher struct {
+	config   DispatchConfig
+	bindings *bindingRegistry
+	topology func() *topology.Topology
+	workers  []*dispatchWorker
+	next     int // Round robin for OrderNone
+}
+
+type dispatchWorker struct {
+	mu    sync.Mutex
+	queue []*rwp.HWCEvent
+	wake  chan struct{}
+}
+
+func newDispatcher(ctx context.Context, config *DispatchConfig, bindings *bindingRegistry, getTopology func() *topology.Topology) *dispatcher {
+	d := &dispatcher{bindings: bindings, topology: getTopology}
+	if config != nil {
+		d.config = *config
+	}
+	if d.config.SlowHandlerThreshold == 0 {
+		d.config.SlowHandlerThreshold = DefaultSlowHandlerThreshold
+	}
+	workers := 1
+	if d.config.Ordering != OrderGlobal {
+		workers = d.config.Workers
+		if workers <= 0 {
+			workers = 4
+		}
+	}
+	for i := 0; i < workers; i++ {
+		w := &dispatchWorker{wake: make(chan struct{}, 1)}
+		d.workers = append(d.workers, w)
+		go d.run(ctx, w)
+	}
+	return d
+}
+
+// Queues an event for its worker
+func (d *dispatcher) dispatch(event *rwp.HWCEvent) {
+	var w *dispatchWorker
+	switch d.config.Ordering {
+	case OrderPerHWC:
+		w = d.workers[int(event.HWCID%uint32(len(d.workers)))]
+	case OrderNone:
+		w = d.workers[d.next]
+		d.next = (d.next + 1) % len(d.workers)
+	default:
+		w = d.workers[0]
+	}
+
+	w.mu.Lock()
+	w.queue = append(w.queue, event)
+	w.mu.Unlock()
+	select {
+	case w.wake <- struct{}{}:
+	default: // Already woken up
+	}
+}
+
+// Handles the events of a worker until the context is done. Queued events are dropped then.
+func (d *dispatcher) run(ctx context.Context, w *dispatchWorker) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-w.wake:
+		}
+		for {
+			w.mu.Lock()
+			if len(w.queue) == 0 {
+				w.mu.Unlock()
+				break
+			}
+			event := w.queue[0]
+			w.queue[0] = nil
+			w.queue = w.queue[1:]
+			w.mu.Unlock()
+
+			if ctx.Err() != nil {
+				return
+			}
+			d.handle(event)
+		}
+	}
+}
+
+// Calls the bound functions for an event, each watched for being slow or panicking
+func (d *dispatcher) handle(event *rwp.HWCEvent) {
+	d.bindings.dispatch(event, d.topology, func(handle func(uint32, *rwp.HWCEvent)) {
+		if d.config.SlowHandlerThreshold > 0 {
+			started := time.Now()
+			watchdog := time.AfterFunc(d.config.SlowHandlerThreshold, func() {
+				running := time.Since(started)
+				log.Warnf("Handler for HWC %d still running after %s\n", event.HWCID, running.Round(time.Millisecond))
+				if d.config.OnSlowHandler != nil {
+					d.config.OnSlowHandler(event.HWCID, running)
+				}
+			})
+			defer watchdog.Stop()
+		}
+
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				stack := debug.Stack()
+				log.Errorf("Handler for HWC %d panicked: %v\n%s\n", event.HWCID, recovered, stack)
+				if d.config.OnPanic != nil {
+					d.config.OnPanic(event.HWCID, recovered, stack)
+				}
+			}
+		}()
+		handle(event.HWCID, event)
+	})
+}
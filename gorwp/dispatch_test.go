@@ -0,0 +1,73 @@
+package gorwp_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	gorwp "github.com/SKAARHOJ/rawpanel-lib/gorwp"
+	rwp "github.com/SKAARHOJ/rawpanel-lib/ibeam_rawpanel"
+	"github.com/SKAARHOJ/rawpanel-lib/internal/testpanel"
+	"go.uber.org/atomic"
+)
+
+// Handlers sending lots of feedback don't block the connection, and panicking or slow ones are reported
+func TestGorwpDispatch(t *testing.T) {
+	var panics, slow atomic.Int32
+	emu, rp := testpanel.StartAndConnect(t, nil, &gorwp.ConnectConfig{Dispatch: &gorwp.DispatchConfig{
+		Ordering:             gorwp.OrderPerHWC,
+		SlowHandlerThreshold: 50 * time.Millisecond,
+		OnPanic:              func(hwc uint32, recovered interface{}, stack []byte) { panics.Inc() },
+		OnSlowHandler:        func(hwc uint32, running time.Duration) { slow.Inc() },
+	}})
+
+	// Far more feedback than the channel to the panel holds:
+	var handled atomic.Int32
+	rp.BindBinary(1, func(hwc uint32, status gorwp.BinaryStatus, edge gorwp.BinaryEdge) {
+		if status == gorwp.Down {
+			for i := 0; i < 50; i++ {
+				rp.SetLEDColorByIndex(1, rwp.ColorIndex_Colors(i%16), rwp.HWCMode_ON)
+			}
+			handled.Inc()
+		}
+	})
+	for i := 0; i < 5; i++ {
+		emu.Press(1, rwp.BinaryEvent_UNKNOWN)
+	}
+	testpanel.WaitFor(t, "handlers sending feedback", func() bool { return handled.Load() == 5 })
+
+	// A panic doesn't stop the other handlers:
+	var afterPanic atomic.Int32
+	rp.BindPulsed(2, func(hwc uint32, value int) { panic("handler bug") })
+	rp.AddPulsed(2, func(hwc uint32, value int) { afterPanic.Inc() })
+	emu.Pulse(2, 1)
+	emu.Pulse(2, 1)
+	testpanel.WaitFor(t, "handlers after the panic", func() bool { return afterPanic.Load() == 2 && panics.Load() == 2 })
+
+	// A slow handler on HWC 3 is reported and doesn't hold up HWC 2, events of HWC 3 stay in order:
+	release := make(chan bool)
+	var mu sync.Mutex
+	values := []int{}
+	rp.BindAbsolute(3, func(hwc uint32, value int) {
+		if value == 100 {
+			<-release
+		}
+		mu.Lock()
+		values = append(values, value)
+		mu.Unlock()
+	})
+	emu.Absolute(3, 100)
+	emu.Absolute(3, 200)
+	emu.Pulse(2, 1)
+	testpanel.WaitFor(t, "HWC 2 while HWC 3 is busy", func() bool { return afterPanic.Load() == 3 })
+	testpanel.WaitFor(t, "slow handler report", func() bool { return slow.Load() >= 1 })
+	close(release)
+	testpanel.WaitFor(t, "HWC 3 events", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(values) == 2
+	})
+	if values[0] != 100 || values[1] != 200 {
+		t.Fatalf("events of HWC 3 out of order: %v", values)
+	}
+}
@@ -0,0 +1,172 @@
+/*
+   Copyright 2026 SKAARHOJ ApS
+
+   Released under MIT License
+*/
+
+package gorwp
+
+import (
+	"context"
+	"sync"
+
+	su "github.com/SKAARHOJ/ibeam-lib-utils"
+	rwp "github.com/SKAARHOJ/rawpanel-lib/ibeam_rawpanel"
+)
+
+// Type EventKind tells which of the fields of an Event are set
+type EventKind int
+
+const (
+	EventBinary    EventKind = iota + 1 // Status and Edge: Buttons, GPIs and pushed encoders
+	EventPulsed                         // Value is the number of pulses, negative to the left: Encoders
+	EventAbsolute                       // Value is the position 0-1000: Faders and T-bars
+	EventIntensity                      // Value is -500 to 500: Joysticks
+	EventRawAnalog                      // Value is the raw ADC reading of an analog input
+)
+
+func (ek EventKind) String() string {
+	switch ek {
+	case EventBinary:
+		return "Binary"
+	case EventPulsed:
+		return "Pulsed"
+	case EventAbsolute:
+		return "Absolute"
+	case EventIntensity:
+		return "Intensity"
+	case EventRawAnalog:
+		return "RawAnalog"
+	}
+	return "Unknown"
+}
+
+// Type Event is an event from a hardware component of the panel, for
+// consuming events with Events in a select loop instead of callbacks
+type Event struct {
+	Kind      EventKind
+	HWCID     uint32
+	Timestamp uint32 // Panel time in milliseconds the moment of the trigger
+
+	Status BinaryStatus // EventBinary
+	Edge   BinaryEdge   // EventBinary
+	Value  int          // EventPulsed, EventAbsolute, EventIntensity and EventRawAnalog
+
+	Raw *rwp.HWCEvent // The event as received from the panel
+}
+
+// Number of events buffered for a slow consumer of Events
+const eventBufferSize = 100
+
+// Function Events returns a channel receiving the events of the HWCs
+// picked by the selector, e.g. HWCs(1, 2, 3) or HWCsWithInput(InputPulsed),
+// or of all HWCs if the selector is nil. The channel is closed when the
+// context is done or the panel connection is closed.
+//
+// Events are delivered by the dispatcher like callbacks, so a consumer
+// which doesn't keep up holds up bound functions once the buffer is full.
+func (rp *RawPanel) Events(ctx context.Context, selector HWCSelector) <-chan Event {
+	if selector == nil {
+		selector = AllHWCs()
+	}
+	events := make(chan Event, eventBufferSize)
+	stop := make(chan struct{})
+
+	var mu sync.Mutex // Held while sending, so the channel isn't closed under a sending handler
+	closed := false
+	binding := rp.bindings.add(0, selector, func(hwc uint32, rawEvent *rwp.HWCEvent) {
+		event, ok := EventFromRaw(rawEvent)
+		if !ok {
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if closed {
+			return
+		}
+		select {
+		case events <- event:
+		case <-stop:
+		}
+	})
+
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-rp.done:
+		}
+		close(stop)
+		binding.Unbind()
+		mu.Lock()
+		closed = true
+		close(events)
+		mu.Unlock()
+	}()
+
+	return events
+}
+
+// Type EventIterator pulls events one at a time, for loops which can't
+// select on a channel
+type EventIterator struct {
+	events <-chan Event
+	cancel context.CancelFunc
+}
+
+// Function Iterate returns an iterator over the events of the HWCs picked
+// by the selector, or of all HWCs if the selector is nil. Events are
+// buffered like for Events. Close the iterator when done with it.
+//
+//	it := rp.Iterate(HWCsWithInput(InputBinary))
+//	defer it.Close()
+//	for event, ok := it.Next(ctx); ok; event, ok = it.Next(ctx) {
+//		...
+//	}
+func (rp *RawPanel) Iterate(selector HWCSelector) *EventIterator {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &EventIterator{events: rp.Events(ctx, selector), cancel: cancel}
+}
+
+// Waits for the next event. Returns false when ctx is done, the iterator
+// is closed or the panel connection is closed. After ctx is done, Next can
+// be called again with another context.
+func (it *EventIterator) Next(ctx context.Context) (Event, bool) {
+	select {
+	case event, ok := <-it.events:
+		return event, ok
+	case <-ctx.Done():
+		return Event{}, false
+	}
+}
+
+// Stops the iterator. Calling it more than once does nothing.
+func (it *EventIterator) Close() {
+	it.cancel()
+}
+
+// Function EventFromRaw converts a raw panel event, it returns false for
+// events without any of the known kinds
+func EventFromRaw(rawEvent *rwp.HWCEvent) (Event, bool) {
+	event := Event{HWCID: rawEvent.HWCID, Timestamp: rawEvent.Timestamp, Raw: rawEvent}
+	switch {
+	case rawEvent.Binary != nil:
+		event.Kind = EventBinary
+		event.Status = BinaryStatus(su.Qint(rawEvent.Binary.Pressed, 1, 0))
+		event.Edge = BinaryEdge(rawEvent.Binary.Edge)
+	case rawEvent.Pulsed != nil:
+		event.Kind = EventPulsed
+		event.Value = int(rawEvent.Pulsed.Value)
+	case rawEvent.Absolute != nil:
+		event.Kind = EventAbsolute
+		event.Value = int(rawEvent.Absolute.Value)
+	case rawEvent.Speed != nil:
+		event.Kind = EventIntensity
+		event.Value = int(rawEvent.Speed.Value)
+	case rawEvent.RawAnalog != nil:
+		event.Kind = EventRawAnalog
+		event.Value = int(rawEvent.RawAnalog.Value)
+	default:
+		return event, false
+	}
+	return event, true
+}
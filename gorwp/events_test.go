@@ -0,0 +1,113 @@
+package gorwp_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	gorwp "github.com/SKAARHOJ/rawpanel-lib/gorwp"
+	rwp "github.com/SKAARHOJ/rawpanel-lib/ibeam_rawpanel"
+	"github.com/SKAARHOJ/rawpanel-lib/internal/testpanel"
+)
+
+// Events are received in a select loop, filtered by HWC or input type, and the channel closes with its context
+func TestGorwpEventChannel(t *testing.T) {
+	emu, rp := testpanel.StartAndConnect(t, nil, nil)
+
+	ctx := context.Background()
+	allCtx, allCancel := context.WithCancel(ctx)
+	all := rp.Events(allCtx, nil)
+	buttons := rp.Events(ctx, gorwp.HWCs(1))
+	encoders := rp.Events(ctx, gorwp.HWCsWithInput(gorwp.InputPulsed))
+
+	receive := func(events <-chan gorwp.Event) gorwp.Event {
+		t.Helper()
+		select {
+		case event, ok := <-events:
+			if !ok {
+				t.Fatal("channel closed")
+			}
+			return event
+		case <-time.After(5 * time.Second):
+			t.Fatal("no event received")
+		}
+		return gorwp.Event{}
+	}
+
+	emu.Press(1, rwp.BinaryEvent_TOP)
+	emu.Pulse(2, -2)
+	emu.Absolute(3, 600)
+	emu.RawAnalog(3, 1234)
+
+	want := []gorwp.Event{
+		{Kind: gorwp.EventBinary, HWCID: 1, Status: gorwp.Down, Edge: gorwp.Top},
+		{Kind: gorwp.EventPulsed, HWCID: 2, Value: -2},
+		{Kind: gorwp.EventAbsolute, HWCID: 3, Value: 600},
+		{Kind: gorwp.EventRawAnalog, HWCID: 3, Value: 1234},
+	}
+	for _, w := range want {
+		got := receive(all)
+		if got.Kind != w.Kind || got.HWCID != w.HWCID || got.Status != w.Status || got.Edge != w.Edge || got.Value != w.Value || got.Raw == nil {
+			t.Fatalf("got %s event %+v, want %+v", got.Kind, got, w)
+		}
+	}
+	if event := receive(buttons); event.HWCID != 1 || event.Kind != gorwp.EventBinary {
+		t.Fatalf("unexpected button event %+v", event)
+	}
+	if event := receive(encoders); event.HWCID != 2 || event.Kind != gorwp.EventPulsed {
+		t.Fatalf("unexpected encoder event %+v", event)
+	}
+	select {
+	case event := <-buttons:
+		t.Fatalf("unexpected event %+v for HWC 1", event)
+	case event := <-encoders:
+		t.Fatalf("unexpected event %+v for encoders", event)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// Cancelling closes the channel, the others keep receiving:
+	allCancel()
+	testpanel.WaitFor(t, "channel closed", func() bool {
+		_, ok := <-all
+		return !ok
+	})
+	emu.Release(1, rwp.BinaryEvent_TOP)
+	if event := receive(buttons); event.Status != gorwp.Up {
+		t.Fatalf("unexpected button event %+v", event)
+	}
+}
+
+func TestGorwpEventIterator(t *testing.T) {
+	emu, rp := testpanel.StartAndConnect(t, nil, nil)
+
+	it := rp.Iterate(gorwp.HWCs(2))
+	emu.Press(1, rwp.BinaryEvent_UNKNOWN)
+	emu.Pulse(2, 1)
+	emu.Pulse(2, -1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, want := range []int{1, -1} {
+		event, ok := it.Next(ctx)
+		if !ok || event.HWCID != 2 || event.Value != want {
+			t.Fatalf("got %+v (%v), want pulse %d on HWC 2", event, ok, want)
+		}
+	}
+
+	// A done context ends one call, not the iterator:
+	short, cancelShort := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancelShort()
+	if event, ok := it.Next(short); ok {
+		t.Fatalf("unexpected event %+v", event)
+	}
+	emu.Pulse(2, 3)
+	if event, ok := it.Next(ctx); !ok || event.Value != 3 {
+		t.Fatalf("got %+v (%v) after a timeout", event, ok)
+	}
+
+	it.Close()
+	it.Close()
+	if _, ok := it.Next(ctx); ok {
+		t.Fatal("event after closing")
+	}
+}
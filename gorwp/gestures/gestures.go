@@ -0,0 +1,400 @@
+/*
+   Copyright 2026 SKAARHOJ ApS
+
+   Released under MIT License
+*/
+
+// Package gestures recognizes taps, double taps, long presses, auto-repeat
+// and chords (several buttons pressed at once) from the binary events of a
+// raw panel.
+//
+// Durations between events are measured with the panel Timestamp, so
+// network delays don't turn a tap into a long press. Gestures reported
+// while a button is held (long press, repeat, chord) use the local clock,
+// as no events arrive then. ASCII panels don't send timestamps, the time
+// events were received is used for them.
+package gestures
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	gorwp "github.com/SKAARHOJ/rawpanel-lib/gorwp"
+	rwp "github.com/SKAARHOJ/rawpanel-lib/ibeam_rawpanel"
+)
+
+// Type Kind is the kind of a gesture
+type Kind int
+
+const (
+	Tap       Kind = iota + 1 // Pressed and released before a long press. Reported once the double tap time has passed without another press
+	DoubleTap                 // Pressed again shortly after a tap, reported on the second press
+	LongPress                 // Held for the long press time, reported while still held
+	Repeat                    // Still held after a long press, reported every repeat interval
+	Chord                     // Several HWCs pressed within the chord time, reported when it has passed
+)
+
+func (k Kind) String() string {
+	switch k {
+	case Tap:
+		return "Tap"
+	case DoubleTap:
+		return "DoubleTap"
+	case LongPress:
+		return "LongPress"
+	case Repeat:
+		return "Repeat"
+	case Chord:
+		return "Chord"
+	}
+	return "Unknown"
+}
+
+// Type Gesture is a recognized gesture
+type Gesture struct {
+	Kind  Kind
+	HWCID uint32           // For chords the HWC pressed first
+	Edge  gorwp.BinaryEdge // Edge of the press (of the first HWC for chords)
+	HWCs  []uint32         // Chord: All HWCs of the chord, in the order pressed
+	Count int              // Repeat: Number of the repeat, starting at 1
+	Held  time.Duration    // Tap, LongPress and Repeat: Time the HWC was held so far
+}
+
+// Defaults for zero thresholds
+const (
+	DefaultLongPress = 500 * time.Millisecond
+	DefaultDoubleTap = 250 * time.Millisecond
+	DefaultChord     = 50 * time.Millisecond
+)
+
+// Type Thresholds holds the timing of gestures. Zero values take the
+// value of Config.Default (and the package default there), negative
+// values disable a gesture.
+type Thresholds struct {
+	LongPress      time.Duration // Held this long is a long press instead of a tap. Disabling it disables repeats as well
+	DoubleTap      time.Duration // Max time from a release to the next press for a double tap. Disabled, taps are reported right on release
+	RepeatInterval time.Duration // Time between repeats after a long press. There is no default, repeats are off unless set
+	Chord          time.Duration // Max time between the presses of a chord
+}
+
+// Type Config holds the thresholds for all HWCs
+type Config struct {
+	Default Thresholds            // Thresholds of HWCs not in PerHWC
+	PerHWC  map[uint32]Thresholds // Thresholds of single HWCs, zero fields take the value of Default
+}
+
+// Returns the thresholds of a HWC, zero values replaced by defaults
+func (c *Config) thresholds(hwc uint32) Thresholds {
+	t := c.PerHWC[hwc]
+	pick := func(value, def, packageDefault time.Duration) time.Duration {
+		if value != 0 {
+			return value
+		}
+		if def != 0 {
+			return def
+		}
+		return packageDefault
+	}
+	t.LongPress = pick(t.LongPress, c.Default.LongPress, DefaultLongPress)
+	t.DoubleTap = pick(t.DoubleTap, c.Default.DoubleTap, DefaultDoubleTap)
+	t.RepeatInterval = pick(t.RepeatInterval, c.Default.RepeatInterval, 0)
+	t.Chord = pick(t.Chord, c.Default.Chord, DefaultChord)
+	return t
+}
+
+// Type Recognizer turns binary events into gestures
+type Recognizer struct {
+	mu      sync.Mutex // Held while calling f, so gestures are reported one at a time
+	config  Config
+	f       func(Gesture)
+	buttons map[uint32]*button
+	chords  []*chord // Chords waiting for the chord time to pass
+	stopped bool
+}
+
+// Type button is the state of a binary HWC
+type button struct {
+	pressed     bool
+	edge        gorwp.BinaryEdge
+	pressedAt   instant // When the current or last press happened
+	releasedAt  instant // When the last release happened
+	generation  int     // Counts presses and releases, so timers of an earlier one do nothing
+	consumed    bool    // The press is part of a double tap or chord, nothing else is reported until release
+	longPressed bool
+	repeats     int
+	holdTimer   *time.Timer // Long press and repeat
+	tapTimer    *time.Timer // A tap waiting for the double tap time, nil if none is waiting
+	tapHeld     time.Duration
+}
+
+type chord struct {
+	hwcs  []uint32
+	timer *time.Timer
+}
+
+// Type instant is the time of an event on the panel and locally
+type instant struct {
+	timestamp uint32 // Panel time in milliseconds, 0 if unknown
+	local     time.Time
+}
+
+// Returns the time from a to b, by the panel clock if both have a
+// timestamp. Negative if b was before a, also across a wrap of the clock.
+func (a instant) until(b instant) time.Duration {
+	if a.timestamp != 0 && b.timestamp != 0 {
+		return time.Duration(int32(b.timestamp-a.timestamp)) * time.Millisecond
+	}
+	return b.local.Sub(a.local)
+}
+
+// Function New creates a recognizer calling f for each gesture. Config may
+// be nil for the default thresholds. f is called one gesture at a time,
+// from the goroutine feeding events or from a timer, and must not call
+// the recognizer.
+func New(config *Config, f func(Gesture)) *Recognizer {
+	r := &Recognizer{f: f, buttons: make(map[uint32]*button)}
+	if config != nil {
+		r.config = *config
+	}
+	return r
+}
+
+// Function Bind feeds the binary events of the HWCs picked by the
+// selector (all if nil) to the recognizer. Unbind the returned binding
+// to stop.
+func (r *Recognizer) Bind(rp *gorwp.RawPanel, selector gorwp.HWCSelector) *gorwp.Binding {
+	if selector == nil {
+		selector = gorwp.AllHWCs()
+	}
+	return rp.BindTriggerTo(selector, func(hwc uint32, rawEvent *rwp.HWCEvent) {
+		if event, ok := gorwp.EventFromRaw(rawEvent); ok {
+			r.Feed(event)
+		}
+	})
+}
+
+// Function Feed hands an event to the recognizer, e.g. from
+// RawPanel.Events. Events other than binary ones are ignored.
+func (r *Recognizer) Feed(event gorwp.Event) {
+	if event.Kind != gorwp.EventBinary {
+		return
+	}
+	at := instant{timestamp: event.Timestamp, local: time.Now()}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.stopped {
+		return
+	}
+	b := r.buttons[event.HWCID]
+	if b == nil {
+		b = &button{}
+		r.buttons[event.HWCID] = b
+	}
+	if event.Status == gorwp.Down {
+		r.press(event.HWCID, b, event.Edge, at)
+	} else {
+		r.release(event.HWCID, b, at)
+	}
+}
+
+// Function Stop stops all timers. Gestures not reported yet are dropped,
+// and further events are ignored.
+func (r *Recognizer) Stop() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.stopped = true
+	for _, b := range r.buttons {
+		b.stopTimers()
+	}
+	for _, c := range r.chords {
+		c.timer.Stop()
+	}
+	r.chords = nil
+}
+
+func (r *Recognizer) press(hwc uint32, b *button, edge gorwp.BinaryEdge, at instant) {
+	if b.pressed {
+		return
+	}
+	t := r.config.thresholds(hwc)
+
+	doubleTap := false
+	if b.tapTimer != nil {
+		if t.DoubleTap > 0 && b.releasedAt.until(at) <= t.DoubleTap {
+			doubleTap = true
+		} else { // Too late for a double tap, the timer just didn't fire yet
+			r.emit(Gesture{Kind: Tap, HWCID: hwc, Edge: b.edge, Held: b.tapHeld})
+		}
+	}
+	b.stopTimers()
+
+	b.pressed = true
+	b.edge = edge
+	b.pressedAt = at
+	b.generation++
+	b.consumed = doubleTap
+	b.longPressed = false
+	b.repeats = 0
+
+	if doubleTap {
+		r.emit(Gesture{Kind: DoubleTap, HWCID: hwc, Edge: edge})
+		return
+	}
+	if r.joinChord(hwc, b, t) {
+		return
+	}
+	if t.LongPress > 0 {
+		r.startHoldTimer(hwc, b, t, t.LongPress)
+	}
+}
+
+func (r *Recognizer) release(hwc uint32, b *button, at instant) {
+	if !b.pressed {
+		return
+	}
+	t := r.config.thresholds(hwc)
+	b.stopTimers()
+	b.pressed = false
+	b.releasedAt = at
+	b.generation++
+	if b.consumed || b.longPressed {
+		return
+	}
+
+	held := b.pressedAt.until(at)
+	if t.LongPress > 0 && held >= t.LongPress { // The timer didn't fire yet, but the panel says it was held long enough
+		b.longPressed = true
+		r.emit(Gesture{Kind: LongPress, HWCID: hwc, Edge: b.edge, Held: held})
+		return
+	}
+	if t.DoubleTap <= 0 {
+		r.emit(Gesture{Kind: Tap, HWCID: hwc, Edge: b.edge, Held: held})
+		return
+	}
+
+	b.tapHeld = held
+	generation := b.generation
+	b.tapTimer = time.AfterFunc(t.DoubleTap, func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if r.stopped || b.generation != generation || b.tapTimer == nil {
+			return
+		}
+		b.tapTimer = nil
+		r.emit(Gesture{Kind: Tap, HWCID: hwc, Edge: b.edge, Held: b.tapHeld})
+	})
+}
+
+// Reports a long press after delay, and repeats after that
+func (r *Recognizer) startHoldTimer(hwc uint32, b *button, t Thresholds, delay time.Duration) {
+	generation := b.generation
+	b.holdTimer = time.AfterFunc(delay, func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if r.stopped || b.generation != generation || b.consumed {
+			return
+		}
+		held := time.Since(b.pressedAt.local)
+		if !b.longPressed {
+			b.longPressed = true
+			r.emit(Gesture{Kind: LongPress, HWCID: hwc, Edge: b.edge, Held: held})
+		} else {
+			b.repeats++
+			r.emit(Gesture{Kind: Repeat, HWCID: hwc, Edge: b.edge, Count: b.repeats, Held: held})
+		}
+		if t.RepeatInterval > 0 {
+			r.startHoldTimer(hwc, b, t, t.RepeatInterval)
+		}
+	})
+}
+
+// Makes a press part of a chord if other HWCs were pressed within the
+// chord time and are still held. Returns false if there is no chord.
+func (r *Recognizer) joinChord(hwc uint32, b *button, t Thresholds) bool {
+	if t.Chord <= 0 {
+		return false
+	}
+	if r.chordOf(hwc) != nil { // Pressed again before its chord was reported
+		b.consumed = true
+		return true
+	}
+	var partners []uint32
+	for other, ob := range r.buttons {
+		if other == hwc || !ob.pressed || (ob.consumed && r.chordOf(other) == nil) {
+			continue
+		}
+		if ot := r.config.thresholds(other); ot.Chord > 0 && ob.pressedAt.until(b.pressedAt) <= t.Chord {
+			partners = append(partners, other)
+		}
+	}
+	if len(partners) == 0 {
+		return false
+	}
+
+	b.consumed = true
+	for _, other := range partners {
+		if c := r.chordOf(other); c != nil {
+			c.hwcs = append(c.hwcs, hwc)
+			return true
+		}
+	}
+
+	// A new chord, in the order pressed, reported when the chord time since the first press has passed:
+	sort.Slice(partners, func(i, j int) bool {
+		return r.buttons[partners[i]].pressedAt.until(r.buttons[partners[j]].pressedAt) > 0
+	})
+	c := &chord{hwcs: append(partners, hwc)}
+	for _, other := range partners {
+		ob := r.buttons[other]
+		ob.consumed = true
+		ob.stopTimers()
+	}
+	first := r.buttons[c.hwcs[0]]
+	c.timer = time.AfterFunc(t.Chord-time.Since(first.pressedAt.local), func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if r.stopped {
+			return
+		}
+		for i := range r.chords {
+			if r.chords[i] == c {
+				r.chords = append(r.chords[:i], r.chords[i+1:]...)
+				break
+			}
+		}
+		r.emit(Gesture{Kind: Chord, HWCID: c.hwcs[0], Edge: first.edge, HWCs: c.hwcs})
+	})
+	r.chords = append(r.chords, c)
+	return true
+}
+
+// Returns the chord waiting to be reported a HWC is part of, if any
+func (r *Recognizer) chordOf(hwc uint32) *chord {
+	for _, c := range r.chords {
+		for _, h := range c.hwcs {
+			if h == hwc {
+				return c
+			}
+		}
+	}
+	return nil
+}
+
+func (r *Recognizer) emit(g Gesture) {
+	if r.f != nil {
+		r.f(g)
+	}
+}
+
+func (b *button) stopTimers() {
+	if b.holdTimer != nil {
+		b.holdTimer.Stop()
+		b.holdTimer = nil
+	}
+	if b.tapTimer != nil {
+		b.tapTimer.Stop()
+		b.tapTimer = nil
+	}
+}
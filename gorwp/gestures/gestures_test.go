@@ -0,0 +1,145 @@
+package gestures
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	gorwp "github.com/SKAARHOJ/rawpanel-lib/gorwp"
+	rwp "github.com/SKAARHOJ/rawpanel-lib/ibeam_rawpanel"
+	"github.com/SKAARHOJ/rawpanel-lib/internal/testpanel"
+)
+
+func down(hwc uint32, timestamp uint32) gorwp.Event {
+	return gorwp.Event{Kind: gorwp.EventBinary, HWCID: hwc, Timestamp: timestamp, Status: gorwp.Down}
+}
+
+func up(hwc uint32, timestamp uint32) gorwp.Event {
+	return gorwp.Event{Kind: gorwp.EventBinary, HWCID: hwc, Timestamp: timestamp, Status: gorwp.Up}
+}
+
+func expect(t *testing.T, gestures chan Gesture, want ...Gesture) {
+	t.Helper()
+	for _, w := range want {
+		select {
+		case g := <-gestures:
+			if g.Kind != w.Kind || g.HWCID != w.HWCID || g.Count != w.Count || !reflect.DeepEqual(g.HWCs, w.HWCs) || (w.Held != 0 && g.Held != w.Held) {
+				t.Fatalf("got %s %+v, want %s %+v", g.Kind, g, w.Kind, w)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("no %s gesture", w.Kind)
+		}
+	}
+}
+
+func expectNone(t *testing.T, gestures chan Gesture) {
+	t.Helper()
+	select {
+	case g := <-gestures:
+		t.Fatalf("unexpected %s %+v", g.Kind, g)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestGestures(t *testing.T) {
+	gestures := make(chan Gesture, 100)
+	r := New(&Config{
+		Default: Thresholds{LongPress: 300 * time.Millisecond, DoubleTap: 50 * time.Millisecond, Chord: 30 * time.Millisecond},
+		PerHWC: map[uint32]Thresholds{
+			2: {DoubleTap: -1},
+			3: {RepeatInterval: 100 * time.Millisecond, Chord: -1},
+		},
+	}, func(g Gesture) { gestures <- g })
+	defer r.Stop()
+
+	t.Run("tap", func(t *testing.T) {
+		r.Feed(down(1, 1000))
+		r.Feed(up(1, 1120))
+		expect(t, gestures, Gesture{Kind: Tap, HWCID: 1, Held: 120 * time.Millisecond})
+		expectNone(t, gestures)
+	})
+
+	t.Run("double tap", func(t *testing.T) {
+		r.Feed(down(1, 2000))
+		r.Feed(up(1, 2050))
+		r.Feed(down(1, 2080))
+		r.Feed(up(1, 2130))
+		expect(t, gestures, Gesture{Kind: DoubleTap, HWCID: 1})
+		expectNone(t, gestures)
+	})
+
+	t.Run("too slow for a double tap", func(t *testing.T) {
+		r.Feed(down(1, 3000))
+		r.Feed(up(1, 3050))
+		r.Feed(down(1, 3200)) // Arrives before the local timer fired
+		r.Feed(up(1, 3250))
+		expect(t, gestures, Gesture{Kind: Tap, HWCID: 1}, Gesture{Kind: Tap, HWCID: 1})
+		expectNone(t, gestures)
+	})
+
+	t.Run("tap without double tap", func(t *testing.T) {
+		r.Feed(down(2, 4000))
+		r.Feed(up(2, 4050))
+		r.Feed(down(2, 4080))
+		r.Feed(up(2, 4130))
+		expect(t, gestures, Gesture{Kind: Tap, HWCID: 2}, Gesture{Kind: Tap, HWCID: 2})
+		expectNone(t, gestures)
+	})
+
+	t.Run("long press", func(t *testing.T) {
+		r.Feed(down(1, 5000))
+		expect(t, gestures, Gesture{Kind: LongPress, HWCID: 1})
+		r.Feed(up(1, 5400))
+		expectNone(t, gestures)
+	})
+
+	t.Run("long press by the panel clock", func(t *testing.T) {
+		r.Feed(down(2, 6000))
+		r.Feed(up(2, 6350)) // Both arrive at once, e.g. after a network hiccup
+		expect(t, gestures, Gesture{Kind: LongPress, HWCID: 2, Held: 350 * time.Millisecond})
+		expectNone(t, gestures)
+	})
+
+	t.Run("repeat", func(t *testing.T) {
+		r.Feed(down(3, 7000))
+		expect(t, gestures, Gesture{Kind: LongPress, HWCID: 3}, Gesture{Kind: Repeat, HWCID: 3, Count: 1}, Gesture{Kind: Repeat, HWCID: 3, Count: 2})
+		r.Feed(up(3, 7600))
+		expectNone(t, gestures)
+	})
+
+	t.Run("chord", func(t *testing.T) {
+		r.Feed(down(2, 8000))
+		r.Feed(down(1, 8010))
+		r.Feed(down(4, 8020))
+		expect(t, gestures, Gesture{Kind: Chord, HWCID: 2, HWCs: []uint32{2, 1, 4}})
+		r.Feed(up(1, 8400))
+		r.Feed(up(2, 8400))
+		r.Feed(up(4, 8400))
+		expectNone(t, gestures)
+	})
+
+	t.Run("no chord", func(t *testing.T) {
+		r.Feed(down(1, 9000))
+		r.Feed(down(2, 9100)) // Too late for a chord
+		r.Feed(down(3, 9110)) // Chords disabled
+		r.Feed(up(2, 9150))
+		r.Feed(up(1, 9150))
+		expect(t, gestures, Gesture{Kind: Tap, HWCID: 2}, Gesture{Kind: Tap, HWCID: 1})
+		r.Feed(up(3, 9150))
+		expect(t, gestures, Gesture{Kind: Tap, HWCID: 3})
+		expectNone(t, gestures)
+	})
+}
+
+func TestGesturesFromPanel(t *testing.T) {
+	emu, rp := testpanel.StartAndConnect(t, nil, nil)
+
+	gestures := make(chan Gesture, 100)
+	r := New(&Config{Default: Thresholds{DoubleTap: -1}}, func(g Gesture) { gestures <- g })
+	defer r.Stop()
+	r.Bind(rp, nil)
+
+	emu.Press(1, rwp.BinaryEvent_UNKNOWN)
+	emu.Release(1, rwp.BinaryEvent_UNKNOWN)
+	expect(t, gestures, Gesture{Kind: Tap, HWCID: 1})
+}
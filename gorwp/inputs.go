@@ -42,9 +42,24 @@ type BinaryFunc func(uint32, BinaryStatus, BinaryEdge)
 
 // Function BindBinary sets a callback for actions on a specific
 // binary trigger.  When the binary trigger is pushed down, then the provided
-// BinaryFunc is called.
-func (rp *RawPanel) BindBinary(hwc uint32, f BinaryFunc) {
-	rp.binaryBindings[hwc] = f
+// BinaryFunc is called. Binding again replaces the callback, the returned
+// Binding removes it.
+func (rp *RawPanel) BindBinary(hwc uint32, f BinaryFunc) *Binding {
+	return rp.bindings.replace(hwc, slotBinary, binaryHandler(f))
+}
+
+// Function AddBinary adds a callback for actions on a specific binary
+// trigger. Unlike BindBinary, callbacks added before are kept and called
+// in the order they were added, after the bound one.
+func (rp *RawPanel) AddBinary(hwc uint32, f BinaryFunc) *Binding {
+	return rp.bindings.add(hwc, nil, binaryHandler(f))
+}
+
+// Function BindBinaryTo adds a callback for actions on all binary
+// triggers picked by the selector, e.g. HWCsWithInput(InputBinary). Like
+// AddBinary, it doesn't replace other callbacks.
+func (rp *RawPanel) BindBinaryTo(selector HWCSelector, f BinaryFunc) *Binding {
+	return rp.bindings.add(0, selector, binaryHandler(f))
 }
 
 // Type PulsedFunc is a function signature used for callbacks on encoder
@@ -54,9 +69,21 @@ type PulsedFunc func(uint32, int)
 
 // Function BindKnob sets a callback for actions on a specific
 // encoder. When the encoder is turned then the provided
-// PulsedFunc is called.
-func (rp *RawPanel) BindPulsed(hwc uint32, f PulsedFunc) {
-	rp.pulsedBindings[hwc] = f
+// PulsedFunc is called. Binding again replaces the callback.
+func (rp *RawPanel) BindPulsed(hwc uint32, f PulsedFunc) *Binding {
+	return rp.bindings.replace(hwc, slotPulsed, pulsedHandler(f))
+}
+
+// Function AddPulsed adds a callback for a specific encoder, keeping the
+// ones added before.
+func (rp *RawPanel) AddPulsed(hwc uint32, f PulsedFunc) *Binding {
+	return rp.bindings.add(hwc, nil, pulsedHandler(f))
+}
+
+// Function BindPulsedTo adds a callback for all encoders picked by the
+// selector, e.g. HWCsWithInput(InputPulsed) for all encoders of the panel.
+func (rp *RawPanel) BindPulsedTo(selector HWCSelector, f PulsedFunc) *Binding {
+	return rp.bindings.add(0, selector, pulsedHandler(f))
 }
 
 // Type AbsoluteFunc is a function signature used for callbacks on fader
@@ -65,9 +92,21 @@ type AbsoluteFunc func(uint32, int)
 
 // Function BindAbsolute sets a callback for actions on a specific
 // fader.  When the fader is moved then the provided
-// AbsoluteFunc is called.
-func (rp *RawPanel) BindAbsolute(hwc uint32, f AbsoluteFunc) {
-	rp.absoluteBindings[hwc] = f
+// AbsoluteFunc is called. Binding again replaces the callback.
+func (rp *RawPanel) BindAbsolute(hwc uint32, f AbsoluteFunc) *Binding {
+	return rp.bindings.replace(hwc, slotAbsolute, absoluteHandler(f))
+}
+
+// Function AddAbsolute adds a callback for a specific fader, keeping the
+// ones added before.
+func (rp *RawPanel) AddAbsolute(hwc uint32, f AbsoluteFunc) *Binding {
+	return rp.bindings.add(hwc, nil, absoluteHandler(f))
+}
+
+// Function BindAbsoluteTo adds a callback for all faders picked by the
+// selector.
+func (rp *RawPanel) BindAbsoluteTo(selector HWCSelector, f AbsoluteFunc) *Binding {
+	return rp.bindings.add(0, selector, absoluteHandler(f))
 }
 
 // Type IntensityFunc is a function signature used for callbacks on joystick
@@ -77,9 +116,21 @@ type IntensityFunc func(uint32, int)
 
 // Function BindIntensity sets a callback for actions on a specific
 // joystick. When the joystick axis is manipulated then the provided
-// IntensityFunc is called.
-func (rp *RawPanel) BindIntensity(hwc uint32, f IntensityFunc) {
-	rp.intensityBindings[hwc] = f
+// IntensityFunc is called. Binding again replaces the callback.
+func (rp *RawPanel) BindIntensity(hwc uint32, f IntensityFunc) *Binding {
+	return rp.bindings.replace(hwc, slotIntensity, intensityHandler(f))
+}
+
+// Function AddIntensity adds a callback for a specific joystick, keeping
+// the ones added before.
+func (rp *RawPanel) AddIntensity(hwc uint32, f IntensityFunc) *Binding {
+	return rp.bindings.add(hwc, nil, intensityHandler(f))
+}
+
+// Function BindIntensityTo adds a callback for all joysticks picked by
+// the selector.
+func (rp *RawPanel) BindIntensityTo(selector HWCSelector, f IntensityFunc) *Binding {
+	return rp.bindings.add(0, selector, intensityHandler(f))
 }
 
 // Type TriggerFunc is a function signature used for callbacks on generic
@@ -88,7 +139,20 @@ func (rp *RawPanel) BindIntensity(hwc uint32, f IntensityFunc) {
 type TriggerFunc func(uint32, *rwp.HWCEvent)
 
 // Function BindTrigger sets a general callback for actions on a specific
-// hardware component.
-func (rp *RawPanel) BindTrigger(hwc uint32, f TriggerFunc) {
-	rp.triggerBindings[hwc] = f
+// hardware component. Binding again replaces the callback. It is called
+// before the callbacks of BindBinary, BindPulsed etc.
+func (rp *RawPanel) BindTrigger(hwc uint32, f TriggerFunc) *Binding {
+	return rp.bindings.replace(hwc, slotTrigger, f)
+}
+
+// Function AddTrigger adds a general callback for a specific hardware
+// component, keeping the ones added before.
+func (rp *RawPanel) AddTrigger(hwc uint32, f TriggerFunc) *Binding {
+	return rp.bindings.add(hwc, nil, f)
+}
+
+// Function BindTriggerTo adds a general callback for actions on all
+// hardware components picked by the selector, e.g. AllHWCs().
+func (rp *RawPanel) BindTriggerTo(selector HWCSelector, f TriggerFunc) *Binding {
+	return rp.bindings.add(0, selector, f)
 }
@@ -0,0 +1,225 @@
+/*
+   Copyright 2026 SKAARHOJ ApS
+
+   Released under MIT License
+*/
+
+package gorwp
+
+import (
+	"context"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	helpers "github.com/SKAARHOJ/rawpanel-lib"
+	rwp "github.com/SKAARHOJ/rawpanel-lib/ibeam_rawpanel"
+	log "github.com/s00500/env_logger"
+)
+
+// Returns true while the panel is connected and initialized
+func (rp *RawPanel) IsConnected() bool {
+	return rp.connected.Load()
+}
+
+func (rp *RawPanel) setConnected(connected bool) {
+	if rp.connected.Swap(connected) != connected && rp.onConnectionChange != nil {
+		rp.onConnectionChange(connected)
+	}
+}
+
+// Sends feedback to the panel. While reconnecting, nothing reads rp.toPanel,
+// so feedback is only recorded then and sent when the panel is restored.
+// Once the panel is closed, feedback is dropped.
+func (rp *RawPanel) send(msgs []*rwp.InboundMessage) {
+	rp.sendMu.Lock()
+	defer rp.sendMu.Unlock()
+	if rp.offline {
+		rp.feedback.record(msgs)
+		return
+	}
+	select {
+	case rp.toPanel <- msgs:
+	case <-rp.done:
+	}
+}
+
+func (rp *RawPanel) setOffline(offline bool) {
+	rp.sendMu.Lock()
+	defer rp.sendMu.Unlock()
+	rp.offline = offline
+}
+
+// Talks to the panel and reconnects whenever the connection is lost, until the context is done or the retry policy gives up
+func (rp *RawPanel) keepConnected(ctx context.Context) {
+	sessionCtx, cancelSession := ctx, context.CancelFunc(func() {}) // The first connection is initialized by ConnectWithConfig
+	for {
+		rp.runSession(sessionCtx)
+		cancelSession()
+		rp.setOffline(true)
+		rp.setConnected(false)
+		if ctx.Err() != nil {
+			return
+		}
+		log.Infoln("Panel: " + rp.panelIPAndPort + " disconnected, reconnecting")
+
+		heartBeatNegotiation, ok := rp.reconnect(ctx)
+		if !ok {
+			(*rp.cancel)()
+			return
+		}
+
+		// Panel info and topology are asked for again, they may have changed with a firmware update:
+		rp.State.resetInitialized()
+		sessionCtx, cancelSession = context.WithCancel(ctx)
+		go rp.restore(sessionCtx, rp.connection, heartBeatNegotiation)
+	}
+}
+
+// Dials until the panel is back. Feedback sent meanwhile is only recorded. Returns false if we should stop trying.
+func (rp *RawPanel) reconnect(ctx context.Context) ([]*rwp.InboundMessage, bool) {
+	for attempt := 1; ; attempt++ {
+		delay, ok := rp.retryPolicy.NextDelay(attempt)
+		if !ok {
+			log.Errorf("Giving up reconnecting to %s after %d attempts\n", rp.panelIPAndPort, attempt-1)
+			return nil, false
+		}
+		log.Debugf("Reconnecting to %s in %s (attempt %d)\n", rp.panelIPAndPort, delay, attempt)
+		if !rp.waitDisconnected(ctx, delay) {
+			return nil, false
+		}
+
+		heartBeatNegotiation, err := rp.dial(ctx)
+		if err == nil {
+			return heartBeatNegotiation, true
+		}
+		if ctx.Err() != nil {
+			return nil, false
+		}
+	}
+}
+
+// Waits while disconnected, recording feedback and processing messages fed in e.g. by ReplayFromPanel. Returns false if the context is done.
+func (rp *RawPanel) waitDisconnected(ctx context.Context, delay time.Duration) bool {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case messagesToPanel := <-rp.toPanel: // Ignored until reconnected, but remembered for restoring the panel
+			rp.feedback.record(messagesToPanel)
+		case messagesFromPanel := <-rp.fromPanel:
+			rp.procesMessagesFromPanel(messagesFromPanel)
+		case <-timer.C:
+			return true
+		}
+	}
+}
+
+// Initializes a reconnected panel and sends the feedback it had before
+func (rp *RawPanel) restore(ctx context.Context, conn net.Conn, heartBeatNegotiation []*rwp.InboundMessage) {
+	if err := rp.init(ctx, heartBeatNegotiation); err != nil {
+		if ctx.Err() == nil {
+			log.Errorln("Panel: " + rp.panelIPAndPort + " reconnected, but " + err.Error())
+			conn.Close() // Try again
+		}
+		return
+	}
+	if ctx.Err() != nil {
+		return
+	}
+
+	// Feedback sent from here on must go after the restored feedback:
+	rp.sendMu.Lock()
+	if msgs := rp.feedback.messages(); len(msgs) > 0 {
+		select {
+		case rp.toPanel <- msgs:
+		case <-ctx.Done():
+			rp.sendMu.Unlock()
+			return
+		}
+	}
+	rp.offline = false
+	rp.sendMu.Unlock()
+	log.Infoln("Panel: " + rp.panelIPAndPort + " reconnected")
+	rp.setConnected(true)
+}
+
+// Type feedbackCache remembers the feedback sent to the panel, merged per HWC like the panel does.
+// The methods can be called on a nil *feedbackCache, which does nothing.
+type feedbackCache struct {
+	sync.Mutex
+	states     map[uint32]*rwp.HWCState
+	brightness *rwp.Brightness
+}
+
+func newFeedbackCache() *feedbackCache {
+	return &feedbackCache{states: make(map[uint32]*rwp.HWCState)}
+}
+
+func (fc *feedbackCache) record(msgs []*rwp.InboundMessage) {
+	if fc == nil {
+		return
+	}
+	fc.Lock()
+	defer fc.Unlock()
+	for _, msg := range msgs {
+		if cmd := msg.Command; cmd != nil {
+			if cmd.PanelBrightness != nil {
+				fc.brightness = proto.Clone(cmd.PanelBrightness).(*rwp.Brightness)
+			}
+			if cmd.ClearAll || cmd.ClearLEDs || cmd.ClearDisplays {
+				for _, state := range fc.states {
+					if cmd.ClearAll || cmd.ClearLEDs {
+						state.HWCMode = nil
+						state.HWCColor = nil
+					}
+					if cmd.ClearAll || cmd.ClearDisplays {
+						state.HWCText = nil
+						state.HWCGfx = nil
+						state.Processors = nil
+					}
+				}
+			}
+		}
+		for _, state := range msg.States {
+			if state.HWCGfx != nil && state.HWCGfx.XYoffset {
+				// Partial graphics only make sense on top of what is on the display, so only the last full image is restored:
+				state = proto.Clone(state).(*rwp.HWCState)
+				state.HWCGfx = nil
+			}
+			for _, hwc := range state.HWCIDs {
+				if _, exists := fc.states[hwc]; !exists {
+					fc.states[hwc] = &rwp.HWCState{HWCIDs: []uint32{hwc}}
+				}
+				helpers.MergeHWCState(fc.states[hwc], state)
+			}
+		}
+	}
+}
+
+// Returns messages restoring the recorded feedback, one per HWC to keep binary frames small
+func (fc *feedbackCache) messages() []*rwp.InboundMessage {
+	if fc == nil {
+		return nil
+	}
+	fc.Lock()
+	defer fc.Unlock()
+	msgs := []*rwp.InboundMessage{}
+	if fc.brightness != nil {
+		msgs = append(msgs, &rwp.InboundMessage{Command: &rwp.Command{PanelBrightness: proto.Clone(fc.brightness).(*rwp.Brightness)}})
+	}
+	hwcs := make([]uint32, 0, len(fc.states))
+	for hwc := range fc.states {
+		hwcs = append(hwcs, hwc)
+	}
+	sort.Slice(hwcs, func(i, j int) bool { return hwcs[i] < hwcs[j] })
+	for _, hwc := range hwcs {
+		msgs = append(msgs, &rwp.InboundMessage{States: []*rwp.HWCState{proto.Clone(fc.states[hwc]).(*rwp.HWCState)}})
+	}
+	return msgs
+}
@@ -0,0 +1,83 @@
+package gorwp_test
+
+import (
+	"testing"
+	"time"
+
+	helpers "github.com/SKAARHOJ/rawpanel-lib"
+	"github.com/SKAARHOJ/rawpanel-lib/emulator"
+	gorwp "github.com/SKAARHOJ/rawpanel-lib/gorwp"
+	rwp "github.com/SKAARHOJ/rawpanel-lib/ibeam_rawpanel"
+	"github.com/SKAARHOJ/rawpanel-lib/internal/testpanel"
+	"go.uber.org/atomic"
+)
+
+// A panel which is power cycled gets its feedback back, and bindings keep working
+func TestGorwpPersistent(t *testing.T) {
+	emu, addr := testpanel.Start(t, nil)
+	var connectionChanges atomic.Int32
+	rp := testpanel.Connect(t, addr, &gorwp.ConnectConfig{
+		Persistent:         true,
+		RetryPolicy:        &helpers.FixedRetryPolicy{Delay: 50 * time.Millisecond},
+		OnConnectionChange: func(connected bool) { connectionChanges.Inc() },
+	})
+	var presses atomic.Int32
+	rp.BindBinary(1, func(hwc uint32, status gorwp.BinaryStatus, edge gorwp.BinaryEdge) {
+		if status == gorwp.Down {
+			presses.Inc()
+		}
+	})
+
+	rp.SetLEDColorByIndex(1, rwp.ColorIndex_RED, rwp.HWCMode_ON)
+	rp.SetBrightness(5)
+	testpanel.WaitFor(t, "feedback on the panel", func() bool {
+		return emu.State(1).GetHWCColor() != nil && emu.Brightness() != nil
+	})
+
+	// Power cycle:
+	emu.Close()
+	testpanel.WaitFor(t, "disconnect", func() bool { return !rp.IsConnected() })
+	rp.SetRWPText(1, "Title", "Sent while off", "", false)
+	rp.SendRawState(&rwp.HWCState{HWCIDs: []uint32{2}, HWCGfx: &rwp.HWCGfx{W: 64, H: 32, ImageData: make([]byte, 256)}})
+	rp.SendRawState(&rwp.HWCState{HWCIDs: []uint32{2}, HWCGfx: &rwp.HWCGfx{W: 8, H: 8, XYoffset: true, X: 8, ImageData: make([]byte, 8)}})
+
+	// Feedback doesn't block while nothing talks to the panel:
+	sent := make(chan bool)
+	go func() {
+		for i := 0; i < 100; i++ {
+			rp.SetLEDColorByIndex(3, rwp.ColorIndex_Colors(i%16), rwp.HWCMode_ON)
+		}
+		close(sent)
+	}()
+	select {
+	case <-sent:
+	case <-time.After(time.Second):
+		t.Fatal("feedback blocks while reconnecting")
+	}
+
+	emu = emulator.New(testpanel.Topology(), nil)
+	defer emu.Close()
+	if _, err := emu.Listen("tcp", addr); err != nil {
+		t.Fatal(err)
+	}
+	testpanel.WaitFor(t, "reconnect", func() bool { return rp.IsConnected() })
+	testpanel.WaitFor(t, "restored feedback", func() bool {
+		state := emu.State(1)
+		return state.GetHWCColor().GetColorIndex().GetIndex() == rwp.ColorIndex_RED &&
+			state.GetHWCText().GetTextline1() == "Sent while off" &&
+			emu.Brightness().GetLEDs() == 5 &&
+			emu.State(3).GetHWCColor() != nil
+	})
+	if gfx := emu.State(2).GetHWCGfx(); gfx.GetW() != 64 || gfx.GetXYoffset() { // Partial graphics are not restored
+		t.Fatalf("expected the full image to be restored, got %v", gfx)
+	}
+	if connectionChanges.Load() != 2 {
+		t.Fatalf("expected a disconnect and a reconnect, got %d changes", connectionChanges.Load())
+	}
+
+	emu.Press(1, rwp.BinaryEvent_UNKNOWN)
+	testpanel.WaitFor(t, "press after reconnecting", func() bool { return presses.Load() == 1 })
+	if !rp.IsConnected() {
+		t.Fatal("not connected")
+	}
+}
@@ -15,18 +15,16 @@ package gorwp
 import (
 	"bufio"
 	"context"
-	"encoding/binary"
-	"encoding/json"
+	"crypto/tls"
 	"fmt"
 	"image"
 	"image/color"
 	"io"
 	"net"
 	"strings"
+	"sync"
 	"time"
 
-	"google.golang.org/protobuf/proto"
-
 	su "github.com/SKAARHOJ/ibeam-lib-utils"
 	helpers "github.com/SKAARHOJ/rawpanel-lib"
 	monogfx "github.com/SKAARHOJ/rawpanel-lib/ibeam_lib_monogfx"
@@ -34,83 +32,288 @@ import (
 	topology "github.com/SKAARHOJ/rawpanel-lib/topology"
 	rawpanelproc "github.com/SKAARHOJ/rawpanel-processors"
 	log "github.com/s00500/env_logger"
+	"go.uber.org/atomic"
 )
 
 // Type RawPanel describes a SKAARHOJ Raw Panel device
 type RawPanel struct {
-	connection  net.Conn
+	connection  net.Conn      // Replaced on reconnects, only used by the goroutines of the current connection
+	connFailed  chan struct{} // Closed when the connection fails, replaced with it
 	cancel      *context.CancelFunc
 	binaryPanel bool
 
+	// Connection settings, kept for reconnecting:
+	panelIPAndPort   string
+	transport        helpers.Transport
+	connectTimeout   time.Duration
+	detectionTimeout time.Duration
+	protocolMode     helpers.ProtocolMode
+
+	// Persistent mode (optional)
+	retryPolicy        helpers.RetryPolicy // Set in persistent mode
+	feedback           *feedbackCache      // What we sent to the panel, restored after reconnecting
+	connected          atomic.Bool
+	onConnectionChange func(connected bool)
+	sendMu             sync.Mutex // Orders feedback sent by the user against restoring the panel
+	offline            bool       // Guarded by sendMu. Feedback is only recorded while reconnecting
+
 	// Message channels:
 	toPanel   chan []*rwp.InboundMessage
 	fromPanel chan []*rwp.OutboundMessage
 
 	// Trigger Bindings
-	binaryBindings    map[uint32]BinaryFunc
-	pulsedBindings    map[uint32]PulsedFunc
-	absoluteBindings  map[uint32]AbsoluteFunc
-	intensityBindings map[uint32]IntensityFunc
-	triggerBindings   map[uint32]TriggerFunc
+	bindings   *bindingRegistry
+	dispatcher *dispatcher // Calls the bindings off the goroutine talking to the panel
+
+	// Liveness supervision (pings)
+	liveness *helpers.LivenessMonitor
+
+	// Binary framing
+	frameWriter  *helpers.FrameWriter
+	maxFrameSize int
+
+	// Counters (optional)
+	metrics *helpers.ConnectionMetrics
+
+	// Traffic recording (optional)
+	recorder *helpers.Recorder
+
+	// Outbound queue (optional)
+	scheduler *helpers.SendScheduler
+
+	// Requests waiting for answers from the panel
+	requests       pendingRequests
+	requestTimeout time.Duration
+	done           <-chan struct{} // Closed with the connection
 
 	// State
 	State RawPanelState
 }
 
+// Type ConnectConfig holds optional settings for ConnectWithConfig
+type ConnectConfig struct {
+	// Supervises the panel with pings. If nil, the panel is pinged every
+	// second and round trip times are tracked, but a panel which stops
+	// answering is not detected. Set MaxMissed in the HeartbeatConfig to
+	// close the connection (and cancel the context) on a dead panel.
+	Liveness *helpers.LivenessMonitor
+
+	// Coalesces feedback to the panel and rate limits writes. If nil,
+	// every message is written right away.
+	SendScheduler *helpers.SendScheduler
+
+	// Connects with TLS if set. Put a client certificate in Certificates
+	// for panels requiring mutual authentication.
+	TLSConfig *tls.Config
+
+	// Max time for establishing a connection. 0 means no timeout other
+	// than the operating system's.
+	ConnectTimeout time.Duration
+
+	// Max time to wait for the reply when detecting binary or ASCII mode.
+	// Default is helpers.DefaultDetectionTimeout.
+	DetectionTimeout time.Duration
+
+	// How the encoding of the panel is chosen. Default is
+	// helpers.ProtocolAuto, which probes with a binary ping.
+	ProtocolMode helpers.ProtocolMode
+
+	// Counts messages, bytes and ping round trip times of the connection.
+	Metrics *helpers.ConnectionMetrics
+
+	// Records all messages to and from the panel, e.g. to a .rwplog file
+	// which can be played back with ReplayToPanel or ReplayFromPanel.
+	Recorder *helpers.Recorder
+
+	// Max payload size of binary frames. Default is
+	// helpers.DefaultMaxFrameSize. Larger frames from the panel close the
+	// connection.
+	MaxFrameSize int
+
+	// Max time methods like GetRunTimeStats wait for the answer of the
+	// panel if their context has no deadline. Default is
+	// DefaultRequestTimeout.
+	RequestTimeout time.Duration
+
+	// Keeps the RawPanel alive when the panel disconnects, e.g. because
+	// it is power cycled. It reconnects in the background, initializes
+	// the panel again and restores the feedback (LEDs, text, graphics
+	// and brightness) sent before. Bindings are kept. Feedback sent while
+	// disconnected is not written, but restored after reconnecting.
+	// Graphics with an X/Y offset draw on top of what is on the display,
+	// so only the last full image is restored.
+	// The context is only cancelled if the retry policy gives up.
+	Persistent bool
+
+	// Delay between reconnection attempts in persistent mode. Default is
+	// a helpers.BackoffRetryPolicy with its defaults.
+	RetryPolicy helpers.RetryPolicy
+
+	// How bound functions are called: In order or in parallel, and what
+	// happens with slow or panicking ones. If nil, they are called one
+	// after the other on a worker goroutine.
+	Dispatch *DispatchConfig
+
+	// Called when the connection to the panel is lost, and when it is
+	// initialized again in persistent mode.
+	OnConnectionChange func(connected bool)
+
+	// Opens the connection instead of dialing panelIPAndPort, which is
+	// then only used for logging. TLSConfig is not used.
+	Transport helpers.Transport
+}
+
 // Connects to a SKAARHOJ Raw Panel at a specified URL. If successful it returns a new RawPanel
+// Use "native" or "host" to connect to the hardware server socket on a SKAARHOJ device
 func Connect(panelIPAndPort string, ctx context.Context, cancel context.CancelFunc) (*RawPanel, error) {
+	return ConnectWithConfig(panelIPAndPort, ctx, cancel, nil)
+}
 
-	dialMode := "tcp"
-	//oldIPandPortString := panelIPAndPort
-	if panelIPAndPort == "native" || panelIPAndPort == "host" {
-		dialMode = "unix"
-		panelIPAndPort = "/var/ibeam/sockets/ibeam-hardware.socket"
-	}
+// Connects to a SKAARHOJ Raw Panel at a specified URL with additional
+// configuration options. Config is optional.
+func ConnectWithConfig(panelIPAndPort string, ctx context.Context, cancel context.CancelFunc, config *ConnectConfig) (*RawPanel, error) {
 
-	c, err := net.Dial(dialMode, panelIPAndPort)
-	if log.Should(err) {
-		return nil, err
+	var transport helpers.Transport
+	if config != nil && config.Transport != nil {
+		transport = config.Transport
+	} else if panelIPAndPort == "native" || panelIPAndPort == "host" {
+		transport = helpers.UnixTransport(helpers.HostSocketPath)
+	} else {
+		var tlsConfig *tls.Config
+		if config != nil {
+			tlsConfig = config.TLSConfig
+		}
+		transport = &helpers.NetTransport{Network: "tcp", Address: panelIPAndPort, TLSConfig: tlsConfig}
 	}
 
-	binaryPanel := helpers.AutoDetectIfPanelEncodingIsBinary(c, panelIPAndPort)
-
-	// Set up new raw panel, handshake and initialize:
+	// Set up new raw panel:
 	newRawPanel := &RawPanel{
-		connection: c,
-		cancel:     &cancel,
-		toPanel:    make(chan []*rwp.InboundMessage, 10),
-		fromPanel:  make(chan []*rwp.OutboundMessage, 10),
-
-		binaryBindings:    make(map[uint32]BinaryFunc),
-		pulsedBindings:    make(map[uint32]PulsedFunc),
-		absoluteBindings:  make(map[uint32]AbsoluteFunc),
-		intensityBindings: make(map[uint32]IntensityFunc),
-		triggerBindings:   make(map[uint32]TriggerFunc),
-
-		binaryPanel: binaryPanel,
+		cancel:    &cancel,
+		toPanel:   make(chan []*rwp.InboundMessage, 10),
+		fromPanel: make(chan []*rwp.OutboundMessage, 10),
+
+		panelIPAndPort: panelIPAndPort,
+		transport:      transport,
+		protocolMode:   helpers.ProtocolAuto,
+
+		bindings: newBindingRegistry(),
+
+		requestTimeout: DefaultRequestTimeout,
+		done:           ctx.Done(),
 	}
 	newRawPanel.State.hwcAvailability = make(map[uint32]uint32)
+	newRawPanel.State.registers = make(map[string]*rwp.Register)
+	if config != nil {
+		newRawPanel.connectTimeout = config.ConnectTimeout
+		newRawPanel.detectionTimeout = config.DetectionTimeout
+		newRawPanel.protocolMode = config.ProtocolMode
+		newRawPanel.metrics = config.Metrics
+		newRawPanel.maxFrameSize = config.MaxFrameSize
+		newRawPanel.recorder = config.Recorder
+		newRawPanel.scheduler = config.SendScheduler
+		newRawPanel.onConnectionChange = config.OnConnectionChange
+		if config.RequestTimeout > 0 {
+			newRawPanel.requestTimeout = config.RequestTimeout
+		}
+		if config.Persistent {
+			newRawPanel.retryPolicy = config.RetryPolicy
+			if newRawPanel.retryPolicy == nil {
+				newRawPanel.retryPolicy = &helpers.BackoffRetryPolicy{}
+			}
+			newRawPanel.feedback = newFeedbackCache()
+		}
+	}
+
+	var dispatchConfig *DispatchConfig
+	if config != nil {
+		dispatchConfig = config.Dispatch
+	}
+	newRawPanel.dispatcher = newDispatcher(ctx, dispatchConfig, newRawPanel.bindings, newRawPanel.State.GetTopology)
+
+	if config != nil && config.Liveness != nil {
+		newRawPanel.liveness = config.Liveness
+	} else {
+		newRawPanel.liveness = helpers.NewLivenessMonitor(nil)
+	}
+	if newRawPanel.metrics != nil {
+		newRawPanel.liveness.OnRTT(newRawPanel.metrics.ObserveRTT)
+	}
+
+	heartBeatNegotiation, err := newRawPanel.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
 
 	// Start listening:
-	go newRawPanel.listen(ctx)
+	if newRawPanel.feedback != nil {
+		go newRawPanel.keepConnected(ctx)
+	} else {
+		go newRawPanel.listen(ctx)
+	}
 
 	// Try to initialize:
-	err = newRawPanel.init(ctx)
+	err = newRawPanel.init(ctx, heartBeatNegotiation)
 	if log.Should(err) {
-		c.Close()
+		if newRawPanel.feedback != nil { // Don't keep reconnecting in the background, the caller gets no RawPanel
+			cancel()
+		}
+		newRawPanel.connection.Close()
 		return nil, err
 	}
+	newRawPanel.connected.Store(true) // OnConnectionChange is only called for changes later on
 
 	return newRawPanel, nil
 }
 
+// Opens the connection, detects the encoding of the panel and prepares for a new session with it.
+// Returns messages to send to the panel right away (heart beat negotiation), if any.
+func (rp *RawPanel) dial(ctx context.Context) ([]*rwp.InboundMessage, error) {
+	dialCtx := ctx
+	if rp.connectTimeout > 0 {
+		var cancelDial context.CancelFunc
+		dialCtx, cancelDial = context.WithTimeout(ctx, rp.connectTimeout)
+		defer cancelDial()
+	}
+
+	c, err := helpers.DialTransport(dialCtx, rp.transport)
+	if log.Should(err) {
+		return nil, err
+	}
+	c = rp.metrics.Conn(c)
+
+	binaryPanel, _, err := helpers.DetectPanelEncodingWithMode(ctx, c, rp.panelIPAndPort, rp.protocolMode, rp.detectionTimeout)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	rp.metrics.Detected(binaryPanel)
+
+	rp.connection = c
+	rp.connFailed = make(chan struct{})
+	rp.binaryPanel = binaryPanel
+	rp.frameWriter = helpers.NewFrameWriter(c, rp.maxFrameSize)
+
+	heartBeatNegotiation := rp.liveness.Start()
+	rp.metrics.ConnectionUp()
+	if rp.scheduler != nil {
+		rp.scheduler.Reset()
+	}
+	return heartBeatNegotiation, nil
+}
+
 // Closes a raw panel connection by calling the context cancel function
 func (rp *RawPanel) Close() {
 	(*rp.cancel)()
 }
 
+// Returns round trip statistics of the pings sent to the panel
+func (rp *RawPanel) RTTStats() helpers.RTTStats {
+	return rp.liveness.Stats()
+}
+
 // Asking a panel for initial information:
-func (rp *RawPanel) init(ctx context.Context) error {
+func (rp *RawPanel) init(ctx context.Context, heartBeatNegotiation []*rwp.InboundMessage) error {
+	connFailed := rp.connFailed
 
 	// Sending request for various standard information from panel, all things we consider mandatory for initialization:
 	rp.toPanel <- []*rwp.InboundMessage{{
@@ -123,6 +326,9 @@ func (rp *RawPanel) init(ctx context.Context) error {
 			},
 		},
 	}}
+	if heartBeatNegotiation != nil { // Overrides the default heart beat timer above
+		rp.toPanel <- heartBeatNegotiation
+	}
 
 	// Check for initialization, if we get it, return in channel:
 	initialized := make(chan bool, 1)
@@ -138,8 +344,15 @@ func (rp *RawPanel) init(ctx context.Context) error {
 
 	// Wait for either signal that init was OK - or return after two seconds where it did not happen
 	select {
+	case <-connFailed: // E.g. a TLS panel rejecting our certificate
+		return fmt.Errorf("panel closed the connection during initialization")
 	case <-ctx.Done():
-		return nil
+		select {
+		case <-connFailed: // Closing the connection also ends the context, when not persistent
+			return fmt.Errorf("panel closed the connection during initialization")
+		default:
+			return nil
+		}
 	case <-initialized:
 		return nil
 	case <-time.After(2 * time.Second):
@@ -148,83 +361,120 @@ func (rp *RawPanel) init(ctx context.Context) error {
 }
 
 func (rp *RawPanel) listen(ctx context.Context) {
+	rp.runSession(ctx)
+	rp.setConnected(false)
+	(*rp.cancel)()
+}
+
+// Talks to the panel until the connection fails or the context is done
+func (rp *RawPanel) runSession(ctx context.Context) {
+	sessionCtx, cancelSession := context.WithCancel(ctx)
+	loopDone := make(chan bool)
+	conn := rp.connection // rp.connection is replaced when reconnecting
+	connFailed := rp.connFailed
 
 	// Listening for messages to/from panel
 	go func() {
-		ticker := time.NewTicker(1000 * time.Millisecond)
+		defer close(loopDone)
+		heartbeat := time.NewTimer(rp.liveness.Interval())
+		defer heartbeat.Stop()
+		var flush helpers.FlushTimer
+		defer flush.Stop()
 		for {
+			toPanel := rp.toPanel
+			if rp.scheduler != nil && rp.scheduler.Full() { // Backpressure: Leave messages in the channel until the queue is flushed
+				toPanel = nil
+			}
 			select {
-			case <-ctx.Done():
+			case <-sessionCtx.Done():
 				//fmt.Println("Stops listening for toPanel messages")
 				return
-			case messagesToPanel := <-rp.toPanel: // Messages from us to the panel.
-				if rp.binaryPanel {
-					for _, msg := range messagesToPanel {
-						pbdata, _ := proto.Marshal(msg) // Encode data
-						log.Debugln("System -> Panel: ", pbdata)
-
-						header := make([]byte, 4)                                  // Create a 4-bytes header
-						binary.LittleEndian.PutUint32(header, uint32(len(pbdata))) // Fill it in
-						pbdata = append(header, pbdata...)                         // and concatenate it with the binary message
-						rp.connection.Write(pbdata)
-					}
+			case messagesToPanel := <-toPanel: // Messages from us to the panel.
+				rp.feedback.record(messagesToPanel)
+				if rp.scheduler != nil {
+					rp.scheduler.Push(messagesToPanel)
 				} else {
-					lines := helpers.InboundMessagesToRawPanelASCIIstrings(messagesToPanel)
-					for _, line := range lines {
-						log.Debugln(string("System -> Panel: " + strings.TrimSpace(string(line))))
-						rp.connection.Write([]byte(line + "\n"))
-					}
+					rp.writeToPanel(messagesToPanel)
+				}
+			case <-flush.C(rp.scheduler):
+				rp.writeToPanel(rp.scheduler.Flush())
+			case <-heartbeat.C: // Sending a ping periodically to the panel to make sure TCP will close connection if it doesn't get through. The liveness monitor matches the ACKs coming back.
+				ping, err := rp.liveness.Tick()
+				if err != nil {
+					log.Errorln("Panel: " + conn.RemoteAddr().String() + " stopped responding to pings")
+					conn.Close() // Makes readFromPanel return
+					return
 				}
-			case <-ticker.C: // Sending a ping periodically to the panel to make sure TCP will close connection if it doesn't get through. Strictly, the panel should answer back with ACK, but we don't check for that (seems this is enough)
-				rp.toPanel <- []*rwp.InboundMessage{{
-					FlowMessage: rwp.InboundMessage_PING,
-				}}
+				rp.writeToPanel([]*rwp.InboundMessage{ping})
+				heartbeat.Reset(rp.liveness.Interval())
 			case messagesFromPanel := <-rp.fromPanel:
 				rp.procesMessagesFromPanel(messagesFromPanel)
 			}
 		}
 	}()
 
+	// Closing the connection makes readFromPanel return when the context is done:
+	go func() {
+		<-sessionCtx.Done()
+		conn.Close()
+	}()
+
 	// Read from panel. This will send into the rp.fromPanel channel. It returns when there is an error:
-	err := rp.readFromPanel()
-	log.Should(err)
+	err := rp.readFromPanel(sessionCtx)
+	if ctx.Err() == nil {
+		log.Should(err)
+		close(connFailed)
+	}
+	rp.metrics.ConnectionDown()
 
-	rp.connection.Close()
-	(*rp.cancel)()
+	cancelSession()
+	<-loopDone
 }
 
-func (rp *RawPanel) readFromPanel() error {
+// Writes messages to the panel in its encoding
+func (rp *RawPanel) writeToPanel(messagesToPanel []*rwp.InboundMessage) {
+	rp.metrics.CountToPanel(messagesToPanel)
+	rp.recorder.RecordToPanel(messagesToPanel)
+	if rp.binaryPanel {
+		for _, msg := range messagesToPanel {
+			log.Debugln("System -> Panel: ", msg)
+			log.Should(rp.frameWriter.WriteMessage(msg))
+		}
+	} else {
+		lines := helpers.InboundMessagesToRawPanelASCIIstrings(messagesToPanel)
+		for _, line := range lines {
+			log.Debugln(string("System -> Panel: " + strings.TrimSpace(string(line))))
+			rp.connection.Write([]byte(line + "\n"))
+		}
+	}
+}
+
+func (rp *RawPanel) readFromPanel(ctx context.Context) error {
 	// Reading from panel:
 	if rp.binaryPanel {
+		frameReader := helpers.NewFrameReader(rp.connection, rp.maxFrameSize)
 		for {
-			rp.connection.SetReadDeadline(time.Time{}) // Reset deadline, waiting for header
-			headerArray := make([]byte, 4)
-			_, err := io.ReadFull(rp.connection, headerArray) // Read 4 header bytes
+			outgoingMessage := &rwp.OutboundMessage{}
+			err := frameReader.ReadMessage(outgoingMessage)
+			if helpers.IsRecoverableFrameError(err) {
+				log.Warnln("Skipping frame from panel:", err)
+				continue
+			}
 			if err != nil {
 				if err == io.EOF {
 					log.Errorln("Panel: " + rp.connection.RemoteAddr().String() + " disconnected")
 				} else {
-					log.Errorln("Binary: ", err)
+					log.Errorln("Binary: ", err) // Oversize or truncated frames leave the stream out of sync, so we disconnect
 				}
 				return err
-			} else {
-				currentPayloadLength := binary.LittleEndian.Uint32(headerArray[0:4])
-				if currentPayloadLength < 500000 {
-					payload := make([]byte, currentPayloadLength)
-					rp.connection.SetReadDeadline(time.Now().Add(2 * time.Second)) // Set a deadline that we want all data within at most 2 seconds. This helps a run-away scenario where not all data arrives or we read the wront (and too big) header
-					_, err := io.ReadFull(rp.connection, payload)
-					if err != nil {
-						log.Errorln(err)
-						break
-					} else {
-						outgoingMessage := &rwp.OutboundMessage{}
-						proto.Unmarshal(payload, outgoingMessage)
-						if outgoingMessage.FlowMessage != 2 { // ack
-							rp.fromPanel <- []*rwp.OutboundMessage{outgoingMessage}
-						}
-					}
-				} else {
-					log.Println("Error: Payload", currentPayloadLength, "exceed limit")
+			}
+			rp.metrics.CountFromPanel([]*rwp.OutboundMessage{outgoingMessage})
+			rp.recorder.RecordFromPanel([]*rwp.OutboundMessage{outgoingMessage})
+			if !rp.liveness.Received(outgoingMessage) { // ACKs are consumed by the liveness monitor
+				select {
+				case rp.fromPanel <- []*rwp.OutboundMessage{outgoingMessage}:
+				case <-ctx.Done():
+					return ctx.Err()
 				}
 			}
 		}
@@ -246,16 +496,25 @@ func (rp *RawPanel) readFromPanel() error {
 				return err
 			} else {
 				netDataStr := strings.TrimSpace(netData)
-				switch netDataStr {
-				case "ack":
-				default:
-					rp.fromPanel <- helpers.RawPanelASCIIstringsToOutboundMessages([]string{netDataStr})
+				messagesFromPanel := []*rwp.OutboundMessage{}
+				converted := helpers.RawPanelASCIIstringsToOutboundMessages([]string{netDataStr})
+				rp.metrics.CountFromPanel(converted)
+				rp.recorder.RecordFromPanel(converted)
+				for _, msg := range converted {
+					if !rp.liveness.Received(msg) { // ACKs are consumed by the liveness monitor
+						messagesFromPanel = append(messagesFromPanel, msg)
+					}
+				}
+				if len(messagesFromPanel) > 0 {
+					select {
+					case rp.fromPanel <- messagesFromPanel:
+					case <-ctx.Done():
+						return ctx.Err()
+					}
 				}
 			}
 		}
 	}
-
-	return nil
 }
 
 func (rp *RawPanel) procesMessagesFromPanel(messagesFromPanel []*rwp.OutboundMessage) {
@@ -269,72 +528,15 @@ func (rp *RawPanel) procesMessagesFromPanel(messagesFromPanel []*rwp.OutboundMes
 			}}
 		}
 
-		// Store panel info:
-		if msg.PanelInfo != nil {
-			rp.State.Lock()
-			if msg.PanelInfo.Model != "" {
-				rp.State.model = msg.PanelInfo.Model
-				log.Debugln("Model:", msg.PanelInfo.Model)
-			}
-			if msg.PanelInfo.Serial != "" {
-				rp.State.serial = msg.PanelInfo.Serial
-				log.Debugln("Serial:", msg.PanelInfo.Serial)
-			}
-			if msg.PanelInfo.Name != "" {
-				rp.State.name = msg.PanelInfo.Name
-				log.Debugln("Name:", msg.PanelInfo.Name)
-			}
-			rp.State.Unlock()
-		}
-
-		// Panel availability:
-		if msg.HWCavailability != nil {
-			rp.State.Lock()
-			for k, v := range msg.HWCavailability {
-				rp.State.hwcAvailability[k] = v
-			}
-			rp.State.Unlock()
-		}
+		// Answers to requests:
+		rp.requests.deliver(msg, rp.binaryPanel)
 
-		// Topology:
-		if msg.PanelTopology != nil { // Receiving topology
-			rp.State.Lock()
-			if msg.PanelTopology.Json != "" {
-				rp.State.topologyJSON = msg.PanelTopology.Json
-				rp.State.topology = &topology.Topology{}
-				err := json.Unmarshal([]byte(rp.State.topologyJSON), rp.State.topology)
-				if err != nil {
-					log.Errorln("Topology JSON parsing Error: ", err)
-				} else {
-					log.Debugln(log.Indent(rp.State.topology))
-				}
-			}
-			if msg.PanelTopology.Svgbase != "" {
-				rp.State.topologySVG = msg.PanelTopology.Svgbase
-				log.Debugln("Received Topology SVG")
-			}
-			rp.State.Unlock()
-		}
+		// Panel info, topology, availability, health etc.:
+		rp.State.update(msg)
 
 		// Events:
-		if len(msg.Events) > 0 {
-			for _, event := range msg.Events {
-				if receiverFunc, exists := rp.triggerBindings[event.HWCID]; exists {
-					receiverFunc(event.HWCID, event)
-				}
-				if receiverFunc, exists := rp.binaryBindings[event.HWCID]; exists && event.Binary != nil {
-					receiverFunc(event.HWCID, BinaryStatus(su.Qint(event.Binary.Pressed, 1, 0)), BinaryEdge(event.Binary.Edge))
-				}
-				if receiverFunc, exists := rp.pulsedBindings[event.HWCID]; exists && event.Pulsed != nil {
-					receiverFunc(event.HWCID, int(event.Pulsed.Value))
-				}
-				if receiverFunc, exists := rp.absoluteBindings[event.HWCID]; exists && event.Absolute != nil {
-					receiverFunc(event.HWCID, int(event.Absolute.Value))
-				}
-				if receiverFunc, exists := rp.intensityBindings[event.HWCID]; exists && event.Speed != nil {
-					receiverFunc(event.HWCID, int(event.Speed.Value))
-				}
-			}
+		for _, event := range msg.Events {
+			rp.dispatcher.dispatch(event)
 		}
 	}
 }
@@ -342,11 +544,11 @@ func (rp *RawPanel) procesMessagesFromPanel(messagesFromPanel []*rwp.OutboundMes
 func (rp *RawPanel) IsInitialized() bool {
 	rp.State.Lock()
 	defer rp.State.Unlock()
-	if rp.State.model != "" &&
+	if rp.State.received.model &&
 		//rp.State.name != "" &&	// Not all panels send a name!
-		rp.State.serial != "" &&
-		rp.State.topologyJSON != "" &&
-		rp.State.topologySVG != "" {
+		rp.State.received.serial &&
+		rp.State.received.topologyJSON &&
+		rp.State.received.topologySVG {
 		return true
 	}
 	return false
@@ -354,7 +556,7 @@ func (rp *RawPanel) IsInitialized() bool {
 
 // Sets the panel brightness (same for OLED and LEDs in this case)
 func (rp *RawPanel) SetBrightness(brightness int) {
-	rp.toPanel <- []*rwp.InboundMessage{
+	rp.send([]*rwp.InboundMessage{
 		{
 			Command: &rwp.Command{
 				PanelBrightness: &rwp.Brightness{
@@ -363,13 +565,13 @@ func (rp *RawPanel) SetBrightness(brightness int) {
 				},
 			},
 		},
-	}
+	})
 }
 
 // Sets the color of a specific LED.
 func (rp *RawPanel) SetLEDColor(hwc uint32, c color.RGBA, intensity rwp.HWCMode_StateE) {
 	r, g, b, _ := c.RGBA()
-	rp.toPanel <- []*rwp.InboundMessage{
+	rp.send([]*rwp.InboundMessage{
 		{
 			States: []*rwp.HWCState{
 				{
@@ -387,12 +589,12 @@ func (rp *RawPanel) SetLEDColor(hwc uint32, c color.RGBA, intensity rwp.HWCMode_
 				},
 			},
 		},
-	}
+	})
 }
 
 // Sets the color of a specific LED by index
 func (rp *RawPanel) SetLEDColorByIndex(hwc uint32, colorIndex rwp.ColorIndex_Colors, intensity rwp.HWCMode_StateE) {
-	rp.toPanel <- []*rwp.InboundMessage{
+	rp.send([]*rwp.InboundMessage{
 		{
 			States: []*rwp.HWCState{
 				{
@@ -408,7 +610,7 @@ func (rp *RawPanel) SetLEDColorByIndex(hwc uint32, colorIndex rwp.ColorIndex_Col
 				},
 			},
 		},
-	}
+	})
 }
 
 // Sets the raw panel ASCII text of a display (text lines and header type)
@@ -426,7 +628,7 @@ func (rp *RawPanel) SetRWPText(hwc uint32, title string, text1 string, text2 str
 
 // Sets the raw panel ASCII text of a display by forwarding a full text struct
 func (rp *RawPanel) SetRWPTextByStruct(hwc uint32, txtStruct *rwp.HWCText) {
-	rp.toPanel <- []*rwp.InboundMessage{
+	rp.send([]*rwp.InboundMessage{
 		{
 			States: []*rwp.HWCState{
 				{
@@ -435,7 +637,7 @@ func (rp *RawPanel) SetRWPTextByStruct(hwc uint32, txtStruct *rwp.HWCText) {
 				},
 			},
 		},
-	}
+	})
 }
 
 // Type DrawFitting represents how the image is scaled
@@ -522,7 +724,7 @@ func (rp *RawPanel) DrawImageOptions(hwc uint32, inImg image.Image, displayInfo
 	// Map the image onto the canvas
 	rawpanelproc.RenderImageOnCanvas(&img, newImage, imgBounds, "", "", "")
 
-	rp.toPanel <- []*rwp.InboundMessage{
+	rp.send([]*rwp.InboundMessage{
 		{
 			States: []*rwp.HWCState{
 				{
@@ -531,7 +733,7 @@ func (rp *RawPanel) DrawImageOptions(hwc uint32, inImg image.Image, displayInfo
 				},
 			},
 		},
-	}
+	})
 
 	return nil
 }
@@ -539,9 +741,9 @@ func (rp *RawPanel) DrawImageOptions(hwc uint32, inImg image.Image, displayInfo
 // Function SendRawState just forwards a state struct
 // to the panel
 func (rp *RawPanel) SendRawState(state *rwp.HWCState) {
-	rp.toPanel <- []*rwp.InboundMessage{
+	rp.send([]*rwp.InboundMessage{
 		{
 			States: []*rwp.HWCState{state},
 		},
-	}
+	})
 }
@@ -7,9 +7,38 @@
 package gorwp
 
 import (
+	"encoding/json"
+	"sort"
 	"sync"
 
+	"google.golang.org/protobuf/proto"
+
+	rwp "github.com/SKAARHOJ/rawpanel-lib/ibeam_rawpanel"
 	topology "github.com/SKAARHOJ/rawpanel-lib/topology"
+	log "github.com/s00500/env_logger"
+)
+
+// Identifies a part of the panel state in change notifications
+type StateField string
+
+const (
+	StatePanelInfo                 StateField = "PanelInfo" // Model, serial, name, software version, platform, panel type, supported encodings...
+	StateTopology                  StateField = "Topology"
+	StateHWCAvailability           StateField = "HWCAvailability"
+	StateSleepState                StateField = "SleepState"
+	StateSleepTimeout              StateField = "SleepTimeout"
+	StateSysStat                   StateField = "SysStat"
+	StateEnvironmentalHealth       StateField = "EnvironmentalHealth"
+	StateBusStatus                 StateField = "BusStatus"
+	StateDimmedGain                StateField = "DimmedGain"
+	StateHeartBeatTimer            StateField = "HeartBeatTimer"
+	StateConnections               StateField = "Connections"
+	StateRunTimeStats              StateField = "RunTimeStats"
+	StateRegisters                 StateField = "Registers"
+	StateNetworkConfig             StateField = "NetworkConfig"
+	StateBurninProfile             StateField = "BurninProfile"
+	StateCalibrationProfile        StateField = "CalibrationProfile"
+	StateDefaultCalibrationProfile StateField = "DefaultCalibrationProfile"
 )
 
 // Contains information retrieved from the panel
@@ -23,6 +52,206 @@ type RawPanelState struct {
 	serial          string             // Serial number
 	name            string             // Name of controller
 	hwcAvailability map[uint32]uint32  // Enabled/mapped hardware components
+
+	panelInfo                 *rwp.PanelInfo           // All panel info received, ASCII panels send it line by line
+	sleepState                *rwp.SleepState          // Whether the panel is sleeping
+	sleepTimeout              *rwp.SleepTimeout        // Minutes until the panel goes to sleep
+	sysStat                   *rwp.SystemStat          // CPU, memory and throttling, if published by the panel
+	environmentalHealth       *rwp.Environment         // Run mode of the panel
+	busStatus                 *rwp.BusStatus           // Internal bus faults
+	dimmedGain                *rwp.DimmedGain          // Brightness of dimmed LEDs
+	heartBeatTimer            *rwp.HeartBeatTimer      // Heart beat interval agreed with the panel
+	connections               *rwp.Connections         // Systems connected to the panel
+	runTimeStats              *rwp.RunTimeStats        // Boot count and uptimes
+	registers                 map[string]*rwp.Register // Registers by type and ID
+	networkConfig             *rwp.NetworkConfig       // Network configuration
+	burninProfile             *rwp.BurninProfile       // Burnin profile (ibeam panels)
+	calibrationProfile        *rwp.CalibrationProfile  // Calibration profile in use
+	defaultCalibrationProfile *rwp.CalibrationProfile  // Factory calibration profile
+
+	received    initReceived // What the panel sent since (re)connecting, see RawPanel.IsInitialized
+	changeFuncs []func(field StateField)
+}
+
+type initReceived struct {
+	model, serial, topologyJSON, topologySVG bool
+}
+
+// Forgets what was received, so IsInitialized waits for the panel to send it again after reconnecting
+func (rps *RawPanelState) resetInitialized() {
+	rps.Lock()
+	defer rps.Unlock()
+	rps.received = initReceived{}
+}
+
+// Registers a function called whenever a part of the state changes.
+// It is called from the goroutine processing messages from the panel,
+// so it must not block. Use the getters to read the new values.
+func (rps *RawPanelState) OnChange(fn func(field StateField)) {
+	rps.Lock()
+	defer rps.Unlock()
+	rps.changeFuncs = append(rps.changeFuncs, fn)
+}
+
+// Stores what a message from the panel tells about it and notifies about the parts which changed
+func (rps *RawPanelState) update(msg *rwp.OutboundMessage) {
+	changed := []StateField{}
+	rps.Lock()
+
+	// Panel info, ASCII panels send it line by line:
+	if msg.PanelInfo != nil {
+		if msg.PanelInfo.Model != "" {
+			rps.received.model = true
+			rps.model = msg.PanelInfo.Model
+			log.Debugln("Model:", msg.PanelInfo.Model)
+		}
+		if msg.PanelInfo.Serial != "" {
+			rps.received.serial = true
+			rps.serial = msg.PanelInfo.Serial
+			log.Debugln("Serial:", msg.PanelInfo.Serial)
+		}
+		if msg.PanelInfo.Name != "" {
+			rps.name = msg.PanelInfo.Name
+			log.Debugln("Name:", msg.PanelInfo.Name)
+		}
+		info := &rwp.PanelInfo{}
+		if rps.panelInfo != nil {
+			info = proto.Clone(rps.panelInfo).(*rwp.PanelInfo)
+		}
+		if len(msg.PanelInfo.LockedToIPs) > 0 {
+			info.LockedToIPs = nil // Merging would append to the old list
+		}
+		proto.Merge(info, msg.PanelInfo)
+		if !proto.Equal(rps.panelInfo, info) {
+			rps.panelInfo = info
+			changed = append(changed, StatePanelInfo)
+		}
+	}
+
+	// Panel availability:
+	if msg.HWCavailability != nil {
+		availabilityChanged := false
+		for k, v := range msg.HWCavailability {
+			if current, exists := rps.hwcAvailability[k]; !exists || current != v {
+				rps.hwcAvailability[k] = v
+				availabilityChanged = true
+			}
+		}
+		if availabilityChanged {
+			changed = append(changed, StateHWCAvailability)
+		}
+	}
+
+	// Topology:
+	if msg.PanelTopology != nil { // Receiving topology
+		rps.received.topologyJSON = rps.received.topologyJSON || msg.PanelTopology.Json != ""
+		rps.received.topologySVG = rps.received.topologySVG || msg.PanelTopology.Svgbase != ""
+		if msg.PanelTopology.Json != "" && msg.PanelTopology.Json != rps.topologyJSON {
+			rps.topologyJSON = msg.PanelTopology.Json
+			rps.topology = &topology.Topology{}
+			err := json.Unmarshal([]byte(rps.topologyJSON), rps.topology)
+			if err != nil {
+				log.Errorln("Topology JSON parsing Error: ", err)
+			} else {
+				log.Debugln(log.Indent(rps.topology))
+			}
+			changed = append(changed, StateTopology)
+		}
+		if msg.PanelTopology.Svgbase != "" && msg.PanelTopology.Svgbase != rps.topologySVG {
+			rps.topologySVG = msg.PanelTopology.Svgbase
+			log.Debugln("Received Topology SVG")
+			if len(changed) == 0 || changed[len(changed)-1] != StateTopology {
+				changed = append(changed, StateTopology)
+			}
+		}
+	}
+
+	// Registers, ASCII panels send them one by one:
+	if len(msg.Registers) > 0 {
+		registersChanged := false
+		for _, register := range msg.Registers {
+			key := register.Reg.String() + register.Id
+			if current, exists := rps.registers[key]; !exists || !proto.Equal(current, register) {
+				rps.registers[key] = proto.Clone(register).(*rwp.Register)
+				registersChanged = true
+			}
+		}
+		if registersChanged {
+			changed = append(changed, StateRegisters)
+		}
+	}
+
+	// Run time stats, ASCII panels send them line by line:
+	if msg.RunTimeStats != nil {
+		stats := &rwp.RunTimeStats{}
+		if rps.runTimeStats != nil {
+			stats = proto.Clone(rps.runTimeStats).(*rwp.RunTimeStats)
+		}
+		proto.Merge(stats, msg.RunTimeStats)
+		if !proto.Equal(rps.runTimeStats, stats) {
+			rps.runTimeStats = stats
+			changed = append(changed, StateRunTimeStats)
+		}
+	}
+
+	// Everything else is replaced as a whole:
+	if msg.SleepState != nil && !proto.Equal(rps.sleepState, msg.SleepState) {
+		rps.sleepState = proto.Clone(msg.SleepState).(*rwp.SleepState)
+		changed = append(changed, StateSleepState)
+	}
+	if msg.SleepTimeout != nil && !proto.Equal(rps.sleepTimeout, msg.SleepTimeout) {
+		rps.sleepTimeout = proto.Clone(msg.SleepTimeout).(*rwp.SleepTimeout)
+		changed = append(changed, StateSleepTimeout)
+	}
+	if msg.SysStat != nil && !proto.Equal(rps.sysStat, msg.SysStat) {
+		rps.sysStat = proto.Clone(msg.SysStat).(*rwp.SystemStat)
+		changed = append(changed, StateSysStat)
+	}
+	if msg.EnvironmentalHealth != nil && !proto.Equal(rps.environmentalHealth, msg.EnvironmentalHealth) {
+		rps.environmentalHealth = proto.Clone(msg.EnvironmentalHealth).(*rwp.Environment)
+		changed = append(changed, StateEnvironmentalHealth)
+	}
+	if msg.BusStatus != nil && !proto.Equal(rps.busStatus, msg.BusStatus) {
+		rps.busStatus = proto.Clone(msg.BusStatus).(*rwp.BusStatus)
+		changed = append(changed, StateBusStatus)
+	}
+	if msg.DimmedGain != nil && !proto.Equal(rps.dimmedGain, msg.DimmedGain) {
+		rps.dimmedGain = proto.Clone(msg.DimmedGain).(*rwp.DimmedGain)
+		changed = append(changed, StateDimmedGain)
+	}
+	if msg.HeartBeatTimer != nil && !proto.Equal(rps.heartBeatTimer, msg.HeartBeatTimer) {
+		rps.heartBeatTimer = proto.Clone(msg.HeartBeatTimer).(*rwp.HeartBeatTimer)
+		changed = append(changed, StateHeartBeatTimer)
+	}
+	if msg.Connections != nil && !proto.Equal(rps.connections, msg.Connections) {
+		rps.connections = proto.Clone(msg.Connections).(*rwp.Connections)
+		changed = append(changed, StateConnections)
+	}
+	if msg.NetworkConfig != nil && !proto.Equal(rps.networkConfig, msg.NetworkConfig) {
+		rps.networkConfig = proto.Clone(msg.NetworkConfig).(*rwp.NetworkConfig)
+		changed = append(changed, StateNetworkConfig)
+	}
+	if msg.BurninProfile != nil && !proto.Equal(rps.burninProfile, msg.BurninProfile) {
+		rps.burninProfile = proto.Clone(msg.BurninProfile).(*rwp.BurninProfile)
+		changed = append(changed, StateBurninProfile)
+	}
+	if msg.CalibrationProfile != nil && !proto.Equal(rps.calibrationProfile, msg.CalibrationProfile) {
+		rps.calibrationProfile = proto.Clone(msg.CalibrationProfile).(*rwp.CalibrationProfile)
+		changed = append(changed, StateCalibrationProfile)
+	}
+	if msg.DefaultCalibrationProfile != nil && !proto.Equal(rps.defaultCalibrationProfile, msg.DefaultCalibrationProfile) {
+		rps.defaultCalibrationProfile = proto.Clone(msg.DefaultCalibrationProfile).(*rwp.CalibrationProfile)
+		changed = append(changed, StateDefaultCalibrationProfile)
+	}
+
+	changeFuncs := rps.changeFuncs
+	rps.Unlock()
+
+	for _, field := range changed {
+		for _, fn := range changeFuncs {
+			fn(field)
+		}
+	}
 }
 
 func (rps *RawPanelState) GetName() string {
@@ -48,3 +277,152 @@ func (rps *RawPanelState) GetTopology() *topology.Topology {
 	defer rps.RUnlock()
 	return rps.topology // Should return copy?
 }
+
+func (rps *RawPanelState) GetTopologyJSON() string {
+	rps.RLock()
+	defer rps.RUnlock()
+	return rps.topologyJSON
+}
+
+func (rps *RawPanelState) GetTopologySVG() string {
+	rps.RLock()
+	defer rps.RUnlock()
+	return rps.topologySVG
+}
+
+// Returns a copy of the HWC availability map
+func (rps *RawPanelState) GetHWCAvailability() map[uint32]uint32 {
+	rps.RLock()
+	defer rps.RUnlock()
+	availability := make(map[uint32]uint32, len(rps.hwcAvailability))
+	for k, v := range rps.hwcAvailability {
+		availability[k] = v
+	}
+	return availability
+}
+
+// The getters below return copies, or nil if the panel didn't send the information (yet)
+
+func (rps *RawPanelState) GetPanelInfo() *rwp.PanelInfo {
+	rps.RLock()
+	defer rps.RUnlock()
+	return proto.Clone(rps.panelInfo).(*rwp.PanelInfo)
+}
+
+func (rps *RawPanelState) GetSoftwareVersion() string {
+	rps.RLock()
+	defer rps.RUnlock()
+	return rps.panelInfo.GetSoftwareVersion()
+}
+
+func (rps *RawPanelState) GetPlatform() string {
+	rps.RLock()
+	defer rps.RUnlock()
+	return rps.panelInfo.GetPlatform()
+}
+
+func (rps *RawPanelState) GetPanelType() rwp.PanelInfo_PanelTypeE {
+	rps.RLock()
+	defer rps.RUnlock()
+	return rps.panelInfo.GetPanelType()
+}
+
+func (rps *RawPanelState) GetRawPanelSupport() *rwp.RawPanelSupport {
+	rps.RLock()
+	defer rps.RUnlock()
+	return proto.Clone(rps.panelInfo.GetRawPanelSupport()).(*rwp.RawPanelSupport)
+}
+
+func (rps *RawPanelState) GetSleepState() *rwp.SleepState {
+	rps.RLock()
+	defer rps.RUnlock()
+	return proto.Clone(rps.sleepState).(*rwp.SleepState)
+}
+
+func (rps *RawPanelState) GetSleepTimeout() *rwp.SleepTimeout {
+	rps.RLock()
+	defer rps.RUnlock()
+	return proto.Clone(rps.sleepTimeout).(*rwp.SleepTimeout)
+}
+
+func (rps *RawPanelState) GetSysStat() *rwp.SystemStat {
+	rps.RLock()
+	defer rps.RUnlock()
+	return proto.Clone(rps.sysStat).(*rwp.SystemStat)
+}
+
+func (rps *RawPanelState) GetEnvironmentalHealth() *rwp.Environment {
+	rps.RLock()
+	defer rps.RUnlock()
+	return proto.Clone(rps.environmentalHealth).(*rwp.Environment)
+}
+
+func (rps *RawPanelState) GetBusStatus() *rwp.BusStatus {
+	rps.RLock()
+	defer rps.RUnlock()
+	return proto.Clone(rps.busStatus).(*rwp.BusStatus)
+}
+
+func (rps *RawPanelState) GetDimmedGain() *rwp.DimmedGain {
+	rps.RLock()
+	defer rps.RUnlock()
+	return proto.Clone(rps.dimmedGain).(*rwp.DimmedGain)
+}
+
+func (rps *RawPanelState) GetHeartBeatTimer() *rwp.HeartBeatTimer {
+	rps.RLock()
+	defer rps.RUnlock()
+	return proto.Clone(rps.heartBeatTimer).(*rwp.HeartBeatTimer)
+}
+
+func (rps *RawPanelState) GetConnections() *rwp.Connections {
+	rps.RLock()
+	defer rps.RUnlock()
+	return proto.Clone(rps.connections).(*rwp.Connections)
+}
+
+func (rps *RawPanelState) GetRunTimeStats() *rwp.RunTimeStats {
+	rps.RLock()
+	defer rps.RUnlock()
+	return proto.Clone(rps.runTimeStats).(*rwp.RunTimeStats)
+}
+
+// Returns the registers sorted by type and ID
+func (rps *RawPanelState) GetRegisters() []*rwp.Register {
+	rps.RLock()
+	defer rps.RUnlock()
+	keys := make([]string, 0, len(rps.registers))
+	for key := range rps.registers {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	registers := make([]*rwp.Register, 0, len(keys))
+	for _, key := range keys {
+		registers = append(registers, proto.Clone(rps.registers[key]).(*rwp.Register))
+	}
+	return registers
+}
+
+func (rps *RawPanelState) GetNetworkConfig() *rwp.NetworkConfig {
+	rps.RLock()
+	defer rps.RUnlock()
+	return proto.Clone(rps.networkConfig).(*rwp.NetworkConfig)
+}
+
+func (rps *RawPanelState) GetBurninProfile() *rwp.BurninProfile {
+	rps.RLock()
+	defer rps.RUnlock()
+	return proto.Clone(rps.burninProfile).(*rwp.BurninProfile)
+}
+
+func (rps *RawPanelState) GetCalibrationProfile() *rwp.CalibrationProfile {
+	rps.RLock()
+	defer rps.RUnlock()
+	return proto.Clone(rps.calibrationProfile).(*rwp.CalibrationProfile)
+}
+
+func (rps *RawPanelState) GetDefaultCalibrationProfile() *rwp.CalibrationProfile {
+	rps.RLock()
+	defer rps.RUnlock()
+	return proto.Clone(rps.defaultCalibrationProfile).(*rwp.CalibrationProfile)
+}
@@ -0,0 +1,78 @@
+package gorwp_test
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"testing"
+
+	helpers "github.com/SKAARHOJ/rawpanel-lib"
+	"github.com/SKAARHOJ/rawpanel-lib/emulator"
+	gorwp "github.com/SKAARHOJ/rawpanel-lib/gorwp"
+	rwp "github.com/SKAARHOJ/rawpanel-lib/ibeam_rawpanel"
+	"github.com/SKAARHOJ/rawpanel-lib/internal/testpanel"
+)
+
+func TestGorwpStateCache(t *testing.T) {
+	// ASCII, so panel info arrives line by line:
+	_, rp := testpanel.StartAndConnect(t, &emulator.Config{SoftwareVersion: "v1.2.3", Platform: "emulator"}, &gorwp.ConnectConfig{ProtocolMode: helpers.ProtocolASCII})
+	if rp.State.GetSoftwareVersion() != "v1.2.3" || rp.State.GetPlatform() != "emulator" || rp.State.GetPanelType() != rwp.PanelInfo_EMULATION {
+		t.Fatalf("unexpected panel info %v", rp.State.GetPanelInfo())
+	}
+	if !rp.State.GetRawPanelSupport().GetASCII() || len(rp.State.GetHWCAvailability()) == 0 {
+		t.Fatalf("unexpected support %v or availability %v", rp.State.GetRawPanelSupport(), rp.State.GetHWCAvailability())
+	}
+
+	var mu sync.Mutex
+	changes := map[gorwp.StateField]int{}
+	rp.State.OnChange(func(field gorwp.StateField) {
+		mu.Lock()
+		changes[field]++
+		mu.Unlock()
+	})
+	changeCount := func(field gorwp.StateField) int {
+		mu.Lock()
+		defer mu.Unlock()
+		return changes[field]
+	}
+
+	// Answers to requests are cached:
+	if _, err := rp.GetRunTimeStats(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	testpanel.WaitFor(t, "run time stats", func() bool { return changeCount(gorwp.StateRunTimeStats) > 0 })
+	if rp.State.GetRunTimeStats().GetBootsCount() != 1 {
+		t.Fatalf("unexpected run time stats %v", rp.State.GetRunTimeStats())
+	}
+
+	// Health messages the emulator doesn't send, fed in through a recording. Repeated values are not notified:
+	var recording bytes.Buffer
+	recorder, err := helpers.NewRecorder(&recording, helpers.RecordBinary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder.RecordFromPanel([]*rwp.OutboundMessage{
+		{SleepState: &rwp.SleepState{IsSleeping: true}},
+		{SysStat: &rwp.SystemStat{CPUUsage: 42}},
+		{SleepState: &rwp.SleepState{IsSleeping: true}},
+		{BusStatus: &rwp.BusStatus{Fault: true}},
+	})
+	recorder.Close()
+	reader, err := helpers.NewRecordingReader(&recording)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rp.ReplayFromPanel(context.Background(), reader, -1); err != nil {
+		t.Fatal(err)
+	}
+	testpanel.WaitFor(t, "bus status", func() bool { return changeCount(gorwp.StateBusStatus) == 1 })
+	if changeCount(gorwp.StateSleepState) != 1 || changeCount(gorwp.StateSysStat) != 1 {
+		t.Fatalf("unexpected notifications %v", changes)
+	}
+	if !rp.State.GetSleepState().GetIsSleeping() || rp.State.GetSysStat().GetCPUUsage() != 42 || !rp.State.GetBusStatus().GetFault() {
+		t.Fatal("health not cached")
+	}
+	if rp.State.GetNetworkConfig() != nil {
+		t.Fatal("expected no network config")
+	}
+}
@@ -0,0 +1,55 @@
+package gorwp_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	helpers "github.com/SKAARHOJ/rawpanel-lib"
+	gorwp "github.com/SKAARHOJ/rawpanel-lib/gorwp"
+	rwp "github.com/SKAARHOJ/rawpanel-lib/ibeam_rawpanel"
+	"github.com/SKAARHOJ/rawpanel-lib/internal/testpanel"
+	"go.uber.org/atomic"
+)
+
+// Records a session with one emulator and replays it into another
+func TestRecordAndReplay(t *testing.T) {
+	var recording bytes.Buffer
+	recorder, err := helpers.NewRecorder(&recording, helpers.RecordBinary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	original, rp := testpanel.StartAndConnect(t, nil, &gorwp.ConnectConfig{Recorder: recorder})
+	var presses atomic.Int32
+	rp.BindBinary(1, func(hwc uint32, status gorwp.BinaryStatus, edge gorwp.BinaryEdge) {
+		if status == gorwp.Down {
+			presses.Inc()
+		}
+	})
+	rp.SendRawState(&rwp.HWCState{HWCIDs: []uint32{2}, HWCMode: &rwp.HWCMode{State: rwp.HWCMode_ON}})
+	testpanel.WaitFor(t, "feedback on the original panel", func() bool { return original.State(2) != nil })
+	original.Press(1, rwp.BinaryEvent_UNKNOWN)
+	testpanel.WaitFor(t, "press in the original session", func() bool { return presses.Load() == 1 })
+	recorder.Close()
+
+	// Without feedback from the replayed system, the state can only come from the recording:
+	replayed, rp := testpanel.StartAndConnect(t, nil, nil)
+	var replayedPresses atomic.Int32
+	rp.BindBinary(1, func(hwc uint32, status gorwp.BinaryStatus, edge gorwp.BinaryEdge) {
+		if status == gorwp.Down {
+			replayedPresses.Inc()
+		}
+	})
+
+	rr, err := helpers.NewRecordingReader(bytes.NewReader(recording.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := replayed.Replay(context.Background(), rr, -1); err != nil {
+		t.Fatal(err)
+	}
+	if replayed.State(2).GetHWCMode().GetState() != rwp.HWCMode_ON {
+		t.Fatal("recorded feedback not applied")
+	}
+	testpanel.WaitFor(t, "replayed press", func() bool { return replayedPresses.Load() == 1 })
+}
@@ -0,0 +1,47 @@
+/*
+   Copyright 2026 SKAARHOJ ApS
+
+   Released under MIT License
+*/
+
+package gorwp
+
+import (
+	"context"
+
+	helpers "github.com/SKAARHOJ/rawpanel-lib"
+	rwp "github.com/SKAARHOJ/rawpanel-lib/ibeam_rawpanel"
+)
+
+// Function ReplayToPanel sends the recorded system -> panel messages
+// of a recording to the connected panel, reproducing the feedback of
+// the recorded session. Speed is like in helpers.ReplayConfig: 1 is the
+// original timing, higher is faster and negative is without delays.
+// It blocks until the recording is done or the context is cancelled.
+func (rp *RawPanel) ReplayToPanel(ctx context.Context, recording *helpers.RecordingReader, speed float64) error {
+	return helpers.Replay(ctx, recording, helpers.ReplayConfig{
+		Speed: speed,
+		ToPanel: func(msgs []*rwp.InboundMessage) {
+			select {
+			case rp.toPanel <- msgs:
+			case <-ctx.Done():
+			}
+		},
+	})
+}
+
+// Function ReplayFromPanel feeds the recorded panel -> system messages
+// of a recording into the RawPanel as if the connected panel sent them,
+// so bound functions fire like in the recorded session.
+// It blocks until the recording is done or the context is cancelled.
+func (rp *RawPanel) ReplayFromPanel(ctx context.Context, recording *helpers.RecordingReader, speed float64) error {
+	return helpers.Replay(ctx, recording, helpers.ReplayConfig{
+		Speed: speed,
+		FromPanel: func(msgs []*rwp.OutboundMessage) {
+			select {
+			case rp.fromPanel <- msgs:
+			case <-ctx.Done():
+			}
+		},
+	})
+}
@@ -0,0 +1,222 @@
+/*
+   Copyright 2026 SKAARHOJ ApS
+
+   Released under MIT License
+*/
+
+package gorwp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	rwp "github.com/SKAARHOJ/rawpanel-lib/ibeam_rawpanel"
+)
+
+// Max time the request methods wait for an answer if the context has no
+// deadline and ConnectConfig.RequestTimeout is not set
+const DefaultRequestTimeout = 2 * time.Second
+
+// ASCII panels answer some requests with several lines, which are
+// collected until no further part arrived for this long
+const multiPartQuietTime = 100 * time.Millisecond
+
+// Returned by the request methods when the panel connection is closed
+// before the answer arrived
+var ErrClosed = errors.New("panel connection closed")
+
+// Type response describes which part of a panel message answers a request
+type response struct {
+	name      string
+	matches   func(msg *rwp.OutboundMessage) bool
+	multiPart bool // Sent as several messages by ASCII panels
+}
+
+var (
+	responseSleepTimeout = &response{name: "sleep timeout", matches: func(msg *rwp.OutboundMessage) bool { return msg.SleepTimeout != nil }}
+	responseConnections  = &response{name: "connections", matches: func(msg *rwp.OutboundMessage) bool { return msg.Connections != nil }}
+	responseRunTimeStats = &response{name: "run time stats", matches: func(msg *rwp.OutboundMessage) bool { return msg.RunTimeStats != nil }, multiPart: true}
+	responseBurnin       = &response{name: "burnin profile", matches: func(msg *rwp.OutboundMessage) bool { return msg.BurninProfile != nil }}
+	responseCalibration  = &response{name: "calibration profile", matches: func(msg *rwp.OutboundMessage) bool { return msg.CalibrationProfile != nil }}
+	responseNetwork      = &response{name: "network config", matches: func(msg *rwp.OutboundMessage) bool { return msg.NetworkConfig != nil }}
+	responseRegisters    = &response{name: "registers", matches: func(msg *rwp.OutboundMessage) bool { return len(msg.Registers) > 0 }, multiPart: true}
+)
+
+// Requests waiting for their answer, oldest first. Panels don't tag
+// answers, so an answer goes to the oldest request waiting for that kind.
+type pendingRequests struct {
+	sync.Mutex
+	waiting []*pendingRequest
+}
+
+// Parts of the answer are merged into the request by deliver, so none
+// get lost however many an ASCII panel sends. Guarded by pendingRequests.
+type pendingRequest struct {
+	response *response
+	updated  chan struct{}        // Signalled when a part was merged into answer
+	answer   *rwp.OutboundMessage // The parts received so far
+	complete bool                 // No further parts are expected
+}
+
+func (pr *pendingRequests) add(response *response) *pendingRequest {
+	request := &pendingRequest{response: response, updated: make(chan struct{}, 1)}
+	pr.Lock()
+	defer pr.Unlock()
+	pr.waiting = append(pr.waiting, request)
+	return request
+}
+
+// Removes a request, so it gets no further parts, and returns what it received
+func (pr *pendingRequests) take(request *pendingRequest) *rwp.OutboundMessage {
+	pr.Lock()
+	defer pr.Unlock()
+	for i, r := range pr.waiting {
+		if r == request {
+			pr.waiting = append(pr.waiting[:i], pr.waiting[i+1:]...)
+			break
+		}
+	}
+	return request.answer
+}
+
+// Returns whether a request has received all parts of its answer
+func (pr *pendingRequests) complete(request *pendingRequest) bool {
+	pr.Lock()
+	defer pr.Unlock()
+	return request.complete
+}
+
+// Merges a message from the panel into the oldest request it answers. Single part answers complete the request right away.
+func (pr *pendingRequests) deliver(msg *rwp.OutboundMessage, binaryPanel bool) {
+	pr.Lock()
+	defer pr.Unlock()
+	for i, request := range pr.waiting {
+		if !request.response.matches(msg) {
+			continue
+		}
+		if request.answer == nil {
+			request.answer = proto.Clone(msg).(*rwp.OutboundMessage)
+		} else {
+			proto.Merge(request.answer, msg)
+		}
+		if binaryPanel || !request.response.multiPart {
+			request.complete = true
+			pr.waiting = append(pr.waiting[:i], pr.waiting[i+1:]...)
+		}
+		select {
+		case request.updated <- struct{}{}:
+		default: // Already signalled, the request looks at all parts merged so far
+		}
+		return
+	}
+}
+
+// Sends a command and waits for the answer. Multi-part answers are merged into one message.
+func (rp *RawPanel) request(ctx context.Context, response *response, command *rwp.Command) (*rwp.OutboundMessage, error) {
+	if _, hasDeadline := ctx.Deadline(); !hasDeadline {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, rp.requestTimeout)
+		defer cancel()
+	}
+
+	request := rp.requests.add(response)
+
+	select {
+	case rp.toPanel <- []*rwp.InboundMessage{{Command: command}}:
+	case <-ctx.Done():
+		rp.requests.take(request)
+		return nil, fmt.Errorf("requesting %s: %w", response.name, ctx.Err())
+	case <-rp.done:
+		rp.requests.take(request)
+		return nil, fmt.Errorf("requesting %s: %w", response.name, ErrClosed)
+	}
+
+	var quiet <-chan time.Time
+	for {
+		select {
+		case <-request.updated:
+			if rp.requests.complete(request) {
+				return rp.requests.take(request), nil
+			}
+			quiet = time.After(multiPartQuietTime)
+		case <-quiet:
+			return rp.requests.take(request), nil
+		case <-ctx.Done():
+			if answer := rp.requests.take(request); answer != nil { // Return what we have of a multi-part answer
+				return answer, nil
+			}
+			return nil, fmt.Errorf("waiting for %s: %w", response.name, ctx.Err())
+		case <-rp.done:
+			rp.requests.take(request)
+			return nil, fmt.Errorf("waiting for %s: %w", response.name, ErrClosed)
+		}
+	}
+}
+
+// Asks the panel for its sleep timeout
+func (rp *RawPanel) GetSleepTimeout(ctx context.Context) (*rwp.SleepTimeout, error) {
+	answer, err := rp.request(ctx, responseSleepTimeout, &rwp.Command{GetSleepTimeout: true})
+	if err != nil {
+		return nil, err
+	}
+	return answer.SleepTimeout, nil
+}
+
+// Asks the panel which systems are connected to it
+func (rp *RawPanel) GetConnections(ctx context.Context) (*rwp.Connections, error) {
+	answer, err := rp.request(ctx, responseConnections, &rwp.Command{GetConnections: true})
+	if err != nil {
+		return nil, err
+	}
+	return answer.Connections, nil
+}
+
+// Asks the panel for boot count and uptimes
+func (rp *RawPanel) GetRunTimeStats(ctx context.Context) (*rwp.RunTimeStats, error) {
+	answer, err := rp.request(ctx, responseRunTimeStats, &rwp.Command{GetRunTimeStats: true})
+	if err != nil {
+		return nil, err
+	}
+	return answer.RunTimeStats, nil
+}
+
+// Asks the panel for its burnin profile (only ibeam panels have one)
+func (rp *RawPanel) GetBurninProfile(ctx context.Context) (*rwp.BurninProfile, error) {
+	answer, err := rp.request(ctx, responseBurnin, &rwp.Command{SendBurninProfile: true})
+	if err != nil {
+		return nil, err
+	}
+	return answer.BurninProfile, nil
+}
+
+// Asks the panel for its calibration profile
+func (rp *RawPanel) GetCalibrationProfile(ctx context.Context) (*rwp.CalibrationProfile, error) {
+	answer, err := rp.request(ctx, responseCalibration, &rwp.Command{SendCalibrationProfile: true})
+	if err != nil {
+		return nil, err
+	}
+	return answer.CalibrationProfile, nil
+}
+
+// Asks the panel for its network configuration (if applicable)
+func (rp *RawPanel) GetNetworkConfig(ctx context.Context) (*rwp.NetworkConfig, error) {
+	answer, err := rp.request(ctx, responseNetwork, &rwp.Command{SendNetworkConfig: true})
+	if err != nil {
+		return nil, err
+	}
+	return answer.NetworkConfig, nil
+}
+
+// Asks the panel for the values of its registers
+func (rp *RawPanel) GetRegisters(ctx context.Context) ([]*rwp.Register, error) {
+	answer, err := rp.request(ctx, responseRegisters, &rwp.Command{SendRegisters: true})
+	if err != nil {
+		return nil, err
+	}
+	return answer.Registers, nil
+}
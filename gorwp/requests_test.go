@@ -0,0 +1,64 @@
+package gorwp_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	helpers "github.com/SKAARHOJ/rawpanel-lib"
+	"github.com/SKAARHOJ/rawpanel-lib/emulator"
+	gorwp "github.com/SKAARHOJ/rawpanel-lib/gorwp"
+	rwp "github.com/SKAARHOJ/rawpanel-lib/ibeam_rawpanel"
+	"github.com/SKAARHOJ/rawpanel-lib/internal/testpanel"
+)
+
+func TestGorwpRequests(t *testing.T) {
+	for _, mode := range []helpers.ProtocolMode{helpers.ProtocolBinary, helpers.ProtocolASCII} {
+		t.Run(mode.String(), func(t *testing.T) {
+			registers := []*rwp.Register{}
+			for i := 0; i < 25; i++ { // Far more lines than could be buffered
+				registers = append(registers, &rwp.Register{Reg: rwp.Register_MEM, Id: fmt.Sprintf("A%d", i), Value: uint32(i)})
+			}
+			_, rp := testpanel.StartAndConnect(t, &emulator.Config{Registers: registers}, &gorwp.ConnectConfig{ProtocolMode: mode})
+
+			stats, err := rp.GetRunTimeStats(context.Background()) // Several lines in ASCII
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stats.BootsCount != 1 {
+				t.Fatalf("unexpected run time stats %v", stats)
+			}
+			gotRegisters, err := rp.GetRegisters(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(gotRegisters) != len(registers) {
+				t.Fatalf("got %d registers, want %d", len(gotRegisters), len(registers))
+			}
+			if _, err := rp.GetSleepTimeout(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			connections, err := rp.GetConnections(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(connections.Connection) != 1 {
+				t.Fatalf("expected one connection, got %v", connections.Connection)
+			}
+
+			// The emulator has no network config:
+			requestCtx, cancelRequest := context.WithTimeout(context.Background(), 200*time.Millisecond)
+			defer cancelRequest()
+			if _, err := rp.GetNetworkConfig(requestCtx); !errors.Is(err, context.DeadlineExceeded) {
+				t.Fatalf("expected a timeout, got %v", err)
+			}
+
+			rp.Close()
+			if _, err := rp.GetRunTimeStats(context.Background()); !errors.Is(err, gorwp.ErrClosed) {
+				t.Fatalf("expected ErrClosed, got %v", err)
+			}
+		})
+	}
+}
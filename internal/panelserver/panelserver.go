@@ -0,0 +1,249 @@
+/*
+   Copyright 2026 SKAARHOJ ApS
+
+   Released under MIT License
+*/
+
+// Package panelserver implements the parts of the panel side of the Raw
+// Panel protocol which the emulator and the proxy share: Accepting
+// systems, detecting their encoding and reading and writing messages in
+// ASCII or binary.
+
+package panelserver
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	helpers "github.com/SKAARHOJ/rawpanel-lib"
+	rwp "github.com/SKAARHOJ/rawpanel-lib/ibeam_rawpanel"
+	log "github.com/s00500/env_logger"
+)
+
+// Returned by Queue when the send queue of a system is full
+var ErrQueueFull = errors.New("send queue full")
+
+// Type Listeners accepts systems on any number of listeners
+type Listeners struct {
+	mu        sync.Mutex
+	listeners []net.Listener
+}
+
+// Starts listening for systems on a network ("tcp" or "unix") and address,
+// with TLS if tlsConfig is set. serve is called for every accepted
+// connection and must return immediately. The accept loop is counted in
+// wg and stops when the listener is closed. Returns the address actually
+// listened on, which is useful with port 0.
+func (l *Listeners) Listen(ctx context.Context, wg *sync.WaitGroup, network string, address string, tlsConfig *tls.Config, serve func(net.Conn)) (net.Addr, error) {
+	listener, err := net.Listen(network, address)
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig != nil {
+		listener = tls.NewListener(listener, tlsConfig)
+	}
+
+	l.mu.Lock()
+	l.listeners = append(l.listeners, listener)
+	l.mu.Unlock()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Should(err)
+				}
+				return
+			}
+			serve(conn)
+		}
+	}()
+
+	return listener.Addr(), nil
+}
+
+// Closes all listeners
+func (l *Listeners) Close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, listener := range l.listeners {
+		listener.Close()
+	}
+	l.listeners = nil
+}
+
+// Type Conn is a connection from a system, which is written in the
+// encoding the system uses
+type Conn struct {
+	net.Conn
+	reader       *bufio.Reader
+	frameWriter  *helpers.FrameWriter
+	maxFrameSize int
+
+	writeMu sync.Mutex
+	binary  bool
+
+	queue        chan []*rwp.OutboundMessage // Set by StartQueue
+	writeTimeout time.Duration
+}
+
+// Wraps a connection from a system. maxFrameSize limits binary frames
+// from the system, 0 is helpers.DefaultMaxFrameSize.
+func NewConn(conn net.Conn, maxFrameSize int) *Conn {
+	return &Conn{
+		Conn:         conn,
+		reader:       bufio.NewReader(conn),
+		frameWriter:  helpers.NewFrameWriter(conn, 0),
+		maxFrameSize: maxFrameSize,
+	}
+}
+
+// Waits for the first bytes from the system and detects its encoding
+func (c *Conn) DetectEncoding() error {
+	isBinary, err := helpers.DetectIfSystemEncodingIsBinary(c.reader)
+	if err != nil {
+		return err
+	}
+	c.SetBinary(isBinary)
+	return nil
+}
+
+// Sets the encoding, for servers which don't detect it
+func (c *Conn) SetBinary(binary bool) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.binary = binary
+}
+
+// Returns whether the system is talking binary
+func (c *Conn) Binary() bool {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.binary
+}
+
+// Reads messages from the system and passes them to handle until it
+// disconnects. Multi-line ASCII graphics are assembled before. If
+// allowSwitch is set, a system can switch from ASCII to binary, which
+// happens when it negotiated in ASCII. Returns nil when the system closed
+// the connection.
+func (c *Conn) ReadMessages(allowSwitch bool, handle func([]*rwp.InboundMessage)) error {
+	if !c.Binary() && !c.readASCII(allowSwitch, handle) {
+		return nil
+	}
+	return c.readBinary(handle)
+}
+
+// Returns true if the system switched to binary
+func (c *Conn) readASCII(allowSwitch bool, handle func([]*rwp.InboundMessage)) bool {
+	asciiReader := &helpers.ASCIIreader{}
+	for {
+		if allowSwitch {
+			isBinary, err := helpers.DetectIfSystemEncodingIsBinary(c.reader)
+			if err != nil {
+				return false
+			}
+			if isBinary {
+				log.Debugln("System", c.RemoteAddr().String(), "switched to binary")
+				c.SetBinary(true)
+				return true
+			}
+		}
+		line, err := c.reader.ReadString('\n')
+		if err != nil {
+			return false
+		}
+		if msgs := asciiReader.Parse(strings.TrimSpace(line)); len(msgs) > 0 {
+			handle(msgs)
+		}
+	}
+}
+
+func (c *Conn) readBinary(handle func([]*rwp.InboundMessage)) error {
+	frameReader := helpers.NewFrameReader(c.reader, c.maxFrameSize)
+	for {
+		msg := &rwp.InboundMessage{}
+		err := frameReader.ReadMessage(msg)
+		if helpers.IsRecoverableFrameError(err) {
+			log.Warnln("Skipping frame from", c.RemoteAddr().String()+":", err)
+			continue
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		handle([]*rwp.InboundMessage{msg})
+	}
+}
+
+// Sends messages to the system in its encoding, waiting for the write
+func (c *Conn) Send(msgs []*rwp.OutboundMessage) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	if c.writeTimeout > 0 {
+		c.Conn.SetWriteDeadline(time.Now().Add(c.writeTimeout))
+	}
+	if c.binary {
+		for _, msg := range msgs {
+			if err := c.frameWriter.WriteMessage(msg); err != nil {
+				return err
+			}
+		}
+	} else {
+		for _, line := range helpers.OutboundMessagesToRawPanelASCIIstrings(msgs) {
+			if _, err := c.Conn.Write([]byte(line + "\n")); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Starts a goroutine writing what is queued with Queue, so one slow system
+// doesn't hold up the others. A write taking longer than writeTimeout
+// closes the connection. The goroutine ends when ctx is done or a write
+// failed.
+func (c *Conn) StartQueue(ctx context.Context, size int, writeTimeout time.Duration) {
+	c.writeMu.Lock()
+	c.writeTimeout = writeTimeout
+	c.writeMu.Unlock()
+	c.queue = make(chan []*rwp.OutboundMessage, size)
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msgs := <-c.queue:
+				if err := c.Send(msgs); err != nil {
+					log.Debugln("Could not send to", c.RemoteAddr().String()+", disconnecting:", err)
+					c.Close()
+					return
+				}
+			}
+		}
+	}()
+}
+
+// Queues messages for the goroutine started by StartQueue. Returns
+// ErrQueueFull without waiting if the system doesn't keep up.
+func (c *Conn) Queue(msgs []*rwp.OutboundMessage) error {
+	select {
+	case c.queue <- msgs:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
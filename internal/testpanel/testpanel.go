@@ -0,0 +1,207 @@
+/*
+   Copyright 2026 SKAARHOJ ApS
+
+   Released under MIT License
+*/
+
+// Package testpanel holds the fixtures shared by the tests of the
+// packages connecting to panels: An emulated panel with a small topology,
+// connected through ConnectToPanel or gorwp, and certificates for TLS.
+// Everything is cleaned up when the test ends.
+
+package testpanel
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	helpers "github.com/SKAARHOJ/rawpanel-lib"
+	"github.com/SKAARHOJ/rawpanel-lib/emulator"
+	gorwp "github.com/SKAARHOJ/rawpanel-lib/gorwp"
+	rwp "github.com/SKAARHOJ/rawpanel-lib/ibeam_rawpanel"
+	topology "github.com/SKAARHOJ/rawpanel-lib/topology"
+)
+
+// Returns a topology with a button (HWC 1), an encoder (HWC 2) and a fader (HWC 3)
+func Topology() *topology.Topology {
+	return &topology.Topology{
+		HWc: []topology.TopologyHWcomponent{
+			{Id: 1, X: 100, Y: 100, Txt: "Button", Type: 1},
+			{Id: 2, X: 300, Y: 100, Txt: "Encoder", Type: 2},
+			{Id: 3, X: 500, Y: 100, Txt: "Fader", Type: 3},
+		},
+		TypeIndex: map[uint32]topology.TopologyHWcTypeDef{
+			1: {W: 100, H: 100, Out: "rgb", In: "b"},
+			2: {W: 100, Out: "rgb", In: "pb"},
+			3: {W: 50, H: 300, In: "av"},
+		},
+	}
+}
+
+// Waits up to 5 seconds for cond to become true
+func WaitFor(t testing.TB, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Starts an emulator with Topology listening on a free local port. Config is optional.
+func Start(t testing.TB, config *emulator.Config) (*emulator.Emulator, string) {
+	t.Helper()
+	emu := emulator.New(Topology(), config)
+	t.Cleanup(emu.Close)
+	addr, err := emu.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return emu, addr.String()
+}
+
+// Connects gorwp to the panel at address. Config is optional.
+func Connect(t testing.TB, address string, config *gorwp.ConnectConfig) *gorwp.RawPanel {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	rp, err := gorwp.ConnectWithConfig(address, ctx, cancel, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rp
+}
+
+// Starts an emulator and connects gorwp to it. Both configs are optional.
+func StartAndConnect(t testing.TB, emuConfig *emulator.Config, config *gorwp.ConnectConfig) (*emulator.Emulator, *gorwp.RawPanel) {
+	t.Helper()
+	emu, address := Start(t, emuConfig)
+	return emu, Connect(t, address, config)
+}
+
+// Type Session is a running ConnectToPanel
+type Session struct {
+	ToPanel   chan []*rwp.InboundMessage
+	FromPanel chan []*rwp.OutboundMessage
+	Connected chan bool // Receives the encoding (binary or not) on every connect
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// Runs ConnectToPanel for address until Stop is called or the test ends. Config is optional.
+func RunConnectToPanel(t testing.TB, address string, config *helpers.ConnectToPanelConfig) *Session {
+	s := &Session{
+		ToPanel:   make(chan []*rwp.InboundMessage, 10),
+		FromPanel: make(chan []*rwp.OutboundMessage, 10),
+		Connected: make(chan bool, 10),
+	}
+	var ctx context.Context
+	ctx, s.cancel = context.WithCancel(context.Background())
+	t.Cleanup(s.Stop)
+	go helpers.ConnectToPanel(address, s.ToPanel, s.FromPanel, ctx, &s.wg, func(errorMsg string, binary bool, _ net.Conn) { s.Connected <- binary }, nil, config)
+	return s
+}
+
+// Waits for the connection and returns whether it is binary
+func (s *Session) WaitConnected(t testing.TB) bool {
+	t.Helper()
+	select {
+	case binary := <-s.Connected:
+		return binary
+	case <-time.After(5 * time.Second):
+		t.Fatal("not connected")
+	}
+	return false
+}
+
+// Stops ConnectToPanel and waits for it
+func (s *Session) Stop() {
+	s.cancel()
+	s.wg.Wait()
+}
+
+// Type PKI is a self-signed CA issuing certificates for 127.0.0.1
+type PKI struct {
+	Pool   *x509.CertPool
+	caCert *x509.Certificate
+	caKey  *ecdsa.PrivateKey
+}
+
+func NewPKI(t testing.TB) *PKI {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &PKI{Pool: pool, caCert: cert, caKey: key}
+}
+
+// Issues a certificate for server or client authentication
+func (pki *PKI) Certificate(t testing.TB, serial int64, usage x509.ExtKeyUsage) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, pki.caCert, &key.PublicKey, pki.caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// Returns the server and client TLS configs for mutual TLS
+func (pki *PKI) MutualTLS(t testing.TB) (server *tls.Config, client *tls.Config) {
+	t.Helper()
+	server = &tls.Config{
+		Certificates: []tls.Certificate{pki.Certificate(t, 2, x509.ExtKeyUsageServerAuth)},
+		ClientCAs:    pki.Pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}
+	client = &tls.Config{
+		Certificates: []tls.Certificate{pki.Certificate(t, 3, x509.ExtKeyUsageClientAuth)},
+		RootCAs:      pki.Pool,
+	}
+	return server, client
+}
@@ -0,0 +1,166 @@
+package rawpanellib
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	rwp "github.com/SKAARHOJ/rawpanel-lib/ibeam_rawpanel"
+	log "github.com/s00500/env_logger"
+)
+
+// Returned by PanelSession.Send when the session has ended
+var ErrSessionEnded = errors.New("panel session ended")
+
+type ListenForPanelsConfig struct {
+	NetworkAlternative string        // Alternative network to listen on, e.g. "unix" (default is "tcp")
+	DetectionTimeout   time.Duration // Max time to wait for the reply when detecting binary or ASCII mode, default DefaultDetectionTimeout
+	MaxFrameSize       int           // Max payload size of binary frames, default DefaultMaxFrameSize
+}
+
+// A panel which has connected to us (panel in client mode)
+// Use ToPanel and FromPanel exactly like the channels supplied to ConnectToPanel. FromPanel is closed when the session ends.
+// Nothing reads ToPanel after the session has ended, so use Send or select on Done as well when sending to it.
+type PanelSession struct {
+	ToPanel    chan<- []*rwp.InboundMessage  // Send messages to the panel here
+	FromPanel  <-chan []*rwp.OutboundMessage // Messages from the panel are received here. Must be read continuously.
+	Binary     bool                          // True if the panel was detected to use the binary protocol
+	RemoteAddr string                        // Address of the panel
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// Closes the connection to the panel
+func (ps *PanelSession) Close() {
+	ps.cancel()
+}
+
+// Returns a channel which is closed when the session has ended
+func (ps *PanelSession) Done() <-chan struct{} {
+	return ps.ctx.Done()
+}
+
+// Sends messages to the panel. Returns ErrSessionEnded instead of blocking once the session has ended
+func (ps *PanelSession) Send(msgs []*rwp.InboundMessage) error {
+	if ps.ctx.Err() != nil {
+		return ErrSessionEnded
+	}
+	select {
+	case ps.ToPanel <- msgs:
+		return nil
+	case <-ps.ctx.Done():
+		return ErrSessionEnded
+	}
+}
+
+// Listens for raw panel compliant devices connecting to us on addr (typically ":9923"). This is the counterpart to ConnectToPanel for panels running in client mode.
+// It blocks until the context is done, so start it as a goroutine (go ListenForPanels). An error is returned if listening fails.
+// For every panel that connects, the encoding is auto detected in the same way as AutoDetectIfPanelEncodingIsBinary does, after which onpanel is called in its own goroutine with a session for that panel.
+// The Waitgroup helps you to know when everything is shut down internally
+// Config is optional additional configuration options
+func ListenForPanels(addr string, ctx context.Context, wg *sync.WaitGroup, onpanel func(*PanelSession), config *ListenForPanelsConfig) error {
+
+	// Workgroup setup:
+	if wg != nil {
+		wg.Add(1)
+		defer wg.Done()
+	}
+
+	network := "tcp"
+	if config != nil && config.NetworkAlternative != "" {
+		network = config.NetworkAlternative
+	}
+	sessionConfig := ListenForPanelsConfig{}
+	if config != nil {
+		sessionConfig = *config
+	}
+
+	listener, err := net.Listen(network, addr)
+	if err != nil {
+		return err
+	}
+	log.Debugln("Listening for panels on " + network + " " + listener.Addr().String())
+
+	// Stop accepting when context is done:
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+
+	var acceptDelay time.Duration // Backs off on accept errors (e.g. out of file descriptors) like net/http does
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				log.Debugln("Stop listening for panels on " + addr)
+				return nil
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return err
+			}
+			if acceptDelay == 0 {
+				acceptDelay = 5 * time.Millisecond
+			} else if acceptDelay *= 2; acceptDelay > time.Second {
+				acceptDelay = time.Second
+			}
+			log.Errorf("Accepting panel connection failed, retrying in %s: %v\n", acceptDelay, err)
+			select {
+			case <-time.After(acceptDelay):
+			case <-ctx.Done():
+			}
+			continue
+		}
+		acceptDelay = 0
+		if wg != nil {
+			wg.Add(1) // Before ListenForPanels can return and call wg.Done
+		}
+		go handlePanelSession(conn, ctx, wg, onpanel, sessionConfig)
+	}
+}
+
+// Detects encoding and runs a single inbound panel connection until it ends. The caller has added it to wg
+func handlePanelSession(conn net.Conn, ctx context.Context, wg *sync.WaitGroup, onpanel func(*PanelSession), config ListenForPanelsConfig) {
+	if wg != nil {
+		defer wg.Done()
+	}
+
+	remoteAddr := conn.RemoteAddr().String()
+	log.Debugln("Panel connected from " + remoteAddr)
+
+	binaryPanel, err := AutoDetectIfPanelEncodingIsBinaryContext(ctx, conn, remoteAddr, config.DetectionTimeout)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	conn.SetReadDeadline(time.Time{}) // Reset - necessary for ASCII line reading.
+
+	toPanel := make(chan []*rwp.InboundMessage, 10)
+	fromPanel := make(chan []*rwp.OutboundMessage, 10)
+	sessionCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	session := &PanelSession{
+		ToPanel:    toPanel,
+		FromPanel:  fromPanel,
+		Binary:     binaryPanel,
+		RemoteAddr: remoteAddr,
+		ctx:        sessionCtx,
+		cancel:     cancel,
+	}
+
+	servePanelConnection(conn, binaryPanel, remoteAddr, toPanel, fromPanel, sessionCtx, wg, panelConnectionOptions{
+		onconnected: func() {
+			if onpanel != nil {
+				go onpanel(session)
+			}
+		},
+		maxFrameSize: config.MaxFrameSize,
+	})
+
+	log.Debugln("Panel session ended for " + remoteAddr)
+	cancel()
+	close(fromPanel)
+}
@@ -0,0 +1,122 @@
+package rawpanellib
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	rwp "github.com/SKAARHOJ/rawpanel-lib/ibeam_rawpanel"
+	"google.golang.org/protobuf/proto"
+)
+
+func writeTestFrame(t *testing.T, conn net.Conn, msg proto.Message) {
+	pbdata, err := proto.Marshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := make([]byte, 4)
+	binary.LittleEndian.PutUint32(header, uint32(len(pbdata)))
+	if _, err := conn.Write(append(header, pbdata...)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readTestFrame(t *testing.T, conn net.Conn, msg proto.Message) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		t.Fatal(err)
+	}
+	payload := make([]byte, binary.LittleEndian.Uint32(header))
+	if _, err := io.ReadFull(conn, payload); err != nil {
+		t.Fatal(err)
+	}
+	if err := proto.Unmarshal(payload, msg); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestListenForPanelsBinary(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	sessions := make(chan *PanelSession, 1)
+	go ListenForPanels(addr, ctx, &wg, func(ps *PanelSession) { sessions <- ps }, nil)
+
+	// Act as a binary panel in client mode:
+	var conn net.Conn
+	for i := 0; i < 50; i++ {
+		if conn, err = net.Dial("tcp", addr); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	ping := &rwp.InboundMessage{}
+	readTestFrame(t, conn, ping)
+	if ping.FlowMessage != rwp.InboundMessage_PING {
+		t.Fatalf("expected ping probe, got %v", ping)
+	}
+	writeTestFrame(t, conn, &rwp.OutboundMessage{FlowMessage: rwp.OutboundMessage_ACK})
+
+	var session *PanelSession
+	select {
+	case session = <-sessions:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no session")
+	}
+	if !session.Binary {
+		t.Fatal("expected binary session")
+	}
+
+	// Panel -> system:
+	writeTestFrame(t, conn, &rwp.OutboundMessage{Events: []*rwp.HWCEvent{{HWCID: 7, Binary: &rwp.BinaryEvent{Pressed: true}}}})
+	select {
+	case msgs := <-session.FromPanel:
+		if len(msgs) != 1 || len(msgs[0].Events) != 1 || msgs[0].Events[0].HWCID != 7 {
+			t.Fatalf("unexpected message from panel: %v", msgs)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event received")
+	}
+
+	// System -> panel:
+	if err := session.Send([]*rwp.InboundMessage{{Command: &rwp.Command{SendPanelInfo: true}}}); err != nil {
+		t.Fatal(err)
+	}
+	cmd := &rwp.InboundMessage{}
+	readTestFrame(t, conn, cmd)
+	if cmd.Command == nil || !cmd.Command.SendPanelInfo {
+		t.Fatalf("unexpected message to panel: %v", cmd)
+	}
+
+	// Closing the panel side ends the session:
+	conn.Close()
+	select {
+	case <-session.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("session did not end")
+	}
+	for i := 0; i < 20; i++ { // More than ToPanel buffers
+		if err := session.Send([]*rwp.InboundMessage{{Command: &rwp.Command{SendPanelInfo: true}}}); err != ErrSessionEnded {
+			t.Fatalf("expected ErrSessionEnded, got %v", err)
+		}
+	}
+
+	cancel()
+	wg.Wait()
+}
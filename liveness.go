@@ -0,0 +1,151 @@
+package rawpanellib
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	rwp "github.com/SKAARHOJ/rawpanel-lib/ibeam_rawpanel"
+)
+
+// Returned as the cause of a disconnect when the panel stopped answering pings
+var ErrPanelNotResponding = errors.New("panel did not acknowledge pings")
+
+type HeartbeatConfig struct {
+	Interval                time.Duration // Time between pings to the panel, default 1 second
+	MaxMissed               int           // Declare the link dead after this many pings in a row without ACK. 0 only tracks round trip times
+	NegotiateHeartBeatTimer bool          // Send Interval to the panel as HeartBeatTimer on connect and use the value the panel reports back as ping interval
+}
+
+// Round trip statistics for PING -> ACK
+type RTTStats struct {
+	Count       uint64        // Number of ACKs matched to a ping
+	Missed      uint64        // Total number of pings which didn't get an ACK before the next ping
+	Last        time.Duration // Most recent round trip time
+	Min         time.Duration
+	Max         time.Duration
+	Avg         time.Duration
+	LastAckTime time.Time // When the last ACK was received
+}
+
+// Supervises liveness of a panel connection by pinging it and matching the ACKs coming back.
+// Create it with NewLivenessMonitor and put it in the client config to read RTT statistics while connected. Statistics are kept across reconnects.
+type LivenessMonitor struct {
+	sync.Mutex
+
+	config     HeartbeatConfig
+	interval   time.Duration
+	pingSentAt time.Time // Zero if no ping is outstanding
+	missed     int       // Pings in a row without ACK
+	totalRTT   time.Duration
+	stats      RTTStats
+	onRTT      func(time.Duration)
+}
+
+func NewLivenessMonitor(config *HeartbeatConfig) *LivenessMonitor {
+	lm := &LivenessMonitor{}
+	if config != nil {
+		lm.config = *config
+	}
+	if lm.config.Interval <= 0 {
+		lm.config.Interval = time.Second
+	}
+	lm.interval = lm.config.Interval
+	return lm
+}
+
+// Prepares for a new connection. Returns messages to send to the panel right away (HeartBeatTimer negotiation), if any
+func (lm *LivenessMonitor) Start() []*rwp.InboundMessage {
+	lm.Lock()
+	defer lm.Unlock()
+
+	lm.pingSentAt = time.Time{}
+	lm.missed = 0
+	lm.interval = lm.config.Interval
+
+	if lm.config.NegotiateHeartBeatTimer {
+		return []*rwp.InboundMessage{{
+			Command: &rwp.Command{
+				SetHeartBeatTimer: &rwp.HeartBeatTimer{
+					Value: uint32(lm.config.Interval.Milliseconds()),
+				},
+			},
+		}}
+	}
+	return nil
+}
+
+// Current ping interval
+func (lm *LivenessMonitor) Interval() time.Duration {
+	lm.Lock()
+	defer lm.Unlock()
+	return lm.interval
+}
+
+// Call this every Interval. It returns the ping to send, or ErrPanelNotResponding if too many pings went unanswered
+func (lm *LivenessMonitor) Tick() (*rwp.InboundMessage, error) {
+	lm.Lock()
+	defer lm.Unlock()
+
+	if !lm.pingSentAt.IsZero() {
+		lm.missed++
+		lm.stats.Missed++
+		if lm.config.MaxMissed > 0 && lm.missed >= lm.config.MaxMissed {
+			return nil, ErrPanelNotResponding
+		}
+	}
+	lm.pingSentAt = time.Now()
+
+	return &rwp.InboundMessage{FlowMessage: rwp.InboundMessage_PING}, nil
+}
+
+// Inspects a message from the panel. Returns true if it was an ACK
+func (lm *LivenessMonitor) Received(msg *rwp.OutboundMessage) bool {
+	lm.Lock()
+	defer lm.Unlock()
+
+	if msg.HeartBeatTimer != nil && msg.HeartBeatTimer.Value > 0 && lm.config.NegotiateHeartBeatTimer {
+		lm.interval = time.Duration(msg.HeartBeatTimer.Value) * time.Millisecond
+	}
+
+	if msg.FlowMessage != rwp.OutboundMessage_ACK {
+		return false
+	}
+
+	now := time.Now()
+	lm.missed = 0
+	lm.stats.LastAckTime = now
+	if !lm.pingSentAt.IsZero() {
+		rtt := now.Sub(lm.pingSentAt)
+		lm.pingSentAt = time.Time{}
+
+		lm.stats.Count++
+		lm.stats.Last = rtt
+		if lm.stats.Min == 0 || rtt < lm.stats.Min {
+			lm.stats.Min = rtt
+		}
+		if rtt > lm.stats.Max {
+			lm.stats.Max = rtt
+		}
+		lm.totalRTT += rtt
+		lm.stats.Avg = lm.totalRTT / time.Duration(lm.stats.Count)
+		if lm.onRTT != nil {
+			lm.onRTT(rtt)
+		}
+	}
+	return true
+}
+
+// Sets a function which is called with every measured round trip time, e.g. ConnectionMetrics.ObserveRTT
+func (lm *LivenessMonitor) OnRTT(f func(rtt time.Duration)) {
+	lm.Lock()
+	defer lm.Unlock()
+	lm.onRTT = f
+}
+
+// Returns a copy of the round trip statistics
+func (lm *LivenessMonitor) Stats() RTTStats {
+	lm.Lock()
+	defer lm.Unlock()
+	return lm.stats
+}
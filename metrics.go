@@ -0,0 +1,355 @@
+package rawpanellib
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	rwp "github.com/SKAARHOJ/rawpanel-lib/ibeam_rawpanel"
+)
+
+// Kind of message content counted by ConnectionMetrics
+type MessageKind int
+
+const (
+	MessageKindStates   MessageKind = iota // HWC states without graphics (to panel)
+	MessageKindGfx                         // HWC states with graphics (to panel)
+	MessageKindCommands                    // Commands (to panel)
+	MessageKindEvents                      // HWC events (from panel)
+	MessageKindInfo                        // Panel info, topology and other replies (from panel)
+	MessageKindFlow                        // Pings, ACKs and other flow messages
+	numMessageKinds
+)
+
+func (mk MessageKind) String() string {
+	switch mk {
+	case MessageKindStates:
+		return "states"
+	case MessageKindGfx:
+		return "gfx"
+	case MessageKindCommands:
+		return "commands"
+	case MessageKindEvents:
+		return "events"
+	case MessageKindInfo:
+		return "info"
+	}
+	return "flow"
+}
+
+// Upper bounds of the RTT histogram buckets
+var rttBuckets = []time.Duration{
+	time.Millisecond, 2 * time.Millisecond, 5 * time.Millisecond, 10 * time.Millisecond, 20 * time.Millisecond, 50 * time.Millisecond,
+	100 * time.Millisecond, 200 * time.Millisecond, 500 * time.Millisecond, time.Second, 2 * time.Second,
+}
+
+// Counters for a panel connection. Put it in the config of ConnectToPanel or gorwp and read it with Snapshot, expvarmetrics.Publish or PrometheusHandler.
+// Metrics are kept across reconnects. The methods recording metrics can be called on a nil *ConnectionMetrics, which does nothing.
+type ConnectionMetrics struct {
+	sync.Mutex
+
+	name              string
+	messagesToPanel   [numMessageKinds]uint64
+	bytesToPanel      [numMessageKinds]uint64
+	messagesFromPanel [numMessageKinds]uint64
+	bytesFromPanel    [numMessageKinds]uint64
+	wireBytesIn       uint64
+	wireBytesOut      uint64
+	connects          uint64
+	disconnects       uint64
+	detectedBinary    uint64
+	detectedASCII     uint64
+	connectedSince    time.Time // Zero if not connected
+	totalConnected    time.Duration
+	rttCounts         []uint64 // Per bucket, last one is +Inf
+	rttCount          uint64
+	rttSum            time.Duration
+}
+
+// Creates metrics for a panel. Name is used as label, typically the panel address or ID
+func NewConnectionMetrics(name string) *ConnectionMetrics {
+	return &ConnectionMetrics{
+		name:      name,
+		rttCounts: make([]uint64, len(rttBuckets)+1),
+	}
+}
+
+// Counts messages written to the panel. Bytes per kind are the protobuf encoded size, also for ASCII panels
+func (m *ConnectionMetrics) CountToPanel(msgs []*rwp.InboundMessage) {
+	if m == nil {
+		return
+	}
+	m.Lock()
+	defer m.Unlock()
+	for _, msg := range msgs {
+		if msg.FlowMessage != rwp.InboundMessage_NONE {
+			m.messagesToPanel[MessageKindFlow]++
+			m.bytesToPanel[MessageKindFlow] += uint64(proto.Size(&rwp.InboundMessage{FlowMessage: msg.FlowMessage}))
+		}
+		if msg.Command != nil {
+			m.messagesToPanel[MessageKindCommands]++
+			m.bytesToPanel[MessageKindCommands] += uint64(proto.Size(msg.Command))
+		}
+		for _, state := range msg.States {
+			kind := MessageKindStates
+			if state.HWCGfx != nil {
+				kind = MessageKindGfx
+			}
+			m.messagesToPanel[kind]++
+			m.bytesToPanel[kind] += uint64(proto.Size(state))
+		}
+	}
+}
+
+// Counts messages received from the panel
+func (m *ConnectionMetrics) CountFromPanel(msgs []*rwp.OutboundMessage) {
+	if m == nil {
+		return
+	}
+	m.Lock()
+	defer m.Unlock()
+	for _, msg := range msgs {
+		size := uint64(proto.Size(msg))
+		switch {
+		case len(msg.Events) > 0:
+			m.messagesFromPanel[MessageKindEvents] += uint64(len(msg.Events))
+			m.bytesFromPanel[MessageKindEvents] += size
+		case msg.FlowMessage != rwp.OutboundMessage_NONE:
+			m.messagesFromPanel[MessageKindFlow]++
+			m.bytesFromPanel[MessageKindFlow] += size
+		default:
+			m.messagesFromPanel[MessageKindInfo]++
+			m.bytesFromPanel[MessageKindInfo] += size
+		}
+	}
+}
+
+// Records the outcome of protocol detection
+func (m *ConnectionMetrics) Detected(binaryPanel bool) {
+	if m == nil {
+		return
+	}
+	m.Lock()
+	defer m.Unlock()
+	if binaryPanel {
+		m.detectedBinary++
+	} else {
+		m.detectedASCII++
+	}
+}
+
+// Records that a connection was established
+func (m *ConnectionMetrics) ConnectionUp() {
+	if m == nil {
+		return
+	}
+	m.Lock()
+	defer m.Unlock()
+	m.connects++
+	m.connectedSince = time.Now()
+}
+
+// Records that a connection was lost or closed
+func (m *ConnectionMetrics) ConnectionDown() {
+	if m == nil {
+		return
+	}
+	m.Lock()
+	defer m.Unlock()
+	if !m.connectedSince.IsZero() {
+		m.disconnects++
+		m.totalConnected += time.Since(m.connectedSince)
+		m.connectedSince = time.Time{}
+	}
+}
+
+// Records a ping round trip time
+func (m *ConnectionMetrics) ObserveRTT(rtt time.Duration) {
+	if m == nil {
+		return
+	}
+	m.Lock()
+	defer m.Unlock()
+	bucket := sort.Search(len(rttBuckets), func(i int) bool { return rtt <= rttBuckets[i] })
+	m.rttCounts[bucket]++
+	m.rttCount++
+	m.rttSum += rtt
+}
+
+// Wraps a connection so bytes on the wire are counted
+func (m *ConnectionMetrics) Conn(c net.Conn) net.Conn {
+	if m == nil {
+		return c
+	}
+	return &metricsConn{Conn: c, metrics: m}
+}
+
+type metricsConn struct {
+	net.Conn
+	metrics *ConnectionMetrics
+}
+
+func (mc *metricsConn) Read(b []byte) (int, error) {
+	n, err := mc.Conn.Read(b)
+	mc.metrics.Lock()
+	mc.metrics.wireBytesIn += uint64(n)
+	mc.metrics.Unlock()
+	return n, err
+}
+
+func (mc *metricsConn) Write(b []byte) (int, error) {
+	n, err := mc.Conn.Write(b)
+	mc.metrics.Lock()
+	mc.metrics.wireBytesOut += uint64(n)
+	mc.metrics.Unlock()
+	return n, err
+}
+
+// Point in time copy of ConnectionMetrics. Maps are keyed by MessageKind names
+type MetricsSnapshot struct {
+	Name              string
+	MessagesToPanel   map[string]uint64
+	BytesToPanel      map[string]uint64
+	MessagesFromPanel map[string]uint64
+	BytesFromPanel    map[string]uint64
+	WireBytesIn       uint64
+	WireBytesOut      uint64
+	Connects          uint64
+	Disconnects       uint64
+	DetectedBinary    uint64
+	DetectedASCII     uint64
+	Connected         bool
+	ConnectedFor      time.Duration // Duration of the current connection
+	TotalConnected    time.Duration // Including the current connection
+	RTT               RTTHistogram
+}
+
+// Cumulative histogram of ping round trip times
+type RTTHistogram struct {
+	Buckets []time.Duration // Upper bounds
+	Counts  []uint64        // Observations less than or equal to the bucket bound
+	Count   uint64
+	Sum     time.Duration
+}
+
+// Returns a copy of the current values
+func (m *ConnectionMetrics) Snapshot() MetricsSnapshot {
+	m.Lock()
+	defer m.Unlock()
+
+	s := MetricsSnapshot{
+		Name:              m.name,
+		MessagesToPanel:   map[string]uint64{},
+		BytesToPanel:      map[string]uint64{},
+		MessagesFromPanel: map[string]uint64{},
+		BytesFromPanel:    map[string]uint64{},
+		WireBytesIn:       m.wireBytesIn,
+		WireBytesOut:      m.wireBytesOut,
+		Connects:          m.connects,
+		Disconnects:       m.disconnects,
+		DetectedBinary:    m.detectedBinary,
+		DetectedASCII:     m.detectedASCII,
+		Connected:         !m.connectedSince.IsZero(),
+		TotalConnected:    m.totalConnected,
+	}
+	for kind := MessageKind(0); kind < numMessageKinds; kind++ {
+		s.MessagesToPanel[kind.String()] = m.messagesToPanel[kind]
+		s.BytesToPanel[kind.String()] = m.bytesToPanel[kind]
+		s.MessagesFromPanel[kind.String()] = m.messagesFromPanel[kind]
+		s.BytesFromPanel[kind.String()] = m.bytesFromPanel[kind]
+	}
+	if s.Connected {
+		s.ConnectedFor = time.Since(m.connectedSince)
+		s.TotalConnected += s.ConnectedFor
+	}
+
+	s.RTT = RTTHistogram{
+		Buckets: append([]time.Duration{}, rttBuckets...),
+		Counts:  make([]uint64, len(rttBuckets)),
+		Count:   m.rttCount,
+		Sum:     m.rttSum,
+	}
+	cumulative := uint64(0)
+	for i := range rttBuckets {
+		cumulative += m.rttCounts[i]
+		s.RTT.Counts[i] = cumulative
+	}
+	return s
+}
+
+// Returns an http.Handler serving the metrics returned by source in the Prometheus text format.
+// Source is called for every scrape, so panels can come and go.
+func PrometheusHandler(source func() []*ConnectionMetrics) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		WritePrometheus(w, source())
+	})
+}
+
+// Writes metrics in the Prometheus text format
+func WritePrometheus(w io.Writer, metrics []*ConnectionMetrics) {
+	snapshots := make([]MetricsSnapshot, 0, len(metrics))
+	for _, m := range metrics {
+		if m != nil {
+			snapshots = append(snapshots, m.Snapshot())
+		}
+	}
+
+	family := func(name string, typ string, help string, write func(s MetricsSnapshot, labels string)) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+		for _, s := range snapshots {
+			write(s, `panel="`+escapeLabel(s.Name)+`"`)
+		}
+	}
+	perKind := func(name string, help string, values func(s MetricsSnapshot) map[string]uint64) {
+		family(name, "counter", help, func(s MetricsSnapshot, labels string) {
+			for kind := MessageKind(0); kind < numMessageKinds; kind++ {
+				fmt.Fprintf(w, "%s{%s,kind=\"%s\"} %d\n", name, labels, kind, values(s)[kind.String()])
+			}
+		})
+	}
+	value := func(name string, typ string, help string, get func(s MetricsSnapshot) float64) {
+		family(name, typ, help, func(s MetricsSnapshot, labels string) {
+			fmt.Fprintf(w, "%s{%s} %g\n", name, labels, get(s))
+		})
+	}
+
+	perKind("rawpanel_messages_to_panel_total", "Messages written to the panel by kind.", func(s MetricsSnapshot) map[string]uint64 { return s.MessagesToPanel })
+	perKind("rawpanel_message_bytes_to_panel_total", "Protobuf encoded size of messages written to the panel by kind.", func(s MetricsSnapshot) map[string]uint64 { return s.BytesToPanel })
+	perKind("rawpanel_messages_from_panel_total", "Messages received from the panel by kind.", func(s MetricsSnapshot) map[string]uint64 { return s.MessagesFromPanel })
+	perKind("rawpanel_message_bytes_from_panel_total", "Protobuf encoded size of messages received from the panel by kind.", func(s MetricsSnapshot) map[string]uint64 { return s.BytesFromPanel })
+	value("rawpanel_wire_bytes_in_total", "counter", "Bytes read from the connection.", func(s MetricsSnapshot) float64 { return float64(s.WireBytesIn) })
+	value("rawpanel_wire_bytes_out_total", "counter", "Bytes written to the connection.", func(s MetricsSnapshot) float64 { return float64(s.WireBytesOut) })
+	value("rawpanel_connects_total", "counter", "Connections established.", func(s MetricsSnapshot) float64 { return float64(s.Connects) })
+	value("rawpanel_disconnects_total", "counter", "Connections lost or closed.", func(s MetricsSnapshot) float64 { return float64(s.Disconnects) })
+	family("rawpanel_detected_encoding_total", "counter", "Protocol detection outcomes.", func(s MetricsSnapshot, labels string) {
+		fmt.Fprintf(w, "rawpanel_detected_encoding_total{%s,encoding=\"binary\"} %d\n", labels, s.DetectedBinary)
+		fmt.Fprintf(w, "rawpanel_detected_encoding_total{%s,encoding=\"ascii\"} %d\n", labels, s.DetectedASCII)
+	})
+	value("rawpanel_connected", "gauge", "1 if the panel is connected.", func(s MetricsSnapshot) float64 {
+		if s.Connected {
+			return 1
+		}
+		return 0
+	})
+	value("rawpanel_connected_seconds_total", "counter", "Time connected.", func(s MetricsSnapshot) float64 { return s.TotalConnected.Seconds() })
+	family("rawpanel_ping_rtt_seconds", "histogram", "Ping round trip time.", func(s MetricsSnapshot, labels string) {
+		for i, bound := range s.RTT.Buckets {
+			fmt.Fprintf(w, "rawpanel_ping_rtt_seconds_bucket{%s,le=\"%g\"} %d\n", labels, bound.Seconds(), s.RTT.Counts[i])
+		}
+		fmt.Fprintf(w, "rawpanel_ping_rtt_seconds_bucket{%s,le=\"+Inf\"} %d\n", labels, s.RTT.Count)
+		fmt.Fprintf(w, "rawpanel_ping_rtt_seconds_sum{%s} %g\n", labels, s.RTT.Sum.Seconds())
+		fmt.Fprintf(w, "rawpanel_ping_rtt_seconds_count{%s} %d\n", labels, s.RTT.Count)
+	})
+}
+
+func escapeLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
@@ -0,0 +1,55 @@
+package rawpanellib_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	helpers "github.com/SKAARHOJ/rawpanel-lib"
+	rwp "github.com/SKAARHOJ/rawpanel-lib/ibeam_rawpanel"
+	"github.com/SKAARHOJ/rawpanel-lib/internal/testpanel"
+)
+
+func TestConnectionMetrics(t *testing.T) {
+	emu, addr := testpanel.Start(t, nil)
+	metrics := helpers.NewConnectionMetrics("emulator")
+	session := testpanel.RunConnectToPanel(t, addr, &helpers.ConnectToPanelConfig{Metrics: metrics})
+	go func() {
+		for range session.FromPanel {
+		}
+	}()
+	session.WaitConnected(t)
+
+	session.ToPanel <- []*rwp.InboundMessage{{States: []*rwp.HWCState{{HWCIDs: []uint32{1}, HWCMode: &rwp.HWCMode{State: rwp.HWCMode_ON}}}}}
+	testpanel.WaitFor(t, "state on the panel", func() bool { return emu.State(1) != nil })
+	emu.Press(1, rwp.BinaryEvent_UNKNOWN)
+	testpanel.WaitFor(t, "press counted", func() bool { return metrics.Snapshot().MessagesFromPanel["events"] > 0 })
+
+	s := metrics.Snapshot()
+	if !s.Connected || s.Connects != 1 || s.DetectedBinary+s.DetectedASCII != 1 {
+		t.Fatalf("unexpected connection counters: %+v", s)
+	}
+	if s.MessagesToPanel["states"] == 0 || s.BytesToPanel["states"] == 0 {
+		t.Fatalf("states not counted: %+v", s.MessagesToPanel)
+	}
+	if s.WireBytesIn == 0 || s.WireBytesOut == 0 {
+		t.Fatalf("wire bytes not counted: in %d, out %d", s.WireBytesIn, s.WireBytesOut)
+	}
+
+	var out bytes.Buffer
+	helpers.WritePrometheus(&out, []*helpers.ConnectionMetrics{metrics})
+	for _, want := range []string{
+		`rawpanel_messages_to_panel_total{panel="emulator",kind="states"}`,
+		`rawpanel_connected{panel="emulator"} 1`,
+		`rawpanel_ping_rtt_seconds_bucket{panel="emulator",le="+Inf"}`,
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("prometheus output is missing %s", want)
+		}
+	}
+
+	session.Stop()
+	if s := metrics.Snapshot(); s.Connected || s.Disconnects != 1 {
+		t.Fatalf("disconnect not counted: %+v", s)
+	}
+}
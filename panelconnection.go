@@ -0,0 +1,197 @@
+package rawpanellib
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	rwp "github.com/SKAARHOJ/rawpanel-lib/ibeam_rawpanel"
+	log "github.com/s00500/env_logger"
+
+	"go.uber.org/atomic"
+)
+
+// Optional hooks for servePanelConnection
+type panelConnectionOptions struct {
+	onconnected  func()             // Called once the writer is running and before reading starts
+	liveness     *LivenessMonitor   // Pings the panel and closes the connection if it stops answering. ACKs are not forwarded
+	scheduler    *SendScheduler     // Queues and coalesces messages to the panel instead of writing them right away
+	maxFrameSize int                // Max payload of binary frames, 0 is DefaultMaxFrameSize
+	metrics      *ConnectionMetrics // Counts messages in and out (optional)
+	recorder     *Recorder          // Records all messages in and out (optional)
+}
+
+// Runs the reader and writer of an established panel connection until the connection fails or the context is done.
+// Messages from msgsToPanel are sent to the panel in the given encoding and messages from the panel are decoded and forwarded to msgsFromPanel.
+// Returns true if the context was the reason for the exit and the error which ended the connection. The connection is closed when this function returns.
+func servePanelConnection(conn net.Conn, binaryPanel bool, panelIPAndPort string, msgsToPanel <-chan []*rwp.InboundMessage, msgsFromPanel chan<- []*rwp.OutboundMessage, ctx context.Context, wg *sync.WaitGroup, opts panelConnectionOptions) (bool, error) {
+
+	// Sends messages to the panel in the proper encoding (binary or ASCII)
+	frameWriter := NewFrameWriter(conn, opts.maxFrameSize)
+	send := func(incomingMessages []*rwp.InboundMessage) {
+		opts.metrics.CountToPanel(incomingMessages)
+		opts.recorder.RecordToPanel(incomingMessages)
+		if binaryPanel {
+			for _, msg := range incomingMessages {
+				log.Should(frameWriter.WriteMessage(msg))
+			}
+		} else {
+			lines := InboundMessagesToRawPanelASCIIstrings(incomingMessages)
+			for _, line := range lines {
+				conn.Write([]byte(line + "\n"))
+			}
+		}
+	}
+
+	// Heartbeat setup:
+	var heartbeat *time.Timer
+	var heartbeatC <-chan time.Time
+	var heartbeatErr atomic.Error
+	if opts.liveness != nil {
+		if negotiation := opts.liveness.Start(); negotiation != nil {
+			send(negotiation)
+		}
+		heartbeat = time.NewTimer(opts.liveness.Interval())
+		heartbeatC = heartbeat.C
+		defer heartbeat.Stop()
+	}
+
+	if opts.scheduler != nil {
+		opts.scheduler.Reset()
+	}
+
+	// This goroutine is reading the msgsToPanel channel and sending over the panel
+	var exit atomic.Bool
+	quit := make(chan bool)
+	go func() {
+		if wg != nil {
+			wg.Add(1)
+			defer wg.Done()
+		}
+		var flush FlushTimer
+		defer flush.Stop()
+		for {
+			// Backpressure: Stop reading msgsToPanel while the scheduler is full
+			in := msgsToPanel
+			if opts.scheduler != nil && opts.scheduler.Full() {
+				in = nil
+			}
+			select {
+			case <-ctx.Done(): // Context shutdown.
+				log.Debugln("Closing network connection because context was done, ", panelIPAndPort)
+				exit.Store(true)
+				conn.Close() // The implications of closing the connection should be that the listening code below will also fail and exit.
+				return
+			case <-quit:
+				return
+			case incomingMessages := <-in:
+				if opts.scheduler != nil {
+					opts.scheduler.Push(incomingMessages)
+				} else {
+					send(incomingMessages)
+				}
+			case <-flush.C(opts.scheduler):
+				send(opts.scheduler.Flush())
+			case <-heartbeatC:
+				ping, err := opts.liveness.Tick()
+				if err != nil {
+					log.Debugln("Closing network connection because panel stopped responding, ", panelIPAndPort)
+					heartbeatErr.Store(err)
+					conn.Close()
+					return
+				}
+				send([]*rwp.InboundMessage{ping})
+				heartbeat.Reset(opts.liveness.Interval())
+			}
+		}
+	}()
+
+	if opts.onconnected != nil {
+		opts.onconnected()
+	}
+
+	// Forwards to msgsFromPanel, but gives up if the context is done so a stopped reader outside this function cannot hang us.
+	// ACKs are swallowed if we are pinging ourselves.
+	forward := func(msgs []*rwp.OutboundMessage) bool {
+		opts.metrics.CountFromPanel(msgs)
+		opts.recorder.RecordFromPanel(msgs)
+		if opts.liveness != nil {
+			filtered := msgs[:0]
+			for _, msg := range msgs {
+				if !opts.liveness.Received(msg) {
+					filtered = append(filtered, msg)
+				}
+			}
+			if len(filtered) == 0 {
+				return true
+			}
+			msgs = filtered
+		}
+
+		select {
+		case msgsFromPanel <- msgs:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	var cause error
+
+	// Below, we will listen to messages from the panel, decode it and forward to the msgsFromPanel channel (which must be read externally)
+	if binaryPanel {
+		frameReader := NewFrameReader(conn, opts.maxFrameSize)
+		for {
+			outcomingMessage := &rwp.OutboundMessage{}
+			err := frameReader.ReadMessage(outcomingMessage)
+			if IsRecoverableFrameError(err) {
+				log.Warnln("Skipping frame from panel", panelIPAndPort, ":", err)
+				continue
+			}
+			if err != nil {
+				log.Debugln("Binary: ", err)
+				cause = err
+				break
+			}
+			if !forward([]*rwp.OutboundMessage{outcomingMessage}) {
+				cause = ctx.Err()
+				break
+			}
+		}
+	} else {
+		//log.Debugln("Reading ASCII lines...")
+		connectionReader := bufio.NewReader(conn) // Define OUTSIDE the for loop
+		for {
+			netData, err := connectionReader.ReadString('\n')
+			if err != nil {
+				cause = err
+				if err == io.EOF {
+					log.Debugln("Panel: " + conn.RemoteAddr().String() + " disconnected")
+					time.Sleep(time.Second)
+				} else {
+					log.Debugln(err)
+				}
+				break
+			} else {
+				if !forward(RawPanelASCIIstringsToOutboundMessages([]string{strings.TrimSpace(netData)})) {
+					cause = ctx.Err()
+					break
+				}
+			}
+		}
+	}
+
+	close(quit)
+	conn.Close()
+	if exit.Load() || ctx.Err() != nil {
+		return true, ctx.Err()
+	}
+	if err := heartbeatErr.Load(); err != nil {
+		return false, err
+	}
+	return false, cause
+}
@@ -0,0 +1,347 @@
+package rawpanellib
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	rwp "github.com/SKAARHOJ/rawpanel-lib/ibeam_rawpanel"
+)
+
+var (
+	ErrUnknownPanel   = errors.New("unknown panel")
+	ErrDuplicatePanel = errors.New("panel ID already in use")
+	ErrManagerClosed  = errors.New("panel manager is closed")
+	ErrPanelStopped   = errors.New("panel is stopped")
+
+	// Returned by Add when Defaults contains Metrics, Recorder, Liveness or SendScheduler. They are per
+	// connection and would be shared by all panels, create them in PerPanel instead
+	ErrSharedDefaults = errors.New("per-connection option set in Defaults")
+)
+
+// A panel handled by a PanelManager
+type ManagedPanel struct {
+	ID      string                // Unique name of the panel, used to tag messages and route sends
+	Address string                // IP:port, passed to ConnectToPanel
+	Groups  []string              // Groups the panel belongs to, for SendToGroup
+	Config  *ConnectToPanelConfig // Connection options for this panel. If nil, the manager's Defaults are used
+}
+
+type PanelManagerConfig struct {
+	Defaults   *ConnectToPanelConfig                                  // Connection options for panels without their own config (optional). Copied for each panel, so it must not contain Metrics, Recorder, Liveness or SendScheduler
+	PerPanel   func(panel ManagedPanel, config *ConnectToPanelConfig) // Called with the copy of Defaults for each panel without its own config, e.g. to create a LivenessMonitor per panel (optional)
+	BufferSize int                                                    // Buffer of the merged message stream, default 100
+	Events     chan<- PanelEvent                                      // Connection events of all panels are sent here (optional). Must be read continuously
+}
+
+// Messages from one of the panels of a PanelManager
+type PanelMessage struct {
+	PanelID  string
+	Messages []*rwp.OutboundMessage
+}
+
+// Connection event from one of the panels of a PanelManager
+type PanelEvent struct {
+	PanelID string
+	Event   ConnectionEvent
+}
+
+// Connection status of a managed panel
+type PanelStatus struct {
+	ID             string
+	Address        string
+	Groups         []string
+	Connected      bool
+	Encoding       PanelEncoding // Valid when connected
+	RemoteAddr     string        // Valid when connected
+	ConnectedSince time.Time     // Valid when connected
+	Attempt        int           // Current connection attempt, 0 once connected
+	ErrorMsg       string        // Last error message the panel replied with, if any
+	LastError      error         // Cause of the last disconnect
+	Stopped        bool          // ConnectToPanel gave up (RetryPolicy) or the panel is being removed
+}
+
+// Runs ConnectToPanel for a set of panels which can be added and removed at runtime.
+// Messages from all panels are merged into one stream tagged with the panel ID, and messages can be sent to a single panel, a group or all panels.
+type PanelManager struct {
+	sync.RWMutex
+
+	config   PanelManagerConfig
+	ctx      context.Context
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+	panels   map[string]*managedPanel
+	messages chan PanelMessage
+	closed   bool
+}
+
+type managedPanel struct {
+	panel   ManagedPanel
+	toPanel chan []*rwp.InboundMessage
+	ctx     context.Context
+	cancel  context.CancelFunc
+	done    chan struct{}
+	status  PanelStatus // Guarded by the manager
+}
+
+// Creates a panel manager. The manager stops all panels when ctx is done or Close is called. Config is optional
+func NewPanelManager(ctx context.Context, config *PanelManagerConfig) *PanelManager {
+	pm := &PanelManager{
+		panels: make(map[string]*managedPanel),
+	}
+	if config != nil {
+		pm.config = *config
+	}
+	if pm.config.BufferSize <= 0 {
+		pm.config.BufferSize = 100
+	}
+	pm.ctx, pm.cancel = context.WithCancel(ctx)
+	pm.messages = make(chan PanelMessage, pm.config.BufferSize)
+	return pm
+}
+
+// Returns the merged stream of messages from all panels. It must be read continuously and is closed by Close
+func (pm *PanelManager) Messages() <-chan PanelMessage {
+	return pm.messages
+}
+
+// Adds a panel and starts connecting to it
+func (pm *PanelManager) Add(panel ManagedPanel) error {
+	pm.Lock()
+	defer pm.Unlock()
+
+	if pm.closed || pm.ctx.Err() != nil {
+		return ErrManagerClosed
+	}
+	if _, exists := pm.panels[panel.ID]; exists {
+		return ErrDuplicatePanel
+	}
+	if d := pm.config.Defaults; panel.Config == nil && d != nil && (d.Metrics != nil || d.Recorder != nil || d.Liveness != nil || d.SendScheduler != nil) {
+		return ErrSharedDefaults
+	}
+
+	mp := &managedPanel{
+		panel:   panel,
+		toPanel: make(chan []*rwp.InboundMessage, 10),
+		done:    make(chan struct{}),
+		status: PanelStatus{
+			ID:      panel.ID,
+			Address: panel.Address,
+			Groups:  append([]string{}, panel.Groups...),
+		},
+	}
+	mp.ctx, mp.cancel = context.WithCancel(pm.ctx)
+	pm.panels[panel.ID] = mp
+
+	// Our own event handler keeps the status, then passes events on:
+	config := ConnectToPanelConfig{}
+	if panel.Config != nil {
+		config = *panel.Config
+	} else {
+		if pm.config.Defaults != nil {
+			config = *pm.config.Defaults
+		}
+		if pm.config.PerPanel != nil {
+			pm.config.PerPanel(panel, &config)
+		}
+	}
+	userHandler := config.EventHandler
+	config.EventHandler = ConnectionEventHandlerFunc(func(ev ConnectionEvent) {
+		pm.updateStatus(mp, ev)
+		if userHandler != nil {
+			userHandler.HandleConnectionEvent(ev)
+		}
+		if pm.config.Events != nil {
+			pev := PanelEvent{PanelID: panel.ID, Event: ev}
+			select {
+			case pm.config.Events <- pev:
+			case <-mp.ctx.Done(): // Once stopping, only deliver if there is room
+				select {
+				case pm.config.Events <- pev:
+				default:
+				}
+			}
+		}
+	})
+
+	fromPanel := make(chan []*rwp.OutboundMessage, 10)
+	pm.wg.Add(1)
+	go func() {
+		defer pm.wg.Done()
+		defer close(mp.done)
+		defer mp.cancel() // Also if the RetryPolicy gave up, so sends don't wait for a connection which never comes
+
+		forwarderDone := make(chan struct{})
+		go func() {
+			defer close(forwarderDone)
+			for msgs := range fromPanel {
+				select {
+				case pm.messages <- PanelMessage{PanelID: panel.ID, Messages: msgs}:
+				case <-mp.ctx.Done():
+				}
+			}
+		}()
+
+		ConnectToPanel(panel.Address, mp.toPanel, fromPanel, mp.ctx, nil, nil, nil, &config)
+		close(fromPanel)
+		<-forwarderDone
+	}()
+
+	return nil
+}
+
+// Stops and removes a panel. It returns when the connection is closed
+func (pm *PanelManager) Remove(id string) error {
+	pm.Lock()
+	mp, exists := pm.panels[id]
+	if exists {
+		delete(pm.panels, id)
+	}
+	pm.Unlock()
+
+	if !exists {
+		return ErrUnknownPanel
+	}
+	mp.cancel()
+	<-mp.done
+	return nil
+}
+
+func (pm *PanelManager) updateStatus(mp *managedPanel, ev ConnectionEvent) {
+	pm.Lock()
+	defer pm.Unlock()
+
+	status := &mp.status
+	switch ev := ev.(type) {
+	case *DialingEvent:
+		status.Attempt = ev.Attempt
+	case *PanelRejectedEvent:
+		status.ErrorMsg = ev.ErrorMsg
+	case *ConnectedEvent:
+		status.Connected = true
+		status.Encoding = ev.Encoding
+		status.RemoteAddr = ev.RemoteAddr
+		status.ConnectedSince = ev.Time
+		status.Attempt = 0
+	case *DisconnectedEvent:
+		status.Connected = false
+		status.LastError = ev.Cause
+	case *StoppedEvent:
+		status.Connected = false
+		status.Stopped = true
+	}
+}
+
+// Returns the status of a panel
+func (pm *PanelManager) Status(id string) (PanelStatus, bool) {
+	pm.RLock()
+	defer pm.RUnlock()
+	mp, exists := pm.panels[id]
+	if !exists {
+		return PanelStatus{}, false
+	}
+	return mp.status, true
+}
+
+// Returns the status of all panels, sorted by ID
+func (pm *PanelManager) Statuses() []PanelStatus {
+	pm.RLock()
+	defer pm.RUnlock()
+	statuses := make([]PanelStatus, 0, len(pm.panels))
+	for _, mp := range pm.panels {
+		statuses = append(statuses, mp.status)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].ID < statuses[j].ID })
+	return statuses
+}
+
+// Sends messages to one panel. Like sending to msgsToPanel of ConnectToPanel, messages are dropped while the panel is not connected.
+// Returns ErrPanelStopped if the panel is being removed or its RetryPolicy gave up
+func (pm *PanelManager) Send(id string, msgs []*rwp.InboundMessage) error {
+	pm.RLock()
+	mp, exists := pm.panels[id]
+	pm.RUnlock()
+	if !exists {
+		return ErrUnknownPanel
+	}
+	return mp.send(msgs)
+}
+
+// Sends messages to all panels in a group. Returns the number of panels which took them (stopped panels don't)
+func (pm *PanelManager) SendToGroup(group string, msgs []*rwp.InboundMessage) int {
+	return pm.sendWhere(msgs, func(mp *managedPanel) bool {
+		for _, g := range mp.panel.Groups {
+			if g == group {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// Sends messages to all panels. Returns the number of panels which took them (stopped panels don't)
+func (pm *PanelManager) SendToAll(msgs []*rwp.InboundMessage) int {
+	return pm.sendWhere(msgs, func(mp *managedPanel) bool { return true })
+}
+
+// The messages are shared between panels, so they must not be modified after sending
+func (pm *PanelManager) sendWhere(msgs []*rwp.InboundMessage, match func(*managedPanel) bool) int {
+	pm.RLock()
+	targets := []*managedPanel{}
+	for _, mp := range pm.panels {
+		if match(mp) {
+			targets = append(targets, mp)
+		}
+	}
+	pm.RUnlock()
+
+	sent := 0
+	for _, mp := range targets {
+		if mp.send(msgs) == nil {
+			sent++
+		}
+	}
+	return sent
+}
+
+// Blocks until the connection takes the messages, or the panel is stopped
+func (mp *managedPanel) send(msgs []*rwp.InboundMessage) error {
+	if mp.ctx.Err() != nil {
+		return ErrPanelStopped
+	}
+	select {
+	case mp.toPanel <- msgs:
+		return nil
+	case <-mp.ctx.Done():
+		return ErrPanelStopped
+	}
+}
+
+// Returns the IDs of all panels, sorted
+func (pm *PanelManager) Panels() []string {
+	pm.RLock()
+	defer pm.RUnlock()
+	ids := make([]string, 0, len(pm.panels))
+	for id := range pm.panels {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// Stops all panels, waits for them to disconnect and closes the message stream
+func (pm *PanelManager) Close() {
+	pm.Lock()
+	if pm.closed {
+		pm.Unlock()
+		return
+	}
+	pm.closed = true
+	pm.Unlock()
+
+	pm.cancel()
+	pm.wg.Wait()
+	close(pm.messages)
+}
@@ -0,0 +1,129 @@
+package rawpanellib_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	helpers "github.com/SKAARHOJ/rawpanel-lib"
+	"github.com/SKAARHOJ/rawpanel-lib/emulator"
+	rwp "github.com/SKAARHOJ/rawpanel-lib/ibeam_rawpanel"
+	"github.com/SKAARHOJ/rawpanel-lib/internal/testpanel"
+)
+
+func TestPanelManager(t *testing.T) {
+	emus := map[string]*emulator.Emulator{}
+	pm := helpers.NewPanelManager(context.Background(), nil)
+	for _, id := range []string{"left", "right", "spare"} {
+		emu, addr := testpanel.Start(t, nil)
+		emus[id] = emu
+		groups := []string{"desk"}
+		if id == "spare" {
+			groups = nil
+		}
+		if err := pm.Add(helpers.ManagedPanel{ID: id, Address: addr, Groups: groups}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer pm.Close()
+
+	if err := pm.Add(helpers.ManagedPanel{ID: "left"}); err != helpers.ErrDuplicatePanel {
+		t.Fatalf("expected duplicate error, got %v", err)
+	}
+	testpanel.WaitFor(t, "all panels connected", func() bool {
+		for _, status := range pm.Statuses() {
+			if !status.Connected {
+				return false
+			}
+		}
+		return true
+	})
+
+	// Routing:
+	if n := pm.SendToGroup("desk", []*rwp.InboundMessage{{States: []*rwp.HWCState{{HWCIDs: []uint32{1}, HWCMode: &rwp.HWCMode{State: rwp.HWCMode_ON}}}}}); n != 2 {
+		t.Fatalf("sent to %d panels in group, want 2", n)
+	}
+	pm.Send("spare", []*rwp.InboundMessage{{States: []*rwp.HWCState{{HWCIDs: []uint32{2}, HWCMode: &rwp.HWCMode{State: rwp.HWCMode_ON}}}}})
+	testpanel.WaitFor(t, "feedback states", func() bool {
+		return emus["left"].State(1) != nil && emus["right"].State(1) != nil && emus["spare"].State(2) != nil
+	})
+	if emus["spare"].State(1) != nil {
+		t.Fatal("spare panel received group message")
+	}
+
+	// Merged stream tagged with the panel:
+	emus["right"].Press(1, rwp.BinaryEvent_UNKNOWN)
+	timeout := time.After(5 * time.Second)
+	for gotPress := false; !gotPress; {
+		select {
+		case msg := <-pm.Messages():
+			for _, m := range msg.Messages {
+				for _, event := range m.Events {
+					if event.HWCID == 1 && event.Binary != nil && event.Binary.Pressed {
+						if msg.PanelID != "right" {
+							t.Fatalf("press tagged with %s", msg.PanelID)
+						}
+						gotPress = true
+					}
+				}
+			}
+		case <-timeout:
+			t.Fatal("press not received")
+		}
+	}
+
+	// Removal:
+	if err := pm.Remove("left"); err != nil {
+		t.Fatal(err)
+	}
+	if _, exists := pm.Status("left"); exists {
+		t.Fatal("removed panel still has a status")
+	}
+	if err := pm.Send("left", nil); err != helpers.ErrUnknownPanel {
+		t.Fatalf("expected unknown panel, got %v", err)
+	}
+	testpanel.WaitFor(t, "emulator to see the disconnect", func() bool {
+		return emus["left"].ClientCount() == 0
+	})
+}
+
+// A panel whose RetryPolicy gave up doesn't block senders, and per connection objects can't be shared through Defaults
+func TestPanelManagerStoppedPanel(t *testing.T) {
+	pm := helpers.NewPanelManager(context.Background(), &helpers.PanelManagerConfig{
+		Defaults: &helpers.ConnectToPanelConfig{RetryPolicy: &helpers.FixedRetryPolicy{Delay: 10 * time.Millisecond, MaxAttempts: 1}},
+	})
+	defer pm.Close()
+
+	if err := pm.Add(helpers.ManagedPanel{ID: "gone", Address: "127.0.0.1:1"}); err != nil {
+		t.Fatal(err)
+	}
+	testpanel.WaitFor(t, "panel to stop", func() bool {
+		status, _ := pm.Status("gone")
+		return status.Stopped
+	})
+	sent := make(chan bool)
+	go func() {
+		for i := 0; i < 20; i++ {
+			if err := pm.Send("gone", nil); err != helpers.ErrPanelStopped {
+				t.Errorf("expected stopped panel, got %v", err)
+			}
+		}
+		if n := pm.SendToAll(nil); n != 0 {
+			t.Errorf("sent to %d stopped panels", n)
+		}
+		close(sent)
+	}()
+	select {
+	case <-sent:
+	case <-time.After(5 * time.Second):
+		t.Fatal("sending to a stopped panel blocks")
+	}
+
+	shared := helpers.NewPanelManager(context.Background(), &helpers.PanelManagerConfig{
+		Defaults: &helpers.ConnectToPanelConfig{Liveness: helpers.NewLivenessMonitor(nil)},
+	})
+	defer shared.Close()
+	if err := shared.Add(helpers.ManagedPanel{ID: "panel", Address: "127.0.0.1:1"}); err != helpers.ErrSharedDefaults {
+		t.Fatalf("expected shared defaults error, got %v", err)
+	}
+}
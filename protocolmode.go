@@ -0,0 +1,178 @@
+package rawpanellib
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	rwp "github.com/SKAARHOJ/rawpanel-lib/ibeam_rawpanel"
+	log "github.com/s00500/env_logger"
+)
+
+// How the protocol encoding of a panel is chosen when connecting
+type ProtocolMode int
+
+const (
+	ProtocolAuto      ProtocolMode = iota // Probe with a binary ping and fall back to ASCII (default)
+	ProtocolASCII                         // Always use ASCII. Nothing binary is ever sent, for panels reacting badly to the probe
+	ProtocolBinary                        // Always use binary without probing
+	ProtocolNegotiate                     // Ask "list" in ASCII and upgrade to binary if the panel reports binary support in _support
+)
+
+func (pm ProtocolMode) String() string {
+	switch pm {
+	case ProtocolASCII:
+		return "ASCII"
+	case ProtocolBinary:
+		return "binary"
+	case ProtocolNegotiate:
+		return "negotiate"
+	}
+	return "auto"
+}
+
+// Parses the names returned by String (case insensitive), e.g. from a command line flag
+func ParseProtocolMode(s string) (ProtocolMode, error) {
+	for _, pm := range []ProtocolMode{ProtocolAuto, ProtocolASCII, ProtocolBinary, ProtocolNegotiate} {
+		if strings.EqualFold(s, pm.String()) {
+			return pm, nil
+		}
+	}
+	return ProtocolAuto, fmt.Errorf("unknown protocol mode %q, use auto, ASCII, binary or negotiate", s)
+}
+
+// Time the panel may stay silent after _support before we consider the reply to "list" complete
+const negotiationQuietPeriod = 100 * time.Millisecond
+
+// Detects the encoding of a panel for ProtocolASCII, ProtocolBinary and ProtocolNegotiate (ProtocolAuto is handled by the callers' binary probe).
+// Returns true if binary, and an error message the panel may have sent in ASCII (typically if it doesn't accept more clients).
+// Waits at most timeout for replies (0 means DefaultDetectionTimeout). If ctx is done while negotiating, it returns the context error and the connection should be closed.
+func DetectPanelEncodingWithMode(ctx context.Context, c net.Conn, panelIPAndPort string, mode ProtocolMode, timeout time.Duration) (bool, string, error) {
+	switch mode {
+	case ProtocolASCII:
+		log.Debugln("Using ASCII Protocol Mode for panel", panelIPAndPort, "(forced)")
+		return false, "", nil
+	case ProtocolBinary:
+		log.Debugln("Using Binary Protocol Mode for panel", panelIPAndPort, "(forced)")
+		return true, "", nil
+	case ProtocolNegotiate:
+		return negotiatePanelEncoding(ctx, c, panelIPAndPort, timeout)
+	}
+	binaryPanel, err := AutoDetectIfPanelEncodingIsBinaryContext(ctx, c, panelIPAndPort, timeout)
+	return binaryPanel, "", err
+}
+
+func negotiatePanelEncoding(ctx context.Context, c net.Conn, panelIPAndPort string, timeout time.Duration) (bool, string, error) {
+	if timeout <= 0 {
+		timeout = DefaultDetectionTimeout
+	}
+	deadline := time.Now().Add(timeout)
+	log.Should(c.SetReadDeadline(deadline))
+	stopAbort := abortOnDone(ctx, c)
+	defer stopAbort()
+
+	// Ask for panel info in ASCII:
+	log.Debugln("Negotiating protocol mode of panel", panelIPAndPort, "by sending list")
+	if _, err := c.Write([]byte("list\n")); err != nil {
+		if ctx.Err() != nil {
+			return false, "", ctx.Err()
+		}
+		log.Should(err)
+		return false, "", nil
+	}
+
+	// Read lines until _support. Bytes are read one at a time, so nothing after the reply is consumed from the connection.
+	errorMsg := ""
+	binarySupport := false
+	for {
+		line, err := readLineUnbuffered(c)
+		if ctx.Err() != nil {
+			return false, "", ctx.Err()
+		}
+		if err != nil {
+			log.Debugln("No _support reply from panel", panelIPAndPort, "staying with ASCII:", err)
+			c.SetReadDeadline(time.Time{})
+			return false, errorMsg, nil
+		}
+		if strings.HasPrefix(line, "ErrorMsg=") {
+			errorMsg = line[9:]
+		}
+		if strings.HasPrefix(line, "_support=") {
+			for _, feature := range strings.Split(line[9:], ",") {
+				if strings.TrimSpace(feature) == "Binary" {
+					binarySupport = true
+				}
+			}
+			break
+		}
+	}
+
+	if !binarySupport {
+		log.Debugln("Panel", panelIPAndPort, "doesn't support binary, using ASCII Protocol Mode")
+		c.SetReadDeadline(time.Time{})
+		return false, errorMsg, nil
+	}
+
+	// Let the rest of the reply to list pass, so it's not mistaken for binary data:
+	for time.Now().Before(deadline) && ctx.Err() == nil {
+		log.Should(c.SetReadDeadline(time.Now().Add(negotiationQuietPeriod)))
+		if _, err := readLineUnbuffered(c); err != nil {
+			break
+		}
+	}
+
+	// Upgrade with a binary ping, which should be answered with an ACK:
+	pbdata, err := proto.Marshal(&rwp.InboundMessage{FlowMessage: rwp.InboundMessage_PING})
+	log.Should(err)
+	header := make([]byte, 4)
+	binary.LittleEndian.PutUint32(header, uint32(len(pbdata)))
+	log.Should(c.SetReadDeadline(time.Now().Add(timeout)))
+	if ctx.Err() != nil { // Checked after setting the deadline, since that would override the abort
+		return false, "", ctx.Err()
+	}
+	if _, err := c.Write(append(header, pbdata...)); err != nil {
+		if ctx.Err() != nil {
+			return false, "", ctx.Err()
+		}
+		log.Should(err)
+		return false, errorMsg, nil
+	}
+
+	byteArray := make([]byte, 1000)
+	byteCount, err := c.Read(byteArray)
+	if ctx.Err() != nil {
+		return false, "", ctx.Err()
+	}
+	c.SetReadDeadline(time.Time{})
+	if err == nil && byteCount > 4 && binary.LittleEndian.Uint32(byteArray[0:4])+4 == uint32(byteCount) {
+		reply := &rwp.OutboundMessage{}
+		if proto.Unmarshal(byteArray[4:byteCount], reply) == nil && reply.FlowMessage == rwp.OutboundMessage_ACK {
+			log.Debugln("Upgraded to Binary Protocol Mode for panel", panelIPAndPort)
+			return true, errorMsg, nil
+		}
+	}
+
+	log.Debugln("Panel", panelIPAndPort, "didn't ACK binary ping, using ASCII Protocol Mode")
+	c.Write([]byte("\n")) // Clearing an ASCII panels buffer with a newline since we sent it binary stuff
+	return false, errorMsg, nil
+}
+
+// Reads a line without reading anything beyond the newline
+func readLineUnbuffered(c net.Conn) (string, error) {
+	line := []byte{}
+	b := make([]byte, 1)
+	for {
+		if _, err := c.Read(b); err != nil {
+			return "", err
+		}
+		if b[0] == '\n' {
+			return strings.TrimSpace(string(line)), nil
+		}
+		line = append(line, b[0])
+	}
+}
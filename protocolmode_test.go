@@ -0,0 +1,47 @@
+package rawpanellib_test
+
+import (
+	"testing"
+	"time"
+
+	helpers "github.com/SKAARHOJ/rawpanel-lib"
+	"github.com/SKAARHOJ/rawpanel-lib/emulator"
+	rwp "github.com/SKAARHOJ/rawpanel-lib/ibeam_rawpanel"
+	"github.com/SKAARHOJ/rawpanel-lib/internal/testpanel"
+)
+
+func TestProtocolModes(t *testing.T) {
+	tests := []struct {
+		name       string
+		encoding   emulator.Encoding
+		mode       helpers.ProtocolMode
+		wantBinary bool
+	}{
+		{"negotiate upgrades", emulator.EncodingAuto, helpers.ProtocolNegotiate, true},
+		{"negotiate without binary support", emulator.EncodingASCII, helpers.ProtocolNegotiate, false},
+		{"forced ASCII", emulator.EncodingAuto, helpers.ProtocolASCII, false},
+		{"forced binary", emulator.EncodingAuto, helpers.ProtocolBinary, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, addr := testpanel.Start(t, &emulator.Config{Encoding: test.encoding})
+			session := testpanel.RunConnectToPanel(t, addr, &helpers.ConnectToPanelConfig{ProtocolMode: test.mode})
+			if binary := session.WaitConnected(t); binary != test.wantBinary {
+				t.Fatalf("got binary %v, want %v", binary, test.wantBinary)
+			}
+
+			session.ToPanel <- []*rwp.InboundMessage{{Command: &rwp.Command{SendPanelInfo: true}}}
+			timeout := time.After(5 * time.Second)
+			for gotModel := false; !gotModel; {
+				select {
+				case msgs := <-session.FromPanel:
+					for _, msg := range msgs {
+						gotModel = gotModel || msg.GetPanelInfo().GetModel() == "SK_EMULATOR"
+					}
+				case <-timeout:
+					t.Fatal("no panel info received")
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,271 @@
+/*
+   Copyright 2026 SKAARHOJ ApS
+
+   Released under MIT License
+*/
+
+package proxy
+
+import (
+	"sort"
+
+	"google.golang.org/protobuf/proto"
+
+	helpers "github.com/SKAARHOJ/rawpanel-lib"
+	rwp "github.com/SKAARHOJ/rawpanel-lib/ibeam_rawpanel"
+)
+
+// Type LayeredState decides what is shown on a panel shared by several
+// systems. Every system writes its feedback into its own layer, and for
+// each HWC the layer with the highest priority which has a state for it
+// is shown. Among layers of the same priority, the one which wrote to the
+// HWC last wins, so equal systems behave as if they were alone.
+//
+// Clearing LEDs or displays and the panel brightness are layered the
+// same way, so a system can't wipe what a more important one shows.
+//
+// The methods return the states to send to the panel to reflect the
+// change. LayeredState is not safe for concurrent use.
+type LayeredState struct {
+	layers map[int]*layer
+	owners map[uint32]int // Layer shown per HWC
+	seq    uint64
+}
+
+type layer struct {
+	priority          int
+	states            map[uint32]*rwp.HWCState // Accumulated feedback per HWC
+	written           map[uint32]uint64        // Sequence number of the last write per HWC
+	brightness        *rwp.Brightness          // Panel brightness set by the layer, nil if none
+	brightnessWritten uint64
+}
+
+// Creates an empty layered state
+func NewLayeredState() *LayeredState {
+	return &LayeredState{
+		layers: make(map[int]*layer),
+		owners: make(map[uint32]int),
+	}
+}
+
+// Adds a layer with a priority, higher is more important. IDs must not be negative, an existing layer with the same ID is replaced
+func (ls *LayeredState) AddLayer(id int, priority int) []*rwp.HWCState {
+	updates := ls.RemoveLayer(id)
+	ls.layers[id] = &layer{
+		priority: priority,
+		states:   make(map[uint32]*rwp.HWCState),
+		written:  make(map[uint32]uint64),
+	}
+	return updates
+}
+
+// Writes feedback into a layer. States of HWCs where the layer is (or becomes) the one shown are returned:
+// If the layer was shown already the state is passed on as it is, otherwise the full accumulated state of the layer is returned,
+// on top of turning off what the layer shown before set.
+func (ls *LayeredState) Apply(id int, states []*rwp.HWCState) []*rwp.HWCState {
+	l, exists := ls.layers[id]
+	if !exists {
+		return nil
+	}
+
+	updates := []*rwp.HWCState{}
+	for _, state := range states {
+		passOn := []uint32{}
+		for _, hwc := range state.HWCIDs {
+			ls.seq++
+			if _, exists := l.states[hwc]; !exists {
+				l.states[hwc] = &rwp.HWCState{HWCIDs: []uint32{hwc}}
+			}
+			helpers.MergeHWCState(l.states[hwc], state)
+			l.written[hwc] = ls.seq
+
+			owner, owned := ls.owners[hwc]
+			if ls.winner(hwc) != id {
+				continue
+			}
+			ls.owners[hwc] = id
+			switch {
+			case owned && owner == id:
+				passOn = append(passOn, hwc)
+			case owned:
+				updates = append(updates, takeoverState(hwc, l))
+			default:
+				updates = append(updates, proto.Clone(l.states[hwc]).(*rwp.HWCState))
+			}
+		}
+		if len(passOn) > 0 {
+			update := proto.Clone(state).(*rwp.HWCState)
+			update.HWCIDs = passOn
+			updates = append(updates, update)
+		}
+	}
+	return updates
+}
+
+// Removes a layer. HWCs it was shown on fall back to the next layer, or are cleared if no other layer has a state for them
+func (ls *LayeredState) RemoveLayer(id int) []*rwp.HWCState {
+	l, exists := ls.layers[id]
+	if !exists {
+		return nil
+	}
+	delete(ls.layers, id)
+
+	updates := []*rwp.HWCState{}
+	for hwc := range l.states {
+		if owner, owned := ls.owners[hwc]; !owned || owner != id {
+			continue
+		}
+		next := ls.winner(hwc)
+		if next < 0 {
+			delete(ls.owners, hwc)
+			updates = append(updates, clearedState(hwc))
+			continue
+		}
+		ls.owners[hwc] = next
+		updates = append(updates, takeoverState(hwc, ls.layers[next]))
+	}
+	return updates
+}
+
+// Clears the LEDs and/or displays of a layer, like the ClearLEDs,
+// ClearDisplays and ClearAll commands do on a panel. HWCs where the layer
+// is shown are turned off, or fall back to the next layer.
+func (ls *LayeredState) Clear(id int, leds bool, displays bool) []*rwp.HWCState {
+	l, exists := ls.layers[id]
+	if !exists || (!leds && !displays) {
+		return nil
+	}
+
+	updates := []*rwp.HWCState{}
+	for hwc, state := range l.states {
+		if leds {
+			state.HWCMode = nil
+			state.HWCColor = nil
+		}
+		if displays {
+			state.HWCText = nil
+			state.HWCGfx = nil
+			state.Processors = nil
+		}
+		if proto.Equal(state, &rwp.HWCState{HWCIDs: state.HWCIDs}) {
+			delete(l.states, hwc)
+			delete(l.written, hwc)
+		}
+
+		if owner, owned := ls.owners[hwc]; !owned || owner != id {
+			continue
+		}
+		// What the layer showed is turned off, then the layer now shown is applied on top:
+		update := &rwp.HWCState{HWCIDs: []uint32{hwc}}
+		if leds {
+			update.HWCMode = &rwp.HWCMode{State: rwp.HWCMode_OFF}
+		}
+		if displays {
+			update.HWCText = &rwp.HWCText{Formatting: rwp.HWCText_FMT_HIDE}
+		}
+		next := ls.winner(hwc)
+		if next < 0 {
+			delete(ls.owners, hwc)
+		} else {
+			ls.owners[hwc] = next
+			helpers.MergeHWCState(update, ls.layers[next].states[hwc])
+		}
+		updates = append(updates, update)
+	}
+	sort.Slice(updates, func(i, j int) bool { return updates[i].HWCIDs[0] < updates[j].HWCIDs[0] })
+	return updates
+}
+
+// Sets the panel brightness of a layer. Returns the brightness to send to
+// the panel, or nil if another layer's brightness is shown.
+func (ls *LayeredState) SetBrightness(id int, brightness *rwp.Brightness) *rwp.Brightness {
+	l, exists := ls.layers[id]
+	if !exists {
+		return nil
+	}
+	ls.seq++
+	l.brightness = proto.Clone(brightness).(*rwp.Brightness)
+	l.brightnessWritten = ls.seq
+	if ls.brightnessWinner() != id {
+		return nil
+	}
+	return proto.Clone(l.brightness).(*rwp.Brightness)
+}
+
+// Returns the panel brightness shown, or nil if no layer has set one
+func (ls *LayeredState) Brightness() *rwp.Brightness {
+	winner := ls.brightnessWinner()
+	if winner < 0 {
+		return nil
+	}
+	return proto.Clone(ls.layers[winner].brightness).(*rwp.Brightness)
+}
+
+// Returns the states shown on all HWCs, e.g. to restore a panel after reconnecting
+func (ls *LayeredState) States() []*rwp.HWCState {
+	states := make([]*rwp.HWCState, 0, len(ls.owners))
+	for hwc, owner := range ls.owners {
+		states = append(states, proto.Clone(ls.layers[owner].states[hwc]).(*rwp.HWCState))
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i].HWCIDs[0] < states[j].HWCIDs[0] })
+	return states
+}
+
+// Returns the state shown on a HWC, or nil
+func (ls *LayeredState) State(hwc uint32) *rwp.HWCState {
+	owner, owned := ls.owners[hwc]
+	if !owned {
+		return nil
+	}
+	return proto.Clone(ls.layers[owner].states[hwc]).(*rwp.HWCState)
+}
+
+// Returns the layer which should be shown on a HWC, or -1 if no layer has a state for it
+func (ls *LayeredState) winner(hwc uint32) int {
+	winner := -1
+	var best *layer
+	for id, l := range ls.layers {
+		if _, exists := l.states[hwc]; !exists {
+			continue
+		}
+		if best == nil || l.priority > best.priority || (l.priority == best.priority && l.written[hwc] > best.written[hwc]) {
+			winner, best = id, l
+		}
+	}
+	return winner
+}
+
+// Returns the layer whose brightness is shown, or -1 if no layer has set one
+func (ls *LayeredState) brightnessWinner() int {
+	winner := -1
+	var best *layer
+	for id, l := range ls.layers {
+		if l.brightness == nil {
+			continue
+		}
+		if best == nil || l.priority > best.priority || (l.priority == best.priority && l.brightnessWritten > best.brightnessWritten) {
+			winner, best = id, l
+		}
+	}
+	return winner
+}
+
+// State which shows a layer on a HWC where another layer was shown: What
+// the other layer set is turned off first, so nothing of it stays visible
+// where the layer has no feedback of its own, e.g. a text when the layer
+// only sets the LED.
+func takeoverState(hwc uint32, l *layer) *rwp.HWCState {
+	state := clearedState(hwc)
+	helpers.MergeHWCState(state, l.states[hwc])
+	return state
+}
+
+// State which turns a HWC off when nobody has feedback for it anymore.
+// The text is hidden like an empty "HWCt#x=" does, an empty HWCText would not make it to ASCII panels.
+func clearedState(hwc uint32) *rwp.HWCState {
+	return &rwp.HWCState{
+		HWCIDs:  []uint32{hwc},
+		HWCMode: &rwp.HWCMode{State: rwp.HWCMode_OFF},
+		HWCText: &rwp.HWCText{Formatting: rwp.HWCText_FMT_HIDE},
+	}
+}
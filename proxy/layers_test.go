@@ -0,0 +1,130 @@
+package proxy
+
+import (
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+
+	rwp "github.com/SKAARHOJ/rawpanel-lib/ibeam_rawpanel"
+)
+
+func colorState(hwc uint32, color uint32) *rwp.HWCState {
+	return &rwp.HWCState{HWCIDs: []uint32{hwc}, HWCColor: &rwp.HWCColor{ColorIndex: &rwp.ColorIndex{Index: rwp.ColorIndex_Colors(color)}}}
+}
+
+func shownColor(ls *LayeredState, hwc uint32) rwp.ColorIndex_Colors {
+	return ls.State(hwc).GetHWCColor().GetColorIndex().GetIndex()
+}
+
+func TestLayeredState(t *testing.T) {
+	ls := NewLayeredState()
+	ls.AddLayer(1, 0)
+	ls.AddLayer(2, 0)
+	ls.AddLayer(3, 10)
+
+	// The layer which is shown passes its feedback on:
+	updates := ls.Apply(1, []*rwp.HWCState{colorState(1, 2)})
+	if len(updates) != 1 || !proto.Equal(updates[0], colorState(1, 2)) {
+		t.Fatalf("unexpected updates %v", updates)
+	}
+
+	// Same priority, the last write wins:
+	ls.Apply(2, []*rwp.HWCState{colorState(1, 4)})
+	if shownColor(ls, 1) != 4 {
+		t.Fatalf("expected the last write to win, got %v", shownColor(ls, 1))
+	}
+	ls.Apply(1, []*rwp.HWCState{{HWCIDs: []uint32{1}, HWCMode: &rwp.HWCMode{State: rwp.HWCMode_ON}}})
+	if state := ls.State(1); shownColor(ls, 1) != 2 || state.GetHWCMode().GetState() != rwp.HWCMode_ON {
+		t.Fatalf("expected the accumulated state of layer 1, got %v", state)
+	}
+
+	// Higher priority wins regardless of the order:
+	ls.Apply(3, []*rwp.HWCState{colorState(1, 5)})
+	if updates := ls.Apply(1, []*rwp.HWCState{colorState(1, 6), colorState(2, 6)}); len(updates) != 1 || updates[0].HWCIDs[0] != 2 {
+		t.Fatalf("expected only HWC 2 to be updated, got %v", updates)
+	}
+	if shownColor(ls, 1) != 5 {
+		t.Fatalf("expected the high priority layer to be shown, got %v", shownColor(ls, 1))
+	}
+
+	// Removing layers falls back to the next one, and clears when none is left:
+	updates = ls.RemoveLayer(3)
+	if len(updates) != 1 || updates[0].GetHWCColor().GetColorIndex().GetIndex() != 6 {
+		t.Fatalf("expected a fall back to layer 1, got %v", updates)
+	}
+	ls.RemoveLayer(2)
+	updates = ls.RemoveLayer(1)
+	if len(updates) != 2 {
+		t.Fatalf("expected both HWCs to be cleared, got %v", updates)
+	}
+	for _, update := range updates {
+		if update.GetHWCMode().GetState() != rwp.HWCMode_OFF {
+			t.Fatalf("expected HWC %v to be turned off", update.HWCIDs)
+		}
+	}
+	if len(ls.States()) != 0 {
+		t.Fatalf("expected no states left, got %v", ls.States())
+	}
+}
+
+// Nothing of a layer which isn't shown anymore stays on the panel
+func TestLayeredStateTakeover(t *testing.T) {
+	ls := NewLayeredState()
+	ls.AddLayer(1, 0)
+	ls.AddLayer(2, 10)
+	ls.Apply(2, []*rwp.HWCState{{HWCIDs: []uint32{1}, HWCText: &rwp.HWCText{Textline1: "High"}}})
+	ls.Apply(1, []*rwp.HWCState{{HWCIDs: []uint32{1}, HWCMode: &rwp.HWCMode{State: rwp.HWCMode_ON}}})
+
+	updates := ls.RemoveLayer(2)
+	want := &rwp.HWCState{
+		HWCIDs:  []uint32{1},
+		HWCMode: &rwp.HWCMode{State: rwp.HWCMode_ON},
+		HWCText: &rwp.HWCText{Formatting: rwp.HWCText_FMT_HIDE},
+	}
+	if len(updates) != 1 || !proto.Equal(updates[0], want) {
+		t.Fatalf("expected the text to be hidden and the LED on, got %v", updates)
+	}
+
+	// Same when a layer of the same priority takes over by writing:
+	ls.AddLayer(3, 0)
+	updates = ls.Apply(3, []*rwp.HWCState{colorState(1, 2)})
+	if len(updates) != 1 || updates[0].GetHWCMode().GetState() != rwp.HWCMode_OFF || shownColor(ls, 1) != 2 {
+		t.Fatalf("expected the LED of layer 1 to be turned off, got %v", updates)
+	}
+}
+
+// Clearing and the brightness only affect the layer of the system doing it
+func TestLayeredStateClearAndBrightness(t *testing.T) {
+	ls := NewLayeredState()
+	ls.AddLayer(1, 0)
+	ls.AddLayer(2, 10)
+	ls.Apply(1, []*rwp.HWCState{colorState(1, 2), colorState(2, 3)})
+	ls.Apply(2, []*rwp.HWCState{colorState(1, 4)})
+
+	// HWC 1 shows layer 2 which isn't cleared, HWC 2 is turned off:
+	updates := ls.Clear(1, true, true)
+	if len(updates) != 1 || updates[0].HWCIDs[0] != 2 || updates[0].GetHWCMode().GetState() != rwp.HWCMode_OFF {
+		t.Fatalf("unexpected updates %v", updates)
+	}
+	if shownColor(ls, 1) != 4 || ls.State(2) != nil {
+		t.Fatalf("unexpected state after clearing, HWC 1 %v, HWC 2 %v", ls.State(1), ls.State(2))
+	}
+
+	// Clearing the shown layer falls back to the next one:
+	ls.Apply(1, []*rwp.HWCState{colorState(1, 5)})
+	updates = ls.Clear(2, true, false)
+	if len(updates) != 1 || updates[0].GetHWCColor().GetColorIndex().GetIndex() != 5 || updates[0].GetHWCMode().GetState() != rwp.HWCMode_OFF {
+		t.Fatalf("unexpected updates %v", updates)
+	}
+
+	if b := ls.SetBrightness(2, &rwp.Brightness{LEDs: 6, OLEDs: 6}); b.GetLEDs() != 6 {
+		t.Fatalf("unexpected brightness %v", b)
+	}
+	if b := ls.SetBrightness(1, &rwp.Brightness{LEDs: 2, OLEDs: 2}); b != nil {
+		t.Fatalf("lower priority brightness passed on: %v", b)
+	}
+	ls.RemoveLayer(2)
+	if b := ls.Brightness(); b.GetLEDs() != 2 {
+		t.Fatalf("expected fallback brightness, got %v", b)
+	}
+}
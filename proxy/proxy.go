@@ -0,0 +1,432 @@
+/*
+   Copyright 2026 SKAARHOJ ApS
+
+   Released under MIT License
+*/
+
+// Package proxy bridges systems and a SKAARHOJ Raw Panel which speak
+// different encodings of the Raw Panel protocol.
+//
+// The proxy keeps one connection to the panel in the best encoding the
+// panel supports and accepts any number of systems in ASCII or binary,
+// detected per connection. Messages are translated both ways, so a legacy
+// ASCII automation system can drive a Blue Pill panel in binary.
+//
+// Since the panel sees a single client, panels which only accept one
+// system can be shared, for example by a monitoring tool running next to
+// the production controller. Events go to all systems, while feedback is
+// combined by a LayeredState according to the priority of each system.
+
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	helpers "github.com/SKAARHOJ/rawpanel-lib"
+	rwp "github.com/SKAARHOJ/rawpanel-lib/ibeam_rawpanel"
+	"github.com/SKAARHOJ/rawpanel-lib/internal/panelserver"
+	log "github.com/s00500/env_logger"
+)
+
+// Type Config holds the settings of a Proxy
+type Config struct {
+	PanelConfig  *helpers.ConnectToPanelConfig // Options for the panel connection (optional). If nil, the panel is asked for its supported encodings with ProtocolNegotiate
+	TLSConfig    *tls.Config                   // Listen accepts TLS connections from systems if set
+	MaxFrameSize int                           // Max payload size of binary frames from systems, default helpers.DefaultMaxFrameSize
+	MaxClients   int                           // Max number of systems, 0 is unlimited. Reported to systems as PanelInfo.MaxClients instead of the panel's own limit
+	AllowedIPs   []string                      // Only systems from these IP addresses are accepted if set. Reported to systems as PanelInfo.LockedToIPs
+	Priority     func(addr net.Addr) int       // Priority of the feedback of a system, higher wins (optional, all systems have priority 0 by default)
+	QueueSize    int                           // Messages queued per system before it is considered stalled and disconnected, default 100
+	WriteTimeout time.Duration                 // Max time for a write to a system before it is disconnected, default 5 seconds
+}
+
+// Error messages sent to rejected systems, like a panel would
+const (
+	errorMsgMaxClients = "Max number of clients reached"
+	errorMsgNotAllowed = "IP address not allowed"
+)
+
+// Type Proxy connects to one panel and serves it to systems
+type Proxy struct {
+	config Config
+
+	ctx       context.Context
+	cancel    context.CancelFunc
+	wg        sync.WaitGroup
+	toPanel   chan []*rwp.InboundMessage
+	fromPanel chan []*rwp.OutboundMessage
+
+	mu          sync.RWMutex
+	listeners   panelserver.Listeners
+	clients     map[*client]bool
+	sessions    int // Accepted connections, including those still being detected
+	nextID      int
+	connected   bool
+	panelBinary bool
+
+	// Feedback of all systems. Held while sending to the panel, so updates arrive in the order they were applied
+	stateMu sync.Mutex
+	layers  *LayeredState
+}
+
+// A connected system
+type client struct {
+	*panelserver.Conn
+
+	id       int // Layer in the LayeredState
+	priority int
+	stalled  sync.Once
+}
+
+// Creates a proxy and starts connecting to the panel at panelIPAndPort.
+// The proxy stops when ctx is done or Close is called. Config is optional.
+func New(ctx context.Context, panelIPAndPort string, config *Config) *Proxy {
+	p := &Proxy{
+		toPanel:   make(chan []*rwp.InboundMessage, 100),
+		fromPanel: make(chan []*rwp.OutboundMessage, 100),
+		clients:   make(map[*client]bool),
+		layers:    NewLayeredState(),
+	}
+	if config != nil {
+		p.config = *config
+	}
+	if p.config.QueueSize <= 0 {
+		p.config.QueueSize = 100
+	}
+	if p.config.WriteTimeout <= 0 {
+		p.config.WriteTimeout = 5 * time.Second
+	}
+	p.ctx, p.cancel = context.WithCancel(ctx)
+
+	panelConfig := &helpers.ConnectToPanelConfig{ProtocolMode: helpers.ProtocolNegotiate}
+	if p.config.PanelConfig != nil {
+		panelConfig = p.config.PanelConfig
+	}
+
+	p.wg.Add(2)
+	go func() {
+		defer p.wg.Done()
+		helpers.ConnectToPanel(panelIPAndPort, p.toPanel, p.fromPanel, p.ctx, &p.wg, func(errorMsg string, binary bool, conn net.Conn) {
+			log.Infoln("Proxy: Connected to panel", panelIPAndPort, "binary:", binary)
+			p.mu.Lock()
+			p.connected = true
+			p.panelBinary = binary
+			p.mu.Unlock()
+
+			// A (re)connected panel starts out blank:
+			p.stateMu.Lock()
+			defer p.stateMu.Unlock()
+			if states := p.layers.States(); len(states) > 0 {
+				p.sendToPanel(stateMessages(states))
+			}
+			if brightness := p.layers.Brightness(); brightness != nil {
+				p.sendToPanel([]*rwp.InboundMessage{{Command: &rwp.Command{PanelBrightness: brightness}}})
+			}
+		}, func(binary bool) {
+			log.Infoln("Proxy: Disconnected from panel", panelIPAndPort)
+			p.mu.Lock()
+			p.connected = false
+			p.mu.Unlock()
+		}, panelConfig)
+	}()
+	go func() {
+		defer p.wg.Done()
+		p.distribute()
+	}()
+
+	return p
+}
+
+// Returns whether the panel is connected, and if so, whether it is connected in binary
+func (p *Proxy) PanelConnected() (connected bool, binary bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.connected, p.panelBinary
+}
+
+// Starts listening for systems on a network ("tcp" or "unix") and address, e.g. ":9923".
+// Returns the address actually listened on, which is useful with port 0.
+func (p *Proxy) Listen(network string, address string) (net.Addr, error) {
+	return p.listeners.Listen(p.ctx, &p.wg, network, address, p.config.TLSConfig, p.ServeConn)
+}
+
+// Serves a single system connection, for example one end of a net.Pipe. Returns immediately.
+func (p *Proxy) ServeConn(conn net.Conn) {
+	c := &client{Conn: panelserver.NewConn(conn, p.config.MaxFrameSize)}
+
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		p.serveClient(c)
+	}()
+}
+
+// Returns the number of connected systems
+func (p *Proxy) ClientCount() int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return len(p.clients)
+}
+
+// Stops listening, disconnects all systems and the panel
+func (p *Proxy) Close() {
+	p.cancel()
+	p.listeners.Close()
+	p.mu.Lock()
+	for c := range p.clients {
+		c.Close()
+	}
+	p.mu.Unlock()
+	p.wg.Wait()
+}
+
+func (p *Proxy) serveClient(c *client) {
+	defer c.Close()
+
+	if errorMsg := p.admit(c.RemoteAddr()); errorMsg != "" {
+		log.Infoln("Proxy: Rejecting system", c.RemoteAddr().String()+":", errorMsg)
+		c.Write([]byte("ErrorMsg=" + errorMsg + "\n")) // Sent in ASCII before detection, like panels do
+		return
+	}
+	defer func() {
+		p.mu.Lock()
+		p.sessions--
+		p.mu.Unlock()
+	}()
+
+	// Close the connection if the proxy stops while we wait for the first bytes:
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-p.ctx.Done():
+			c.Close()
+		case <-done:
+		}
+	}()
+
+	remoteAddr := c.RemoteAddr().String()
+	if err := c.DetectEncoding(); err != nil {
+		log.Debugln("Proxy: System", remoteAddr, "disconnected before sending anything")
+		return
+	}
+	log.Debugln("Proxy: System connected from", remoteAddr, "binary:", c.Binary())
+
+	// Everything to the system goes through its own queue, so a stalled system doesn't hold up the panel or the other systems:
+	queueCtx, stopQueue := context.WithCancel(p.ctx)
+	defer stopQueue()
+	c.StartQueue(queueCtx, p.config.QueueSize, p.config.WriteTimeout)
+
+	p.mu.Lock()
+	p.nextID++
+	c.id = p.nextID
+	p.clients[c] = true
+	p.mu.Unlock()
+	if p.config.Priority != nil {
+		c.priority = p.config.Priority(c.RemoteAddr())
+	}
+	p.stateMu.Lock()
+	p.layers.AddLayer(c.id, c.priority)
+	p.stateMu.Unlock()
+
+	defer func() {
+		p.mu.Lock()
+		delete(p.clients, c)
+		p.mu.Unlock()
+
+		// The panel falls back to the feedback of the remaining systems:
+		p.stateMu.Lock()
+		defer p.stateMu.Unlock()
+		brightness := p.layers.Brightness()
+		if states := p.layers.RemoveLayer(c.id); len(states) > 0 {
+			p.sendToPanel(stateMessages(states))
+		}
+		if next := p.layers.Brightness(); next != nil && !proto.Equal(next, brightness) {
+			p.sendToPanel([]*rwp.InboundMessage{{Command: &rwp.Command{PanelBrightness: next}}})
+		}
+	}()
+
+	// Systems can switch to binary after negotiating in ASCII
+	err := c.ReadMessages(true, func(msgs []*rwp.InboundMessage) { p.processInbound(c, msgs) })
+	if err != nil && p.ctx.Err() == nil {
+		log.Errorln("Proxy:", err)
+	}
+}
+
+// Counts a new connection, or returns why it is rejected
+func (p *Proxy) admit(addr net.Addr) string {
+	if len(p.config.AllowedIPs) > 0 {
+		host, _, err := net.SplitHostPort(addr.String())
+		allowed := false
+		for _, ip := range p.config.AllowedIPs {
+			allowed = allowed || (err == nil && net.ParseIP(host).Equal(net.ParseIP(ip)))
+		}
+		if !allowed {
+			return errorMsgNotAllowed
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.config.MaxClients > 0 && p.sessions >= p.config.MaxClients {
+		return errorMsgMaxClients
+	}
+	p.sessions++
+	return ""
+}
+
+// Answers pings and connection queries of the system and passes everything else on to the panel, with the feedback going through the layered state.
+// Clearing and the panel brightness are layered as well, so a system only clears its own feedback.
+// Pings are answered by the proxy itself, the system is supervising its link to us and not the panel.
+func (p *Proxy) processInbound(c *client, msgs []*rwp.InboundMessage) {
+	p.stateMu.Lock()
+	defer p.stateMu.Unlock()
+
+	toPanel := make([]*rwp.InboundMessage, 0, len(msgs))
+	for _, msg := range msgs {
+		msg = proto.Clone(msg).(*rwp.InboundMessage)
+		if msg.FlowMessage == rwp.InboundMessage_PING {
+			p.sendToSystem(c, []*rwp.OutboundMessage{{FlowMessage: rwp.OutboundMessage_ACK}})
+		}
+		msg.FlowMessage = rwp.InboundMessage_NONE // ACKs and other flow messages belong to the link between the system and us as well
+
+		if cmd := msg.Command; cmd != nil {
+			if cmd.GetConnections {
+				p.sendToSystem(c, []*rwp.OutboundMessage{{Connections: p.connections()}})
+				cmd.GetConnections = false
+			}
+			if cmd.ClearAll || cmd.ClearLEDs || cmd.ClearDisplays {
+				toPanel = append(toPanel, stateMessages(p.layers.Clear(c.id, cmd.ClearAll || cmd.ClearLEDs, cmd.ClearAll || cmd.ClearDisplays))...)
+				cmd.ClearAll, cmd.ClearLEDs, cmd.ClearDisplays = false, false, false
+			}
+			if cmd.PanelBrightness != nil {
+				cmd.PanelBrightness = p.layers.SetBrightness(c.id, cmd.PanelBrightness)
+			}
+			if proto.Size(cmd) == 0 {
+				msg.Command = nil
+			}
+		}
+
+		if len(msg.States) > 0 {
+			msg.States = p.layers.Apply(c.id, msg.States)
+		}
+
+		if proto.Size(msg) > 0 {
+			toPanel = append(toPanel, msg)
+		}
+	}
+	if len(toPanel) > 0 {
+		p.sendToPanel(toPanel)
+	}
+}
+
+// Puts every state in its own message, to keep binary frames small when many HWCs are updated at once
+func stateMessages(states []*rwp.HWCState) []*rwp.InboundMessage {
+	msgs := make([]*rwp.InboundMessage, 0, len(states))
+	for _, state := range states {
+		msgs = append(msgs, &rwp.InboundMessage{States: []*rwp.HWCState{state}})
+	}
+	return msgs
+}
+
+// While the panel is disconnected, ConnectToPanel drops what we send
+func (p *Proxy) sendToPanel(msgs []*rwp.InboundMessage) {
+	select {
+	case p.toPanel <- msgs:
+	case <-p.ctx.Done():
+	}
+}
+
+// Lists the connected systems, as the panel would list its clients
+func (p *Proxy) connections() *rwp.Connections {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	connections := &rwp.Connections{}
+	for c := range p.clients {
+		connections.Connection = append(connections.Connection, c.RemoteAddr().String())
+	}
+	sort.Strings(connections.Connection)
+	return connections
+}
+
+// Sends messages from the panel to all systems. Pings from the panel are answered here
+func (p *Proxy) distribute() {
+	for {
+		select {
+		case <-p.ctx.Done():
+			return
+		case msgs := <-p.fromPanel:
+			toSystems := make([]*rwp.OutboundMessage, 0, len(msgs))
+			for _, msg := range msgs {
+				switch msg.FlowMessage {
+				case rwp.OutboundMessage_PING:
+					select {
+					case p.toPanel <- []*rwp.InboundMessage{{FlowMessage: rwp.InboundMessage_ACK}}:
+					case <-p.ctx.Done():
+						return
+					}
+					continue
+				case rwp.OutboundMessage_ACK:
+					continue // Replies to our own pings
+				}
+				if msg.PanelInfo != nil {
+					msg = proto.Clone(msg).(*rwp.OutboundMessage)
+					p.rewritePanelInfo(msg.PanelInfo)
+				}
+				toSystems = append(toSystems, msg)
+			}
+			if len(toSystems) > 0 {
+				p.broadcast(toSystems)
+			}
+		}
+	}
+}
+
+// Systems see the proxy, not the panel: They can use either encoding whatever the panel supports, and the client limits are ours.
+// ASCII panels send PanelInfo in pieces, the limits are added to the piece with the model.
+func (p *Proxy) rewritePanelInfo(info *rwp.PanelInfo) {
+	if info.RawPanelSupport != nil {
+		info.RawPanelSupport.ASCII = true
+		info.RawPanelSupport.Binary = true
+	}
+	if info.Model != "" || info.MaxClients != 0 || len(info.LockedToIPs) > 0 {
+		info.MaxClients = uint32(p.config.MaxClients)
+		info.LockedToIPs = append([]string{}, p.config.AllowedIPs...)
+	}
+}
+
+// Sends messages to all connected systems
+func (p *Proxy) broadcast(msgs []*rwp.OutboundMessage) {
+	p.mu.RLock()
+	clients := make([]*client, 0, len(p.clients))
+	for c := range p.clients {
+		clients = append(clients, c)
+	}
+	p.mu.RUnlock()
+
+	for _, c := range clients {
+		// Each client gets its own copy since the queues are written concurrently:
+		clientMsgs := make([]*rwp.OutboundMessage, len(msgs))
+		for i, msg := range msgs {
+			clientMsgs[i] = proto.Clone(msg).(*rwp.OutboundMessage)
+		}
+		p.sendToSystem(c, clientMsgs)
+	}
+}
+
+// Queues messages for a system. A system whose queue is full has stalled and is disconnected
+func (p *Proxy) sendToSystem(c *client, msgs []*rwp.OutboundMessage) {
+	if err := c.Queue(msgs); err != nil {
+		c.stalled.Do(func() {
+			log.Warnln("Proxy: Disconnecting system", c.RemoteAddr().String()+":", err)
+			c.Close()
+		})
+	}
+}
@@ -0,0 +1,322 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	helpers "github.com/SKAARHOJ/rawpanel-lib"
+	"github.com/SKAARHOJ/rawpanel-lib/emulator"
+	rwp "github.com/SKAARHOJ/rawpanel-lib/ibeam_rawpanel"
+	topology "github.com/SKAARHOJ/rawpanel-lib/topology"
+)
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// A binary only panel behind the proxy, driven by an ASCII system and a binary system at the same time
+func TestBridge(t *testing.T) {
+	top := &topology.Topology{
+		HWc: []topology.TopologyHWcomponent{
+			{Id: 1, X: 100, Y: 100, Txt: "Display", Type: 1},
+		},
+		TypeIndex: map[uint32]topology.TopologyHWcTypeDef{
+			1: {W: 100, H: 100, Out: "rgb", In: "b", Disp: &topology.TopologyHWcTypeDef_Display{W: 64, H: 32}},
+		},
+	}
+	emu := emulator.New(top, &emulator.Config{Encoding: emulator.EncodingBinary})
+	defer emu.Close()
+	panelAddr, err := emu.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := New(context.Background(), panelAddr.String(), &Config{PanelConfig: &helpers.ConnectToPanelConfig{ProtocolMode: helpers.ProtocolBinary}})
+	defer p.Close()
+	addr, err := p.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "panel connection", func() bool {
+		connected, binary := p.PanelConnected()
+		return connected && binary
+	})
+
+	// ASCII system:
+	asciiConn, err := net.Dial("tcp", addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer asciiConn.Close()
+	asciiLines := make(chan string, 100)
+	go func() {
+		scanner := bufio.NewScanner(asciiConn)
+		for scanner.Scan() {
+			asciiLines <- scanner.Text()
+		}
+	}()
+
+	// Binary system:
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+	toPanel := make(chan []*rwp.InboundMessage, 10)
+	fromPanel := make(chan []*rwp.OutboundMessage, 10)
+	binaryConnected := make(chan bool, 1)
+	go helpers.ConnectToPanel(addr.String(), toPanel, fromPanel, ctx, &wg, func(errorMsg string, binary bool, _ net.Conn) { binaryConnected <- binary }, nil, &helpers.ConnectToPanelConfig{ProtocolMode: helpers.ProtocolNegotiate})
+	select {
+	case binary := <-binaryConnected:
+		if !binary {
+			t.Fatal("proxy did not offer binary to the negotiating system")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("binary system not connected")
+	}
+
+	// ASCII feedback, including multi-line graphics, reaches the binary panel:
+	image := make([]byte, 64*32/8)
+	for i := range image {
+		image[i] = byte(i)
+	}
+	gfxLines := helpers.InboundMessagesToRawPanelASCIIstrings([]*rwp.InboundMessage{{States: []*rwp.HWCState{{
+		HWCIDs: []uint32{1},
+		HWCGfx: &rwp.HWCGfx{ImageType: rwp.HWCGfx_MONO, W: 64, H: 32, ImageData: image},
+	}}}})
+	if len(gfxLines) < 2 {
+		t.Fatalf("expected a multi-line image, got %d lines", len(gfxLines))
+	}
+	if _, err := asciiConn.Write([]byte("HWCc#1=2\n" + strings.Join(gfxLines, "\n") + "\n")); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "feedback on the panel", func() bool {
+		state := emu.State(1)
+		return state.GetHWCColor() != nil && bytes.Equal(state.GetHWCGfx().GetImageData(), image)
+	})
+
+	// Events reach both systems in their encodings:
+	emu.Press(1, rwp.BinaryEvent_UNKNOWN)
+	timeout := time.After(5 * time.Second)
+	for gotBinary, gotASCII := false, false; !gotBinary || !gotASCII; {
+		select {
+		case msgs := <-fromPanel:
+			for _, msg := range msgs {
+				for _, event := range msg.Events {
+					gotBinary = gotBinary || (event.HWCID == 1 && event.Binary.GetPressed())
+				}
+			}
+		case line := <-asciiLines:
+			gotASCII = gotASCII || line == "HWC#1=Down"
+		case <-timeout:
+			t.Fatal("press not received by both systems")
+		}
+	}
+
+	// Pings are answered by the proxy:
+	if _, err := asciiConn.Write([]byte("ping\n")); err != nil {
+		t.Fatal(err)
+	}
+	timeout = time.After(5 * time.Second)
+	for gotACK := false; !gotACK; {
+		select {
+		case line := <-asciiLines:
+			gotACK = line == "ack"
+		case <-timeout:
+			t.Fatal("ping not answered")
+		}
+	}
+}
+
+// Two systems sharing a panel: The one with higher priority wins, the limit is enforced and Connections lists both
+func TestSharing(t *testing.T) {
+	top := &topology.Topology{
+		HWc: []topology.TopologyHWcomponent{
+			{Id: 1, X: 100, Y: 100, Txt: "Button 1", Type: 1},
+			{Id: 2, X: 200, Y: 100, Txt: "Button 2", Type: 1},
+		},
+		TypeIndex: map[uint32]topology.TopologyHWcTypeDef{
+			1: {W: 100, H: 100, Out: "rgb", In: "b"},
+		},
+	}
+	emu := emulator.New(top, &emulator.Config{Encoding: emulator.EncodingBinary})
+	defer emu.Close()
+	panelAddr, err := emu.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var priorities []int
+	var mu sync.Mutex
+	p := New(context.Background(), panelAddr.String(), &Config{
+		PanelConfig: &helpers.ConnectToPanelConfig{ProtocolMode: helpers.ProtocolBinary},
+		MaxClients:  2,
+		Priority: func(addr net.Addr) int { // Every system gets a higher priority than the previous one
+			mu.Lock()
+			defer mu.Unlock()
+			priorities = append(priorities, len(priorities)*10)
+			return priorities[len(priorities)-1]
+		},
+	})
+	defer p.Close()
+	addr, err := p.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "panel connection", func() bool {
+		connected, _ := p.PanelConnected()
+		return connected
+	})
+
+	connect := func(send string) (net.Conn, chan string) {
+		conn, err := net.Dial("tcp", addr.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := conn.Write([]byte(send)); err != nil {
+			t.Fatal(err)
+		}
+		lines := make(chan string, 100)
+		go func() {
+			scanner := bufio.NewScanner(conn)
+			for scanner.Scan() {
+				lines <- scanner.Text()
+			}
+			close(lines)
+		}()
+		return conn, lines
+	}
+	color := func(hwc uint32) rwp.ColorIndex_Colors {
+		return emu.State(hwc).GetHWCColor().GetColorIndex().GetIndex()
+	}
+
+	low, _ := connect("HWCc#1=130\n") // Color index 2
+	defer low.Close()
+	waitFor(t, "feedback of the first system", func() bool { return color(1) == 2 })
+
+	high, highLines := connect("HWCc#1=132\n")
+	defer high.Close()
+	waitFor(t, "feedback of the second system", func() bool { return color(1) == 4 })
+
+	// The lower priority system only gets through where the other one has no feedback:
+	low.Write([]byte("HWCc#1=133\nHWCc#2=133\n"))
+	waitFor(t, "feedback on HWC 2", func() bool { return color(2) == 5 })
+	if color(1) != 4 {
+		t.Fatalf("expected the higher priority system to be shown, got color %v", color(1))
+	}
+
+	// A third system is rejected:
+	rejected, rejectedLines := connect("")
+	defer rejected.Close()
+	select {
+	case line := <-rejectedLines:
+		if line != "ErrorMsg="+errorMsgMaxClients {
+			t.Fatalf("unexpected reply %q", line)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("third system not rejected")
+	}
+
+	// Clearing only clears the system's own feedback, and the brightness is layered as well:
+	high.Write([]byte("PanelBrightness=6\n"))
+	waitFor(t, "brightness of the second system", func() bool { return emu.Brightness().GetLEDs() == 6 })
+	low.Write([]byte("PanelBrightness=2\nClear\n"))
+	waitFor(t, "HWC 2 cleared", func() bool { return emu.State(2).GetHWCText().GetFormatting() == rwp.HWCText_FMT_HIDE })
+	if color(1) != 4 || emu.Brightness().GetLEDs() != 6 {
+		t.Fatalf("the first system cleared the feedback of the second, color %v, brightness %v", color(1), emu.Brightness())
+	}
+	low.Write([]byte("HWCc#1=133\n"))
+
+	// Connections lists the systems, not the proxy:
+	high.Write([]byte("Connections?\n"))
+	timeout := time.After(5 * time.Second)
+	for gotConnections := false; !gotConnections; {
+		select {
+		case line := <-highLines:
+			if strings.HasPrefix(line, "_connections=") {
+				if connections := strings.Split(strings.TrimPrefix(line, "_connections="), ";"); len(connections) != 2 {
+					t.Fatalf("expected two connections, got %q", line)
+				}
+				gotConnections = true
+			}
+		case <-timeout:
+			t.Fatal("no connections reply")
+		}
+	}
+
+	// The panel falls back to the remaining system:
+	high.Close()
+	waitFor(t, "fall back to the first system", func() bool { return color(1) == 5 && emu.Brightness().GetLEDs() == 2 })
+}
+
+// A system which stops reading is disconnected and doesn't hold up the others
+func TestStalledSystem(t *testing.T) {
+	emu := emulator.New(&topology.Topology{
+		HWc:       []topology.TopologyHWcomponent{{Id: 1, X: 100, Y: 100, Txt: "Button", Type: 1}},
+		TypeIndex: map[uint32]topology.TopologyHWcTypeDef{1: {W: 100, H: 100, Out: "rgb", In: "b"}},
+	}, &emulator.Config{Encoding: emulator.EncodingBinary})
+	defer emu.Close()
+	panelAddr, err := emu.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := New(context.Background(), panelAddr.String(), &Config{
+		PanelConfig:  &helpers.ConnectToPanelConfig{ProtocolMode: helpers.ProtocolBinary},
+		WriteTimeout: 100 * time.Millisecond,
+	})
+	defer p.Close()
+	waitFor(t, "panel connection", func() bool {
+		connected, _ := p.PanelConnected()
+		return connected
+	})
+
+	stalled, stalledProxySide := net.Pipe() // Writes to a pipe block until they are read
+	defer stalled.Close()
+	p.ServeConn(stalledProxySide)
+	go stalled.Write([]byte("ping\n"))
+
+	healthy, healthyProxySide := net.Pipe()
+	defer healthy.Close()
+	p.ServeConn(healthyProxySide)
+	go healthy.Write([]byte("ping\n"))
+	lines := make(chan string, 100)
+	go func() {
+		scanner := bufio.NewScanner(healthy)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+	waitFor(t, "both systems", func() bool { return p.ClientCount() == 2 })
+
+	for i := 0; i < 10; i++ {
+		emu.Press(1, rwp.BinaryEvent_UNKNOWN)
+		emu.Release(1, rwp.BinaryEvent_UNKNOWN)
+	}
+	events := 0
+	timeout := time.After(5 * time.Second)
+	for events < 20 {
+		select {
+		case line := <-lines:
+			if strings.HasPrefix(line, "HWC#1=") {
+				events++
+			}
+		case <-timeout:
+			t.Fatalf("healthy system received %d of 20 events", events)
+		}
+	}
+	waitFor(t, "stalled system disconnected", func() bool { return p.ClientCount() == 1 })
+}
@@ -3,6 +3,8 @@ package rawpanellib
 import (
 	"bufio"
 	"bytes"
+	"context"
+	"crypto/tls"
 	"encoding/base64"
 	"encoding/binary"
 	"encoding/json"
@@ -675,10 +677,32 @@ func (ar *ASCIIreader) Parse(inputString string) []*rwp.InboundMessage {
 	return nil
 }
 
+// Dials a panel on the network ("tcp", "unix" etc.). If tlsConfig is set, the connection is wrapped in TLS and the handshake is done before returning, so certificate errors show up here.
+// For mutual authentication, put the client certificate in tlsConfig.Certificates. If tlsConfig.ServerName is empty, it's taken from the address.
+// Use a Transport for other kinds of links.
+func DialPanel(network string, panelIPAndPort string, tlsConfig *tls.Config) (net.Conn, error) {
+	transport := &NetTransport{Network: network, Address: panelIPAndPort, TLSConfig: tlsConfig}
+	return transport.dial(context.Background())
+}
+
 // Is server mode panel ASCII or Binary? Test by sending a binary ping to the panel.
 // Background: Since it's possible that a panel auto detects binary or ascii protocol mode itself, it's best to probe with a binary package since otherwise a binary capable panel/system pair in auto mode would negotiate to use ASCII which is not as efficient and complete an encoding.
 // Returns true if binary panel. May hang for a few seconds waiting for reply
 func AutoDetectIfPanelEncodingIsBinary(c net.Conn, panelIPAndPort string) bool {
+	binaryPanel, _ := AutoDetectIfPanelEncodingIsBinaryContext(context.Background(), c, panelIPAndPort, 0)
+	return binaryPanel
+}
+
+// Same as AutoDetectIfPanelEncodingIsBinary, but waits at most timeout for the reply (0 means the default of 2 seconds).
+// If ctx is done while detecting, it returns right away with the context error. The connection should be closed in that case.
+func AutoDetectIfPanelEncodingIsBinaryContext(ctx context.Context, c net.Conn, panelIPAndPort string, timeout time.Duration) (bool, error) {
+	if timeout <= 0 {
+		timeout = DefaultDetectionTimeout
+	}
+	err := c.SetReadDeadline(time.Now().Add(timeout)) // Before abortOnDone, which must have the last word on deadlines
+	log.Should(err)
+	stopAbort := abortOnDone(ctx, c)
+	defer stopAbort()
 
 	// Is panel ASCII or Binary? Try by sending a binary ping to the panel.
 	// Background: Since it's possible that a panel auto detects binary or ascii protocol mode itself, it's better to probe with a Binary package since otherwise a binary capable panel/system pair in auto mode would negotiate to use ASCII which is not efficient.
@@ -692,37 +716,38 @@ func AutoDetectIfPanelEncodingIsBinary(c net.Conn, panelIPAndPort string) bool {
 	pbdata = append(header, pbdata...)                         // and concatenate it with the binary message
 	log.Debugln("Autodetecting binary / ascii mode of panel", panelIPAndPort, "by sending binary ping:", pbdata)
 
-	_, err = c.Write(pbdata) // Send "ping" and wait one second for a reply:
+	_, err = c.Write(pbdata) // Send "ping" and wait for a reply:
 	log.Should(err)
 	byteArray := make([]byte, 1000)
-	err = c.SetReadDeadline(time.Now().Add(2000 * time.Millisecond))
-	log.Should(err)
 
-	byteCount, err := c.Read(byteArray) // Should timeout after 2 seconds if ascii panel, otherwise respond promptly with an ACK message
+	byteCount, err := c.Read(byteArray) // Should timeout if ascii panel, otherwise respond promptly with an ACK message
+	if ctx.Err() != nil {
+		return false, ctx.Err()
+	}
 	if err != nil {
 		log.WithError(err).Debug("tried to connected in binarymode failed, trying asciimode...")
 		log.Infoln("Using ASCII Protocol Mode for panel", panelIPAndPort)
 		_, err = c.Write([]byte("\n")) // Clearing an ASCII panels buffer with a newline since we sent it binary stuff
 		log.Should(err)
-		return false
+		return false, nil
 	}
 
 	if byteCount >= 4 && (string(byteArray[0:4]) == "RDY\n" || string(byteArray[0:4]) == "map=") {
 		log.Debugln("Detected map or RDY message issued by UniSketch ASCII panels - we conclude ASCII")
 		_, err = c.Write([]byte("\n")) // Clearing an ASCII panels buffer with a newline since we sent it binary stuff
 		log.Should(err)
-		return false
+		return false, nil
 	}
 
 	if byteCount <= 4 {
 		log.Debugln("Unexpected reply length, staying with Binary Protocol Mode for panel ", panelIPAndPort, ". Reply was", byteArray[0:byteCount], string(byteArray[0:byteCount]))
-		return true
+		return true, nil
 	}
 
 	responsePayloadLength := binary.LittleEndian.Uint32(byteArray[0:4])
 	if responsePayloadLength+4 != uint32(byteCount) {
 		log.Debugln("Bytecount didn't match header, staying with Binary Protocol Mode for panel ", panelIPAndPort, ". Reply was", byteArray[0:byteCount], string(byteArray[0:byteCount]))
-		return true
+		return true, nil
 	}
 
 	reply := &rwp.OutboundMessage{}
@@ -735,7 +760,89 @@ func AutoDetectIfPanelEncodingIsBinary(c net.Conn, panelIPAndPort string) bool {
 		log.Debugln("Received something else than an ack response, staying with Binary Protocol Mode for panel ", panelIPAndPort)
 	}
 
-	return true // Default is binary
+	return true, nil // Default is binary
+}
+
+// Default time to wait for the reply to the binary ping when detecting the encoding of a panel
+const DefaultDetectionTimeout = 2 * time.Second
+
+// Sets the deadline of c to now when ctx is done, which aborts blocking reads and writes. Call the returned function when done waiting, before resetting deadlines
+func abortOnDone(ctx context.Context, c net.Conn) func() {
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		select {
+		case <-ctx.Done():
+			c.SetDeadline(time.Now())
+		case <-stop:
+		}
+	}()
+	return func() {
+		close(stop)
+		<-done
+	}
+}
+
+// Is a connecting system talking ASCII or Binary to us? This is the panel side counterpart of AutoDetectIfPanelEncodingIsBinary.
+// It peeks at the first bytes sent by the system without consuming them: A binary message starts with a 4 byte little endian length header
+// where the two upper bytes are zero for any reasonable message size, while ASCII lines never contain zero bytes.
+// Blocks until the system has sent something.
+func DetectIfSystemEncodingIsBinary(r *bufio.Reader) (bool, error) {
+	for {
+		_, err := r.Peek(1) // Wait for the first data
+		if err != nil {
+			return false, err
+		}
+		peekLen := r.Buffered()
+		if peekLen > 4 {
+			peekLen = 4
+		}
+		firstBytes, _ := r.Peek(peekLen)
+		if !bytes.Contains(firstBytes, []byte{0}) {
+			return false, nil
+		}
+		if peekLen == 4 {
+			return firstBytes[2] == 0 && firstBytes[3] == 0, nil
+		}
+
+		// A zero byte in a partial header, wait for the full header:
+		if _, err := r.Peek(4); err != nil {
+			return false, err
+		}
+	}
+}
+
+// Merges the feedback state src into dst the same way a panel applies consecutive states for the same HWC:
+// Fields set in src replace those in dst while unset fields are kept. Text, graphics and processors all define the display content, so the latest state setting any of them replaces all three (text and processors set together, as for TextToGraphics, stay together).
+// HWCIDs of dst are not touched and src is cloned, so it can be reused by the caller.
+func MergeHWCState(dst *rwp.HWCState, src *rwp.HWCState) {
+	if src.HWCMode != nil {
+		dst.HWCMode = proto.Clone(src.HWCMode).(*rwp.HWCMode)
+	}
+	if src.HWCColor != nil {
+		dst.HWCColor = proto.Clone(src.HWCColor).(*rwp.HWCColor)
+	}
+	if src.HWCExtended != nil {
+		dst.HWCExtended = proto.Clone(src.HWCExtended).(*rwp.HWCExtended)
+	}
+	if src.PublishRawADCValues != nil {
+		dst.PublishRawADCValues = proto.Clone(src.PublishRawADCValues).(*rwp.PublishRawADCValues)
+	}
+	if src.HWCText == nil && src.HWCGfx == nil && src.Processors == nil {
+		return
+	}
+
+	dst.HWCText, dst.HWCGfx, dst.Processors = nil, nil, nil
+	if src.HWCText != nil {
+		dst.HWCText = proto.Clone(src.HWCText).(*rwp.HWCText)
+	}
+	if src.HWCGfx != nil {
+		dst.HWCGfx = proto.Clone(src.HWCGfx).(*rwp.HWCGfx)
+	}
+	if src.Processors != nil {
+		dst.Processors = proto.Clone(src.Processors).(*rwp.Processors)
+	}
 }
 
 // Converts a monochrome byte slice back to image object
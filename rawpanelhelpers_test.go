@@ -0,0 +1,58 @@
+package rawpanellib
+
+import (
+	"testing"
+
+	rwp "github.com/SKAARHOJ/rawpanel-lib/ibeam_rawpanel"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestMergeHWCState(t *testing.T) {
+	text := &rwp.HWCText{Textline1: "Text"}
+	gfx := &rwp.HWCGfx{W: 64, H: 32, ImageData: make([]byte, 256)}
+	processors := &rwp.Processors{TextToGraphics: &rwp.ProcTextToGraphics{W: 64, H: 32}}
+	mode := &rwp.HWCMode{State: rwp.HWCMode_ON}
+
+	tests := []struct {
+		name   string
+		states []*rwp.HWCState
+		want   *rwp.HWCState
+	}{
+		{
+			"text and processors together",
+			[]*rwp.HWCState{{HWCText: text, Processors: processors}},
+			&rwp.HWCState{HWCText: text, Processors: processors},
+		},
+		{
+			"text and processors after graphics",
+			[]*rwp.HWCState{{HWCGfx: gfx}, {HWCText: text, Processors: processors}},
+			&rwp.HWCState{HWCText: text, Processors: processors},
+		},
+		{
+			"graphics after text",
+			[]*rwp.HWCState{{HWCText: text}, {HWCGfx: gfx}},
+			&rwp.HWCState{HWCGfx: gfx},
+		},
+		{
+			"text after graphics",
+			[]*rwp.HWCState{{HWCGfx: gfx}, {HWCText: text}},
+			&rwp.HWCState{HWCText: text},
+		},
+		{
+			"LED keeps the display",
+			[]*rwp.HWCState{{HWCText: text}, {HWCMode: mode}},
+			&rwp.HWCState{HWCText: text, HWCMode: mode},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := &rwp.HWCState{}
+			for _, state := range test.states {
+				MergeHWCState(got, state)
+			}
+			if !proto.Equal(got, test.want) {
+				t.Fatalf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
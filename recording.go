@@ -0,0 +1,415 @@
+package rawpanellib
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	rwp "github.com/SKAARHOJ/rawpanel-lib/ibeam_rawpanel"
+	log "github.com/s00500/env_logger"
+)
+
+// Recordings (.rwplog files) come in two forms:
+//
+// Binary: The header "RWPLOG\x00\x01", then one record per message: 8 bytes timestamp (unix nanoseconds),
+// 1 byte direction, 4 bytes payload length and the protobuf encoded InboundMessage or OutboundMessage. All integers are little endian.
+//
+// ASCII: The header line "#RWPLOG ascii", then one line per Raw Panel ASCII line: RFC3339 timestamp, ">" (to panel) or "<" (from panel) and the line.
+// It is easy to read and edit, but only holds what the ASCII protocol can express.
+const (
+	recordingMagic      = "RWPLOG\x00\x01"
+	recordingASCIIMagic = "#RWPLOG ascii"
+)
+
+var ErrBadRecording = errors.New("not a valid rwplog recording")
+
+// Format of a recording
+type RecordFormat int
+
+const (
+	RecordBinary RecordFormat = iota // Protobuf frames, lossless
+	RecordASCII                      // Raw Panel ASCII lines
+)
+
+// Direction of a recorded message
+type RecordDirection uint8
+
+const (
+	DirectionToPanel   RecordDirection = 1 // System -> Panel
+	DirectionFromPanel RecordDirection = 2 // Panel -> System
+)
+
+func (d RecordDirection) String() string {
+	switch d {
+	case DirectionToPanel:
+		return "to panel"
+	case DirectionFromPanel:
+		return "from panel"
+	}
+	return fmt.Sprintf("RecordDirection(%d)", int(d))
+}
+
+// A message read from a recording. Depending on the direction, either ToPanel or FromPanel is set
+type RecordedMessage struct {
+	Time      time.Time
+	Direction RecordDirection
+	ToPanel   *rwp.InboundMessage
+	FromPanel *rwp.OutboundMessage
+}
+
+// Writes the traffic of a panel connection to a recording. Set it in ConnectToPanelConfig or gorwp.ConnectConfig.
+// Safe for concurrent use. All methods can be called on a nil *Recorder, which records nothing.
+type Recorder struct {
+	sync.Mutex
+
+	w      io.Writer
+	closer io.Closer
+	format RecordFormat
+	err    error
+	now    func() time.Time
+}
+
+// Creates a recorder writing to w and writes the header
+func NewRecorder(w io.Writer, format RecordFormat) (*Recorder, error) {
+	rec := &Recorder{w: w, format: format, now: time.Now}
+	header := recordingMagic
+	if format == RecordASCII {
+		header = recordingASCIIMagic + "\n"
+	}
+	if _, err := io.WriteString(w, header); err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
+
+// Creates (or truncates) a recording file. Close the recorder to close the file
+func CreateRecording(path string, format RecordFormat) (*Recorder, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	rec, err := NewRecorder(f, format)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	rec.closer = f
+	return rec, nil
+}
+
+// Records messages sent to the panel
+func (rec *Recorder) RecordToPanel(msgs []*rwp.InboundMessage) {
+	if rec == nil || len(msgs) == 0 {
+		return
+	}
+	if rec.format == RecordASCII {
+		rec.writeASCII(DirectionToPanel, InboundMessagesToRawPanelASCIIstrings(msgs))
+		return
+	}
+	for _, msg := range msgs {
+		rec.writeBinary(DirectionToPanel, msg)
+	}
+}
+
+// Records messages received from the panel
+func (rec *Recorder) RecordFromPanel(msgs []*rwp.OutboundMessage) {
+	if rec == nil || len(msgs) == 0 {
+		return
+	}
+	if rec.format == RecordASCII {
+		rec.writeASCII(DirectionFromPanel, OutboundMessagesToRawPanelASCIIstrings(msgs))
+		return
+	}
+	for _, msg := range msgs {
+		rec.writeBinary(DirectionFromPanel, msg)
+	}
+}
+
+func (rec *Recorder) writeBinary(direction RecordDirection, msg proto.Message) {
+	data, err := proto.Marshal(msg)
+	if err != nil {
+		log.Should(err)
+		return
+	}
+
+	rec.Lock()
+	defer rec.Unlock()
+	record := make([]byte, 13, 13+len(data))
+	binary.LittleEndian.PutUint64(record[0:8], uint64(rec.now().UnixNano()))
+	record[8] = byte(direction)
+	binary.LittleEndian.PutUint32(record[9:13], uint32(len(data)))
+	rec.write(append(record, data...))
+}
+
+func (rec *Recorder) writeASCII(direction RecordDirection, lines []string) {
+	rec.Lock()
+	defer rec.Unlock()
+	marker := ">"
+	if direction == DirectionFromPanel {
+		marker = "<"
+	}
+	timestamp := rec.now().Format(time.RFC3339Nano)
+	var buf bytes.Buffer
+	for _, line := range lines {
+		fmt.Fprintf(&buf, "%s %s %s\n", timestamp, marker, strings.TrimSpace(line))
+	}
+	rec.write(buf.Bytes())
+}
+
+// Writes a record in one go. After the first error nothing more is written, since the file would be unreadable from there on anyway
+func (rec *Recorder) write(data []byte) {
+	if rec.err != nil {
+		return
+	}
+	if _, err := rec.w.Write(data); err != nil {
+		log.Errorln("Recording stopped:", err)
+		rec.err = err
+	}
+}
+
+// Returns the write error which stopped the recording, if any
+func (rec *Recorder) Err() error {
+	if rec == nil {
+		return nil
+	}
+	rec.Lock()
+	defer rec.Unlock()
+	return rec.err
+}
+
+// Closes the file if the recorder was created with CreateRecording. Later messages are not recorded
+func (rec *Recorder) Close() error {
+	if rec == nil {
+		return nil
+	}
+	rec.Lock()
+	defer rec.Unlock()
+	if rec.err == nil {
+		rec.err = os.ErrClosed
+	}
+	if rec.closer != nil {
+		return rec.closer.Close()
+	}
+	return nil
+}
+
+// Reads recordings of both forms
+type RecordingReader struct {
+	r       *bufio.Reader
+	closer  io.Closer
+	format  RecordFormat
+	pending []*RecordedMessage // ASCII lines can convert to more than one message
+}
+
+// Creates a reader and checks the header
+func NewRecordingReader(r io.Reader) (*RecordingReader, error) {
+	rr := &RecordingReader{r: bufio.NewReader(r)}
+	header, err := rr.r.Peek(len(recordingMagic))
+	if err != nil && len(header) == 0 {
+		return nil, ErrBadRecording
+	}
+	switch {
+	case string(header) == recordingMagic:
+		rr.r.Discard(len(recordingMagic))
+		rr.format = RecordBinary
+	case strings.HasPrefix(recordingASCIIMagic, string(header)):
+		line, _ := rr.r.ReadString('\n')
+		if strings.TrimSpace(line) != recordingASCIIMagic {
+			return nil, ErrBadRecording
+		}
+		rr.format = RecordASCII
+	default:
+		return nil, ErrBadRecording
+	}
+	return rr, nil
+}
+
+// Opens a recording file. Close the reader to close the file
+func OpenRecording(path string) (*RecordingReader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	rr, err := NewRecordingReader(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	rr.closer = f
+	return rr, nil
+}
+
+// Returns the format of the recording
+func (rr *RecordingReader) Format() RecordFormat {
+	return rr.format
+}
+
+// Returns the next message of the recording, or io.EOF at the end
+func (rr *RecordingReader) Next() (*RecordedMessage, error) {
+	if rr.format == RecordASCII {
+		return rr.nextASCII()
+	}
+	return rr.nextBinary()
+}
+
+func (rr *RecordingReader) nextBinary() (*RecordedMessage, error) {
+	header := make([]byte, 13)
+	if n, err := io.ReadFull(rr.r, header); err != nil {
+		if n == 0 && err == io.EOF {
+			return nil, io.EOF
+		}
+		return nil, fmt.Errorf("%w: truncated record header", ErrBadRecording)
+	}
+	size := binary.LittleEndian.Uint32(header[9:13])
+	if size > DefaultMaxFrameSize {
+		return nil, &FrameError{Kind: ErrFrameOversize, Size: size}
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(rr.r, data); err != nil {
+		return nil, &FrameError{Kind: ErrFrameTruncated, Size: size, Cause: err}
+	}
+
+	rm := &RecordedMessage{
+		Time:      time.Unix(0, int64(binary.LittleEndian.Uint64(header[0:8]))),
+		Direction: RecordDirection(header[8]),
+	}
+	var msg proto.Message
+	switch rm.Direction {
+	case DirectionToPanel:
+		rm.ToPanel = &rwp.InboundMessage{}
+		msg = rm.ToPanel
+	case DirectionFromPanel:
+		rm.FromPanel = &rwp.OutboundMessage{}
+		msg = rm.FromPanel
+	default:
+		return nil, fmt.Errorf("%w: unknown direction %d", ErrBadRecording, header[8])
+	}
+	if err := proto.Unmarshal(data, msg); err != nil {
+		return nil, &FrameError{Kind: ErrFrameDecode, Size: size, Cause: err}
+	}
+	return rm, nil
+}
+
+func (rr *RecordingReader) nextASCII() (*RecordedMessage, error) {
+	for len(rr.pending) == 0 {
+		line, err := rr.r.ReadString('\n')
+		if err != nil && (err != io.EOF || line == "") {
+			return nil, err
+		}
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") { // Comments can be added by hand
+			continue
+		}
+
+		fields := strings.SplitN(line, " ", 3)
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("%w: malformed line %q", ErrBadRecording, line)
+		}
+		timestamp, err := time.Parse(time.RFC3339Nano, fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrBadRecording, err)
+		}
+		switch fields[1] {
+		case ">":
+			for _, msg := range RawPanelASCIIstringsToInboundMessages([]string{fields[2]}) {
+				rr.pending = append(rr.pending, &RecordedMessage{Time: timestamp, Direction: DirectionToPanel, ToPanel: msg})
+			}
+		case "<":
+			for _, msg := range RawPanelASCIIstringsToOutboundMessages([]string{fields[2]}) {
+				rr.pending = append(rr.pending, &RecordedMessage{Time: timestamp, Direction: DirectionFromPanel, FromPanel: msg})
+			}
+		default:
+			return nil, fmt.Errorf("%w: unknown direction %q", ErrBadRecording, fields[1])
+		}
+	}
+	rm := rr.pending[0]
+	rr.pending = rr.pending[1:]
+	return rm, nil
+}
+
+// Closes the file if the reader was created with OpenRecording
+func (rr *RecordingReader) Close() error {
+	if rr.closer != nil {
+		return rr.closer.Close()
+	}
+	return nil
+}
+
+type ReplayConfig struct {
+	Speed     float64                      // Playback speed, 1 (default) is the original timing, 10 is ten times faster. Negative replays without delays
+	ToPanel   func([]*rwp.InboundMessage)  // Receives recorded messages to the panel (optional, skipped if nil)
+	FromPanel func([]*rwp.OutboundMessage) // Receives recorded messages from the panel (optional, skipped if nil)
+	KeepFlow  bool                         // Also replay pings and ACKs. They belong to the liveness of the recorded connection, so they are skipped by default
+}
+
+// Plays a recording back with its original timing (scaled by Speed), handing each message to the callbacks of the config.
+// It blocks until the end of the recording (returning nil) or until ctx is done.
+func Replay(ctx context.Context, rr *RecordingReader, config ReplayConfig) error {
+	speed := config.Speed
+	if speed == 0 {
+		speed = 1
+	}
+
+	var last time.Time
+	for {
+		rm, err := rr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if speed > 0 && !last.IsZero() && rm.Time.After(last) {
+			timer := time.NewTimer(time.Duration(float64(rm.Time.Sub(last)) / speed))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			case <-timer.C:
+			}
+		} else if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		last = rm.Time
+
+		switch {
+		case rm.ToPanel != nil && config.ToPanel != nil:
+			if config.KeepFlow || !isFlowOnly(rm.ToPanel) {
+				config.ToPanel([]*rwp.InboundMessage{rm.ToPanel})
+			}
+		case rm.FromPanel != nil && config.FromPanel != nil:
+			if config.KeepFlow || !isFlowOnly(rm.FromPanel) {
+				config.FromPanel([]*rwp.OutboundMessage{rm.FromPanel})
+			}
+		}
+	}
+}
+
+// True if the message has nothing but a flow message (ping, ACK, ...)
+func isFlowOnly(msg proto.Message) bool {
+	msg = proto.Clone(msg)
+	switch m := msg.(type) {
+	case *rwp.InboundMessage:
+		if m.FlowMessage == rwp.InboundMessage_NONE {
+			return false
+		}
+		m.FlowMessage = rwp.InboundMessage_NONE
+	case *rwp.OutboundMessage:
+		if m.FlowMessage == rwp.OutboundMessage_NONE {
+			return false
+		}
+		m.FlowMessage = rwp.OutboundMessage_NONE
+	}
+	return proto.Size(msg) == 0
+}
@@ -0,0 +1,124 @@
+package rawpanellib
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	rwp "github.com/SKAARHOJ/rawpanel-lib/ibeam_rawpanel"
+	"google.golang.org/protobuf/proto"
+)
+
+func testRecording(t *testing.T, format RecordFormat) *bytes.Buffer {
+	var buf bytes.Buffer
+	rec, err := NewRecorder(&buf, format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	rec.now = func() time.Time {
+		clock = clock.Add(10 * time.Millisecond)
+		return clock
+	}
+
+	rec.RecordToPanel([]*rwp.InboundMessage{{FlowMessage: rwp.InboundMessage_PING}})
+	rec.RecordToPanel([]*rwp.InboundMessage{{States: []*rwp.HWCState{{HWCIDs: []uint32{3}, HWCMode: &rwp.HWCMode{State: rwp.HWCMode_ON}}}}})
+	rec.RecordFromPanel([]*rwp.OutboundMessage{{Events: []*rwp.HWCEvent{{HWCID: 3, Binary: &rwp.BinaryEvent{Pressed: true}}}}})
+	if err := rec.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return &buf
+}
+
+func TestRecordingRoundTrip(t *testing.T) {
+	for _, format := range []RecordFormat{RecordBinary, RecordASCII} {
+		rr, err := NewRecordingReader(testRecording(t, format))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rr.Format() != format {
+			t.Fatalf("detected format %d, want %d", rr.Format(), format)
+		}
+
+		recorded := []*RecordedMessage{}
+		for {
+			rm, err := rr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			recorded = append(recorded, rm)
+		}
+		if len(recorded) != 3 {
+			t.Fatalf("format %d: read %d messages, want 3", format, len(recorded))
+		}
+		if recorded[0].ToPanel.GetFlowMessage() != rwp.InboundMessage_PING {
+			t.Errorf("format %d: first message is not a ping: %v", format, recorded[0].ToPanel)
+		}
+		if recorded[1].Direction != DirectionToPanel || recorded[1].ToPanel.States[0].HWCMode.State != rwp.HWCMode_ON {
+			t.Errorf("format %d: unexpected state %v", format, recorded[1].ToPanel)
+		}
+		want := &rwp.HWCEvent{HWCID: 3, Binary: &rwp.BinaryEvent{Pressed: true}}
+		if recorded[2].Direction != DirectionFromPanel || !proto.Equal(recorded[2].FromPanel.Events[0], want) {
+			t.Errorf("format %d: unexpected event %v", format, recorded[2].FromPanel)
+		}
+		if d := recorded[2].Time.Sub(recorded[0].Time); d != 20*time.Millisecond {
+			t.Errorf("format %d: timestamps %v apart, want 20ms", format, d)
+		}
+	}
+}
+
+func TestBadRecording(t *testing.T) {
+	if _, err := NewRecordingReader(bytes.NewBufferString("HWC#1=1\n")); !errors.Is(err, ErrBadRecording) {
+		t.Fatalf("expected ErrBadRecording, got %v", err)
+	}
+
+	data := testRecording(t, RecordBinary).Bytes()
+	rr, err := NewRecordingReader(bytes.NewReader(data[:len(data)-2]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr.Next()
+	rr.Next()
+	if _, err := rr.Next(); !errors.Is(err, ErrFrameTruncated) {
+		t.Fatalf("expected truncated frame, got %v", err)
+	}
+}
+
+func TestReplay(t *testing.T) {
+	rr, err := NewRecordingReader(testRecording(t, RecordBinary))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	toPanel := 0
+	fromPanel := 0
+	start := time.Now()
+	err = Replay(context.Background(), rr, ReplayConfig{
+		Speed:     0.5, // The 20ms recording takes 40ms
+		ToPanel:   func(msgs []*rwp.InboundMessage) { toPanel += len(msgs) },
+		FromPanel: func(msgs []*rwp.OutboundMessage) { fromPanel += len(msgs) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if toPanel != 1 || fromPanel != 1 {
+		t.Fatalf("replayed %d messages to and %d from the panel, want 1 each (ping skipped)", toPanel, fromPanel)
+	}
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+		t.Fatalf("replay took %v, want at least 40ms", elapsed)
+	}
+
+	// Cancelled:
+	rr, _ = NewRecordingReader(testRecording(t, RecordBinary))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := Replay(ctx, rr, ReplayConfig{Speed: -1}); err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+}
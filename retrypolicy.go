@@ -0,0 +1,65 @@
+package rawpanellib
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Decides how long ConnectToPanel waits before the next connection attempt.
+// Attempt is 1 for the first retry after a failed dial or a disconnect and counts up until a connection succeeds.
+// Returning false stops ConnectToPanel.
+type RetryPolicy interface {
+	NextDelay(attempt int) (time.Duration, bool)
+}
+
+// Retries with a fixed delay (this is how NoConnectionRetryPeriod is applied)
+type FixedRetryPolicy struct {
+	Delay       time.Duration
+	MaxAttempts int // Give up after this many retries. 0 is unlimited
+}
+
+func (p *FixedRetryPolicy) NextDelay(attempt int) (time.Duration, bool) {
+	if p.MaxAttempts > 0 && attempt > p.MaxAttempts {
+		return 0, false
+	}
+	return p.Delay, true
+}
+
+// Retries with exponential backoff and jitter, so many clients losing their panels at the same time don't retry in lockstep
+type BackoffRetryPolicy struct {
+	InitialDelay time.Duration // Delay before the first retry, default 1 second
+	MaxDelay     time.Duration // Upper limit of the delay (before jitter), default 30 seconds
+	Multiplier   float64       // Factor applied to the delay for every attempt, default 2
+	Jitter       float64       // Fraction (0-1) of the delay which is randomized. 0.5 gives delays between 50% and 100% of the computed value
+	MaxAttempts  int           // Give up after this many retries. 0 is unlimited
+}
+
+func (p *BackoffRetryPolicy) NextDelay(attempt int) (time.Duration, bool) {
+	if p.MaxAttempts > 0 && attempt > p.MaxAttempts {
+		return 0, false
+	}
+
+	initialDelay := p.InitialDelay
+	if initialDelay <= 0 {
+		initialDelay = time.Second
+	}
+	maxDelay := p.MaxDelay
+	if maxDelay <= 0 {
+		maxDelay = 30 * time.Second
+	}
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = 2
+	}
+
+	delay := float64(initialDelay) * math.Pow(multiplier, float64(attempt-1))
+	if delay > float64(maxDelay) {
+		delay = float64(maxDelay)
+	}
+
+	jitter := math.Min(math.Max(p.Jitter, 0), 1)
+	delay -= delay * jitter * rand.Float64()
+
+	return time.Duration(delay), true
+}
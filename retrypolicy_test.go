@@ -0,0 +1,32 @@
+package rawpanellib
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoffRetryPolicy(t *testing.T) {
+	policy := &BackoffRetryPolicy{
+		InitialDelay: 100 * time.Millisecond,
+		MaxDelay:     time.Second,
+		MaxAttempts:  6,
+	}
+	want := []time.Duration{100, 200, 400, 800, 1000, 1000}
+	for i, w := range want {
+		delay, ok := policy.NextDelay(i + 1)
+		if !ok || delay != w*time.Millisecond {
+			t.Fatalf("attempt %d: got %v %v, want %v", i+1, delay, ok, w*time.Millisecond)
+		}
+	}
+	if _, ok := policy.NextDelay(7); ok {
+		t.Fatal("expected policy to give up after MaxAttempts")
+	}
+
+	policy.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		delay, _ := policy.NextDelay(3)
+		if delay < 200*time.Millisecond || delay > 400*time.Millisecond {
+			t.Fatalf("jittered delay %v out of range", delay)
+		}
+	}
+}
@@ -0,0 +1,218 @@
+package rawpanellib
+
+import (
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	rwp "github.com/SKAARHOJ/rawpanel-lib/ibeam_rawpanel"
+)
+
+type SendSchedulerConfig struct {
+	MaxFlushesPerSecond int // Rate limit for writes to the panel. Everything queued between two writes is coalesced. 0 is unlimited
+	MaxPending          int // Max number of queued messages which cannot be coalesced (commands, partial graphics...) before we stop reading more messages, default 100
+}
+
+type SendSchedulerStats struct {
+	Pushed    uint64 // States and commands queued
+	Coalesced uint64 // States merged into a pending state for the same HWC
+	Flushes   uint64 // Writes to the panel
+}
+
+// Max payload of states packed into one InboundMessage when flushing. Keeps us well below what panels accept per message
+const sendSchedulerMaxBatchSize = 64 * 1024
+
+// Queues messages for a panel and merges pending HWCState feedback per HWC, so only the newest mode, color, text, graphics etc. is written.
+// Commands, flow messages and registers are never merged. They keep their order relative to states, so a state sent after a ClearAll is never merged into one sent before it.
+// It doesn't run any goroutines; the connection writer pushes to it, asks it when to flush and writes what Flush returns.
+// Create it with NewSendScheduler and put it in the client config. Statistics are kept across reconnects.
+type SendScheduler struct {
+	sync.Mutex
+
+	config      SendSchedulerConfig
+	entries     []*scheduledEntry
+	slots       map[uint32]*scheduledEntry // Pending state per HWC which can still be merged into (since the last barrier)
+	uncoalesced int
+	lastFlush   time.Time
+	stats       SendSchedulerStats
+}
+
+// Either a single HWC state (mergeable) or a message which is sent as is
+type scheduledEntry struct {
+	state   *rwp.HWCState
+	message *rwp.InboundMessage
+}
+
+func NewSendScheduler(config *SendSchedulerConfig) *SendScheduler {
+	ss := &SendScheduler{
+		slots: make(map[uint32]*scheduledEntry),
+	}
+	if config != nil {
+		ss.config = *config
+	}
+	if ss.config.MaxPending <= 0 {
+		ss.config.MaxPending = 100
+	}
+	return ss
+}
+
+// Queues messages. Never blocks, so check Full before reading more messages to apply backpressure
+func (ss *SendScheduler) Push(msgs []*rwp.InboundMessage) {
+	ss.Lock()
+	defer ss.Unlock()
+
+	for _, msg := range msgs {
+		if msg.FlowMessage != rwp.InboundMessage_NONE || msg.Command != nil || len(msg.Registers) > 0 {
+			barrier := &rwp.InboundMessage{
+				FlowMessage: msg.FlowMessage,
+				Command:     msg.Command,
+				Registers:   msg.Registers,
+			}
+			ss.appendUncoalesced(&scheduledEntry{message: barrier})
+			ss.slots = make(map[uint32]*scheduledEntry) // States after this must not be merged into states before it
+		}
+
+		for _, state := range msg.States {
+			ss.stats.Pushed++
+
+			// Partial graphics updates depend on what is already on the display, and states without HWCs are unusual, so both are sent as they are:
+			if len(state.HWCIDs) == 0 || (state.HWCGfx != nil && state.HWCGfx.XYoffset) {
+				ss.appendUncoalesced(&scheduledEntry{message: &rwp.InboundMessage{States: []*rwp.HWCState{state}}})
+				for _, hwc := range state.HWCIDs {
+					delete(ss.slots, hwc)
+				}
+				continue
+			}
+
+			for _, hwc := range state.HWCIDs {
+				if slot, exists := ss.slots[hwc]; exists {
+					MergeHWCState(slot.state, state)
+					ss.stats.Coalesced++
+				} else {
+					slot := &scheduledEntry{state: &rwp.HWCState{HWCIDs: []uint32{hwc}}}
+					MergeHWCState(slot.state, state)
+					ss.slots[hwc] = slot
+					ss.entries = append(ss.entries, slot)
+				}
+			}
+		}
+	}
+}
+
+func (ss *SendScheduler) appendUncoalesced(entry *scheduledEntry) {
+	ss.entries = append(ss.entries, entry)
+	ss.uncoalesced++
+	ss.stats.Pushed++
+}
+
+// True if no more messages should be pushed until the next flush
+func (ss *SendScheduler) Full() bool {
+	ss.Lock()
+	defer ss.Unlock()
+	return ss.uncoalesced >= ss.config.MaxPending
+}
+
+// True if there is something to flush
+func (ss *SendScheduler) Pending() bool {
+	ss.Lock()
+	defer ss.Unlock()
+	return len(ss.entries) > 0
+}
+
+// Time to wait before the next flush is allowed by the rate limit
+func (ss *SendScheduler) FlushDelay() time.Duration {
+	ss.Lock()
+	defer ss.Unlock()
+	if ss.config.MaxFlushesPerSecond <= 0 {
+		return 0
+	}
+	delay := time.Until(ss.lastFlush.Add(time.Second / time.Duration(ss.config.MaxFlushesPerSecond)))
+	if delay < 0 {
+		return 0
+	}
+	return delay
+}
+
+// Takes everything pending and returns it as messages to write, in order. Consecutive states are packed into as few messages as possible
+func (ss *SendScheduler) Flush() []*rwp.InboundMessage {
+	ss.Lock()
+	defer ss.Unlock()
+
+	if len(ss.entries) == 0 {
+		return nil
+	}
+
+	msgs := []*rwp.InboundMessage{}
+	var batch *rwp.InboundMessage
+	batchSize := 0
+	for _, entry := range ss.entries {
+		if entry.message != nil {
+			msgs = append(msgs, entry.message)
+			batch = nil
+			continue
+		}
+		stateSize := proto.Size(entry.state)
+		if batch == nil || batchSize+stateSize > sendSchedulerMaxBatchSize {
+			batch = &rwp.InboundMessage{}
+			batchSize = 0
+			msgs = append(msgs, batch)
+		}
+		batch.States = append(batch.States, entry.state)
+		batchSize += stateSize
+	}
+
+	ss.entries = nil
+	ss.slots = make(map[uint32]*scheduledEntry)
+	ss.uncoalesced = 0
+	ss.lastFlush = time.Now()
+	ss.stats.Flushes++
+
+	return msgs
+}
+
+// Drops everything pending, e.g. when a new connection is made. Statistics are kept
+func (ss *SendScheduler) Reset() {
+	ss.Lock()
+	defer ss.Unlock()
+	ss.entries = nil
+	ss.slots = make(map[uint32]*scheduledEntry)
+	ss.uncoalesced = 0
+}
+
+// Returns a copy of the statistics
+func (ss *SendScheduler) Stats() SendSchedulerStats {
+	ss.Lock()
+	defer ss.Unlock()
+	return ss.stats
+}
+
+// Timer for the next flush of a scheduler, for use in a select loop. The zero value is ready to use
+type FlushTimer struct {
+	timer *time.Timer
+}
+
+// Returns a channel which fires when the scheduler may be flushed, or a nil channel if nothing is pending (or ss is nil)
+func (ft *FlushTimer) C(ss *SendScheduler) <-chan time.Time {
+	if ss == nil || !ss.Pending() {
+		return nil
+	}
+	if ft.timer == nil {
+		ft.timer = time.NewTimer(ss.FlushDelay())
+	} else {
+		if !ft.timer.Stop() {
+			select {
+			case <-ft.timer.C:
+			default:
+			}
+		}
+		ft.timer.Reset(ss.FlushDelay())
+	}
+	return ft.timer.C
+}
+
+func (ft *FlushTimer) Stop() {
+	if ft.timer != nil {
+		ft.timer.Stop()
+	}
+}
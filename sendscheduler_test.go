@@ -0,0 +1,52 @@
+package rawpanellib
+
+import (
+	"testing"
+
+	rwp "github.com/SKAARHOJ/rawpanel-lib/ibeam_rawpanel"
+)
+
+func TestSendSchedulerCoalescing(t *testing.T) {
+	ss := NewSendScheduler(&SendSchedulerConfig{MaxPending: 3})
+
+	ss.Push([]*rwp.InboundMessage{
+		{States: []*rwp.HWCState{{HWCIDs: []uint32{1, 2}, HWCMode: &rwp.HWCMode{State: rwp.HWCMode_ON}}}},
+		{States: []*rwp.HWCState{{HWCIDs: []uint32{1}, HWCText: &rwp.HWCText{Title: "old"}}}},
+		{States: []*rwp.HWCState{{HWCIDs: []uint32{1}, HWCText: &rwp.HWCText{Title: "new"}}}},
+		{Command: &rwp.Command{ClearAll: true}},
+		{States: []*rwp.HWCState{{HWCIDs: []uint32{2}, HWCMode: &rwp.HWCMode{State: rwp.HWCMode_OFF}}}},
+		{States: []*rwp.HWCState{{HWCIDs: []uint32{2}, HWCGfx: &rwp.HWCGfx{XYoffset: true, W: 8, H: 8}}}},
+	})
+	if ss.Full() {
+		t.Fatal("scheduler should not be full with two uncoalesced messages")
+	}
+
+	msgs := ss.Flush()
+	if len(msgs) != 4 {
+		t.Fatalf("expected 4 messages, got %d: %v", len(msgs), msgs)
+	}
+	if len(msgs[0].States) != 2 || msgs[0].States[0].HWCText.GetTitle() != "new" || msgs[0].States[0].HWCMode.GetState() != rwp.HWCMode_ON {
+		t.Fatalf("states before the command were not merged: %v", msgs[0])
+	}
+	if !msgs[1].Command.GetClearAll() {
+		t.Fatalf("expected ClearAll as second message, got %v", msgs[1])
+	}
+	if len(msgs[2].States) != 1 || msgs[2].States[0].HWCMode.GetState() != rwp.HWCMode_OFF {
+		t.Fatalf("state after the command was merged across it: %v", msgs[2])
+	}
+	if msgs[3].States[0].HWCGfx == nil || !msgs[3].States[0].HWCGfx.XYoffset {
+		t.Fatalf("partial graphics should be sent as is: %v", msgs[3])
+	}
+
+	stats := ss.Stats()
+	if stats.Coalesced != 2 || stats.Flushes != 1 || ss.Pending() {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+
+	for i := 0; i < 3; i++ {
+		ss.Push([]*rwp.InboundMessage{{Command: &rwp.Command{SendPanelInfo: true}}})
+	}
+	if !ss.Full() {
+		t.Fatal("scheduler should be full after MaxPending commands")
+	}
+}
@@ -0,0 +1,26 @@
+package rawpanellib_test
+
+import (
+	"testing"
+
+	helpers "github.com/SKAARHOJ/rawpanel-lib"
+	"github.com/SKAARHOJ/rawpanel-lib/emulator"
+	rwp "github.com/SKAARHOJ/rawpanel-lib/ibeam_rawpanel"
+	"github.com/SKAARHOJ/rawpanel-lib/internal/testpanel"
+)
+
+func TestMutualTLS(t *testing.T) {
+	serverTLS, clientTLS := testpanel.NewPKI(t).MutualTLS(t)
+	emu, addr := testpanel.Start(t, &emulator.Config{TLSConfig: serverTLS})
+
+	// Autodetecting binary on top of TLS:
+	session := testpanel.RunConnectToPanel(t, addr, &helpers.ConnectToPanelConfig{TLSConfig: clientTLS})
+	if !session.WaitConnected(t) {
+		t.Fatal("expected binary mode")
+	}
+	session.ToPanel <- []*rwp.InboundMessage{{States: []*rwp.HWCState{{HWCIDs: []uint32{1}, HWCMode: &rwp.HWCMode{State: rwp.HWCMode_ON}}}}}
+	testpanel.WaitFor(t, "feedback state", func() bool {
+		state := emu.State(1)
+		return state != nil && state.HWCMode != nil && state.HWCMode.State == rwp.HWCMode_ON
+	})
+}
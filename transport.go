@@ -0,0 +1,90 @@
+package rawpanellib
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+)
+
+// Unix socket of the hardware server on SKAARHOJ devices (what gorwp connects to for "native" and "host")
+const HostSocketPath = "/var/ibeam/sockets/ibeam-hardware.socket"
+
+// Opens the byte stream to a panel. Binary/ASCII detection, framing and the protocol run on top of it, so any link carrying the Raw Panel stream can be plugged in: serial ports, WebSocket tunnels, SSH port forwards etc.
+// If Dial returns a net.Conn, it's used directly. Anything else is bridged to a net.Conn so read deadlines work for protocol detection.
+type Transport interface {
+	Dial(ctx context.Context) (io.ReadWriteCloser, error)
+}
+
+// Adapter to use a function as a Transport
+type TransportFunc func(ctx context.Context) (io.ReadWriteCloser, error)
+
+func (f TransportFunc) Dial(ctx context.Context) (io.ReadWriteCloser, error) {
+	return f(ctx)
+}
+
+// Dials on a network, optionally with TLS
+type NetTransport struct {
+	Network   string      // "tcp", "unix" etc. Default "tcp"
+	Address   string      // IP:port or socket path
+	TLSConfig *tls.Config // Wraps the connection in TLS if set
+	Dialer    *net.Dialer // Dialer to use, e.g. for timeouts or a local address (optional)
+}
+
+func (nt *NetTransport) Dial(ctx context.Context) (io.ReadWriteCloser, error) {
+	return nt.dial(ctx)
+}
+
+func (nt *NetTransport) dial(ctx context.Context) (net.Conn, error) {
+	network := nt.Network
+	if network == "" {
+		network = "tcp"
+	}
+	dialer := nt.Dialer
+	if dialer == nil {
+		dialer = &net.Dialer{}
+	}
+	if nt.TLSConfig != nil {
+		tlsDialer := &tls.Dialer{NetDialer: dialer, Config: nt.TLSConfig}
+		return tlsDialer.DialContext(ctx, network, nt.Address)
+	}
+	return dialer.DialContext(ctx, network, nt.Address)
+}
+
+// Connects to a unix socket, for example HostSocketPath
+func UnixTransport(path string) Transport {
+	return &NetTransport{Network: "unix", Address: path}
+}
+
+// Connects through an in-memory net.Pipe. The panel end of the pipe is passed to serve for every dial, e.g. the ServeConn method of an emulator.
+// Serve is called on its own goroutine, so it may serve the connection until it is closed.
+func PipeTransport(serve func(conn net.Conn)) Transport {
+	return TransportFunc(func(ctx context.Context) (io.ReadWriteCloser, error) {
+		system, panel := net.Pipe()
+		go serve(panel)
+		return system, nil
+	})
+}
+
+// Dials a transport and returns the stream as a net.Conn
+func DialTransport(ctx context.Context, transport Transport) (net.Conn, error) {
+	rwc, err := transport.Dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if conn, ok := rwc.(net.Conn); ok {
+		return conn, nil
+	}
+
+	// Bridge through a pipe. Closing the returned conn closes the stream and vice versa
+	local, remote := net.Pipe()
+	go func() {
+		io.Copy(remote, rwc)
+		remote.Close()
+	}()
+	go func() {
+		io.Copy(rwc, remote)
+		rwc.Close()
+	}()
+	return local, nil
+}
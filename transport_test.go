@@ -0,0 +1,69 @@
+package rawpanellib_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	helpers "github.com/SKAARHOJ/rawpanel-lib"
+	"github.com/SKAARHOJ/rawpanel-lib/emulator"
+	rwp "github.com/SKAARHOJ/rawpanel-lib/ibeam_rawpanel"
+	"github.com/SKAARHOJ/rawpanel-lib/internal/testpanel"
+)
+
+// Hides the net.Conn methods, like a serial port or tunnel would
+type plainStream struct {
+	io.ReadWriteCloser
+}
+
+func TestTransportFunc(t *testing.T) {
+	emu := emulator.New(testpanel.Topology(), nil)
+	t.Cleanup(emu.Close)
+
+	transport := helpers.TransportFunc(func(ctx context.Context) (io.ReadWriteCloser, error) {
+		system, panel := net.Pipe()
+		emu.ServeConn(panel)
+		return plainStream{system}, nil
+	})
+	session := testpanel.RunConnectToPanel(t, "emulator", &helpers.ConnectToPanelConfig{Transport: transport})
+	if !session.WaitConnected(t) {
+		t.Fatal("expected binary mode")
+	}
+	session.ToPanel <- []*rwp.InboundMessage{{States: []*rwp.HWCState{{HWCIDs: []uint32{3}, HWCMode: &rwp.HWCMode{State: rwp.HWCMode_ON}}}}}
+	testpanel.WaitFor(t, "feedback state", func() bool {
+		state := emu.State(3)
+		return state != nil && state.HWCMode != nil && state.HWCMode.State == rwp.HWCMode_ON
+	})
+	session.Stop()
+
+	testpanel.WaitFor(t, "client to disconnect", func() bool {
+		return emu.ClientCount() == 0
+	})
+}
+
+// Serve may keep the panel end of the pipe until the connection is closed
+func TestPipeTransportBlockingServe(t *testing.T) {
+	served := make(chan bool)
+	transport := helpers.PipeTransport(func(conn net.Conn) {
+		defer close(served)
+		conn.Read(make([]byte, 1)) // Until the system closes its end
+	})
+
+	dialed := make(chan io.ReadWriteCloser)
+	go func() {
+		rwc, err := transport.Dial(context.Background())
+		if err != nil {
+			t.Error(err)
+		}
+		dialed <- rwc
+	}()
+	select {
+	case rwc := <-dialed:
+		rwc.Close()
+	case <-time.After(5 * time.Second):
+		t.Fatal("Dial waits for serve")
+	}
+	<-served
+}